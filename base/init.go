@@ -43,6 +43,9 @@ import (
 	"gitlab.com/pantacor/pantahub-base/objects"
 	"gitlab.com/pantacor/pantahub-base/plog"
 	"gitlab.com/pantacor/pantahub-base/profiles"
+	"gitlab.com/pantacor/pantahub-base/releases"
+	"gitlab.com/pantacor/pantahub-base/rollouts"
+	"gitlab.com/pantacor/pantahub-base/signing"
 	"gitlab.com/pantacor/pantahub-base/subscriptions"
 	"gitlab.com/pantacor/pantahub-base/tokens"
 	"gitlab.com/pantacor/pantahub-base/trails"
@@ -137,13 +140,23 @@ func DoInit() {
 		http.Handle("/trails/", http.StripPrefix("/trails", app.API.MakeHandler()))
 	}
 	{
-		app := plog.New(defaultJwtMiddleware, mongoClient)
-		http.Handle("/plog/", http.StripPrefix("/plog", app.API.MakeHandler()))
+		app := rollouts.New(defaultJwtMiddleware, mongoClient)
+		http.Handle("/rollouts/", http.StripPrefix("/rollouts", app.API.MakeHandler()))
+	}
+	{
+		app := releases.New(defaultJwtMiddleware, mongoClient)
+		http.Handle("/releases/", http.StripPrefix("/releases", app.API.MakeHandler()))
 	}
 	{
-		app := logs.New(defaultJwtMiddleware, mongoClient)
-		http.Handle("/logs/", http.StripPrefix("/logs", app.API.MakeHandler()))
+		app := signing.New(defaultJwtMiddleware, mongoClient)
+		http.Handle("/signing/", http.StripPrefix("/signing", app.API.MakeHandler()))
+	}
+	{
+		app := plog.New(defaultJwtMiddleware, mongoClient)
+		http.Handle("/plog/", http.StripPrefix("/plog", app.API.MakeHandler()))
 	}
+	logsApp := logs.New(defaultJwtMiddleware, mongoClient)
+	http.Handle("/logs/", http.StripPrefix("/logs", logsApp.API.MakeHandler()))
 	{
 		app := healthz.New(mongoClient)
 		http.Handle("/healthz/", http.StripPrefix("/healthz", app.API.MakeHandler()))
@@ -183,7 +196,7 @@ func DoInit() {
 		http.Handle("/callbacks/", http.StripPrefix("/callbacks", app.API.MakeHandler()))
 	}
 	{
-		app := exports.New(defaultJwtMiddleware, mongoClient)
+		app := exports.New(defaultJwtMiddleware, mongoClient, logsApp)
 		http.Handle("/exports/", http.StripPrefix("/exports", app.API.MakeHandler()))
 	}
 	{
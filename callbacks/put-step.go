@@ -30,6 +30,7 @@ import (
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/trails/trailservices"
 	"gitlab.com/pantacor/pantahub-base/utils"
 )
 
@@ -85,6 +86,13 @@ func (a *App) handlePutStep(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
+	trailService := trailservices.CreateService(a.mongoClient, utils.MongoDb)
+	rerr := trailService.ResolveRelease(r.Context(), &step)
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
+
 	var publicStep PublicStep
 	var hasPublicStep bool
 
@@ -28,6 +28,7 @@ import (
 	"github.com/ant0ine/go-json-rest/rest"
 	"gitlab.com/pantacor/pantahub-base/callbacks"
 	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/trails/trailservices"
 	"gitlab.com/pantacor/pantahub-base/utils"
 )
 
@@ -52,6 +53,7 @@ func (a *App) handlePutSteps(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 	callbackApp := callbacks.Build(a.mongoClient)
+	trailService := trailservices.CreateService(a.mongoClient, utils.MongoDb)
 
 	findOptions := options.Find()
 	findOptions.SetNoCursorTimeout(true)
@@ -78,6 +80,12 @@ func (a *App) handlePutSteps(w rest.ResponseWriter, r *rest.Request) {
 			return
 		}
 
+		rerr := trailService.ResolveRelease(r.Context(), &step)
+		if rerr != nil {
+			utils.RestErrorWrite(w, rerr)
+			return
+		}
+
 		var publicStep callbacks.PublicStep
 
 		err = callbackApp.FindPublicStep(r.Context(), step.ID, &publicStep)
@@ -0,0 +1,51 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package cron
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"gitlab.com/pantacor/pantahub-base/trails"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// handlePutRetention Api to expire old steps of all trails
+// @Summary Api to expire old steps of all trails
+// @Description Mark the steps of every trail that expired under its retention policy
+// @Description (or the subscription defaults of its owner) as garbage and drop their
+// @Description progress history. Objects get collected by pantahub-gc.
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags trails
+// @Success 200 {array} trailmodels.RetentionResult
+// @Failure 500 {object} utils.RError
+// @Router /cron/retention [put]
+func (a *App) handlePutRetention(w rest.ResponseWriter, r *rest.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), a.CronJobTimeout)
+	defer cancel()
+
+	response, err := trails.Build(a.mongoClient).ProcessRetention(ctx)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error processing retention: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(response)
+}
@@ -0,0 +1,50 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package cron
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"gitlab.com/pantacor/pantahub-base/rollouts"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// handlePutRollouts Api to advance all running rollouts
+// @Summary Api to advance all running rollouts
+// @Description Evaluate the current wave of every running rollout and start the
+// @Description next wave, halt or finish the rollout accordingly
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags rollouts
+// @Success 200 {array} rollouts.ProcessResult
+// @Failure 500 {object} utils.RError
+// @Router /cron/rollouts [put]
+func (a *App) handlePutRollouts(w rest.ResponseWriter, r *rest.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), a.CronJobTimeout)
+	defer cancel()
+
+	response, err := rollouts.Build(a.mongoClient).ProcessRollouts(ctx)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error processing rollouts: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(response)
+}
@@ -0,0 +1,50 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package cron
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"gitlab.com/pantacor/pantahub-base/trails"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// handlePutSummaries Api to rebuild the device summaries
+// @Summary Api to rebuild the device summaries
+// @Description Rebuild the summary of every device from its device, trail and steps
+// @Description documents and remove the summaries of devices that do not exist anymore
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags trails
+// @Success 200 {object} trailmodels.SummaryRebuildResult
+// @Failure 500 {object} utils.RError
+// @Router /cron/summaries [put]
+func (a *App) handlePutSummaries(w rest.ResponseWriter, r *rest.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), a.CronJobTimeout)
+	defer cancel()
+
+	response, err := trails.Build(a.mongoClient).RebuildTrailSummaries(ctx)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error rebuilding summaries: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(response)
+}
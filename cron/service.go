@@ -97,6 +97,9 @@ func New(jwtMiddleware *jwt.JWTMiddleware,
 	apiRouter, _ := rest.MakeRouter(
 		rest.Put("/public/devices", app.handlePutDevices),
 		rest.Put("/public/steps", app.handlePutSteps),
+		rest.Put("/rollouts", app.handlePutRollouts),
+		rest.Put("/retention", app.handlePutRetention),
+		rest.Put("/summaries", app.handlePutSummaries),
 	)
 	app.API.Use(&tracer.OtelMiddleware{
 		ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
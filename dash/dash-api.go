@@ -83,7 +83,7 @@ func (a *App) handleGetSummary(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
-	summaryCol := a.mongoClient.Database("pantabase_devicesummary").Collection("device_summary_short_new_v2")
+	summaryCol := a.mongoClient.Database(trailmodels.SummaryDatabase).Collection(trailmodels.SummaryCollection)
 	if summaryCol == nil {
 		utils.RestErrorWrapper(w, "Error with Database connectivity (summaryCol)", http.StatusInternalServerError)
 		return
@@ -29,11 +29,27 @@ import (
 	"gitlab.com/pantacor/pantahub-base/profiles"
 	"gitlab.com/pantacor/pantahub-base/utils"
 	"gitlab.com/pantacor/pantahub-base/utils/mongoutils"
+	"gitlab.com/pantacor/pantahub-base/utils/querymongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"gopkg.in/mgo.v2/bson"
 )
 
+// deviceFilterSchema fields of Device that filters can use
+var deviceFilterSchema = &querymongo.FilterSchema{
+	Fields: map[string]querymongo.FilterField{
+		"prn":           {Key: "prn", Type: querymongo.FilterString},
+		"nick":          {Key: "nick", Type: querymongo.FilterString},
+		"public":        {Key: "ispublic", Type: querymongo.FilterBool},
+		"time-created":  {Key: "timecreated", Type: querymongo.FilterTime},
+		"time-modified": {Key: "timemodified", Type: querymongo.FilterTime},
+	},
+	Maps: map[string]string{
+		"user-meta.":   "user-meta.",
+		"device-meta.": "device-meta.",
+	},
+}
+
 // handleGetDevices Get all accounts devices
 // @Summary Get all accounts devices
 // Get Any user's public devices by using owner/ owner-nick params
@@ -49,6 +65,7 @@ import (
 // @Tags devices
 // @Param owner-nick query string false "Owner nick"
 // @Param owner query string false "Owner PRN"
+// @Param filter query string false "Filter expression, e.g. device-meta.pantavisor.arch ^= aarch64 and time-modified >= now-7d"
 // @Success 200 {array} Device
 // @Failure 400 {object} utils.RError
 // @Failure 404 {object} utils.RError
@@ -133,8 +150,17 @@ func (a *App) handleGetDevices(w rest.ResponseWriter, r *rest.Request) {
 		}
 	}
 
+	if filterParam := r.URL.Query().Get("filter"); filterParam != "" {
+		filter, err := querymongo.ParseFilter(filterParam, deviceFilterSchema)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Illegal Filter "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		query["$and"] = []interface{}{filter}
+	}
+
 	for k, v := range r.URL.Query() {
-		if k == "owner-nick" {
+		if k == "owner-nick" || k == "filter" {
 			continue
 		}
 		if query[k] == nil {
@@ -0,0 +1,160 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package devices
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Selector selects a set of devices of one owner.
+//
+// Devices can be listed by ID or nick and/or matched on user-meta and
+// device-meta values. All criteria must match. To select every device of
+// the owner All must be set explicitly.
+type Selector struct {
+	All        bool              `json:"all,omitempty" bson:"all,omitempty"`
+	Devices    []string          `json:"devices,omitempty" bson:"devices,omitempty"`
+	UserMeta   map[string]string `json:"user-meta,omitempty" bson:"user-meta,omitempty"`
+	DeviceMeta map[string]string `json:"device-meta,omitempty" bson:"device-meta,omitempty"`
+}
+
+// ErrEmptySelector error returned when a selector has no criteria
+var ErrEmptySelector = errors.New("device selector needs at least one criteria or all set to true")
+
+// IsEmpty returns true if the selector has no criteria at all
+func (s *Selector) IsEmpty() bool {
+	return !s.All && len(s.Devices) == 0 && len(s.UserMeta) == 0 && len(s.DeviceMeta) == 0
+}
+
+// SelectorFromQuery reads a selector from url query values: devices=<id|nick>,...
+// user-meta.<key>=<value> and device-meta.<key>=<value>. The selector is empty if
+// none of them is given.
+func SelectorFromQuery(values url.Values) *Selector {
+	s := &Selector{}
+	for key, v := range values {
+		if len(v) == 0 {
+			continue
+		}
+		switch {
+		case key == "devices":
+			for _, d := range strings.Split(v[0], ",") {
+				if d != "" {
+					s.Devices = append(s.Devices, d)
+				}
+			}
+		case strings.HasPrefix(key, "user-meta."):
+			if s.UserMeta == nil {
+				s.UserMeta = map[string]string{}
+			}
+			s.UserMeta[strings.TrimPrefix(key, "user-meta.")] = v[0]
+		case strings.HasPrefix(key, "device-meta."):
+			if s.DeviceMeta == nil {
+				s.DeviceMeta = map[string]string{}
+			}
+			s.DeviceMeta[strings.TrimPrefix(key, "device-meta.")] = v[0]
+		}
+	}
+	return s
+}
+
+// Query builds the mongo query matching the selected devices of owner
+func (s *Selector) Query(owner string) (bson.M, error) {
+	if s.IsEmpty() {
+		return nil, ErrEmptySelector
+	}
+
+	query := bson.M{
+		"owner":   owner,
+		"garbage": bson.M{"$ne": true},
+	}
+
+	if len(s.Devices) > 0 {
+		ids := []primitive.ObjectID{}
+		nicks := []string{}
+		for _, d := range s.Devices {
+			id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(d, "prn:::devices:/"))
+			if err == nil {
+				ids = append(ids, id)
+			} else {
+				nicks = append(nicks, d)
+			}
+		}
+		query["$or"] = []bson.M{
+			{"_id": bson.M{"$in": ids}},
+			{"nick": bson.M{"$in": nicks}},
+		}
+	}
+
+	for k, v := range s.UserMeta {
+		query["user-meta."+strings.Replace(k, ".", "\uFF2E", -1)] = v
+	}
+	for k, v := range s.DeviceMeta {
+		query["device-meta."+strings.Replace(k, ".", "\uFF2E", -1)] = v
+	}
+
+	return query, nil
+}
+
+// FindDevicesBySelector returns all devices of owner matching the selector
+// ordered by device ID
+func (a *App) FindDevicesBySelector(pctx context.Context, owner string, s *Selector) ([]Device, error) {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_devices")
+	if collection == nil {
+		return nil, errors.New("Error with Database connectivity")
+	}
+
+	query, err := s.Query(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	findOptions := options.Find()
+	findOptions.SetNoCursorTimeout(true)
+	findOptions.SetSort(bson.M{"_id": 1})
+	findOptions.SetProjection(bson.M{"secret": 0, "challenge": 0})
+
+	ctx, cancel := context.WithTimeout(pctx, 30*time.Second)
+	defer cancel()
+	cur, err := collection.Find(ctx, query, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	result := []Device{}
+	for cur.Next(ctx) {
+		device := Device{}
+		err := cur.Decode(&device)
+		if err != nil {
+			return nil, err
+		}
+		device.UserMeta = utils.BsonUnquoteMap(&device.UserMeta)
+		device.DeviceMeta = utils.BsonUnquoteMap(&device.DeviceMeta)
+		result = append(result, device)
+	}
+
+	return result, nil
+}
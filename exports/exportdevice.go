@@ -17,6 +17,7 @@
 package exports
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/ant0ine/go-json-rest/rest"
@@ -27,17 +28,27 @@ import (
 
 // handleGetExport Export a tar gz file with of a device
 // @Summary Export a tar gz file with of a device
-// @Description Export a tar gz file with of a device
+// @Description Export a tar gz file with of a device. rev can be a rev number, a tag
+// @Description of the device trail or latest.
+// @Description With base=<rev> a delta export gets created: it only includes the objects
+// @Description not used by the base revision and a manifest.json listing the objects
+// @Description included and the ones expected from the base revision.
+// @Description With signed=true the tarball is a signed offline update bundle: it has a
+// @Description manifest.json and its detached JWS signature as manifest.json.sig, made
+// @Description with the key of /exports/bundle-key (501 if no bundle key is configured).
 // @Accept  json
 // @Produce  json
 // @Security ApiKeyAuth
 // @Tags exports
 // @Param owner-nick query string false "Owner nick"
 // @Param owner query string false "Owner PRN"
+// @Param base query string false "Base rev for a delta export"
+// @Param signed query string false "true to create a signed bundle"
 // @Success 200 {binary} []byte
 // @Failure 400 {object} utils.RError
 // @Failure 404 {object} utils.RError
 // @Failure 500 {object} utils.RError
+// @Failure 501 {object} utils.RError
 // @Router /exports/{owner}/{nick}/{rev}/{filename} [get]
 func (a *App) handleGetExport(w rest.ResponseWriter, r *rest.Request) {
 	owner := r.PathParam("owner")
@@ -45,6 +56,8 @@ func (a *App) handleGetExport(w rest.ResponseWriter, r *rest.Request) {
 	rev := r.PathParam("rev")
 	filename := r.PathParam("filename")
 	frags := r.URL.Query().Get("parts")
+	base := r.URL.Query().Get("base")
+	signed := r.URL.Query().Get("signed") == "true"
 
 	payload, ok := r.Env["JWT_PAYLOAD"]
 	if !ok {
@@ -116,5 +129,45 @@ func (a *App) handleGetExport(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
-	exportservice.WriteExportTar(w, filename, objectDownloads, state, modtime)
+	var manifest *exportservices.ExportManifest
+	var signature []byte
+	if base != "" || signed {
+		baseRevision := ""
+		baseState := []byte("{}")
+		if base != "" {
+			baseRevision, baseState, _, rerr = exportservice.GetStepRev(r.Context(), device.ID.Hex(), base, "")
+			if rerr != nil {
+				utils.RestErrorWrite(w, rerr)
+				return
+			}
+		}
+
+		included, baseObjects, err := exportservices.DeltaObjects(objectDownloads, baseState)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Error reading base state: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		objectDownloads = included
+		manifest = exportservices.NewExportManifest(revision, baseRevision, state, included, baseObjects)
+		manifest.Device = device.ID.Hex()
+	}
+
+	if signed {
+		content, err := exportservices.MarshalManifest(manifest)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Error encoding manifest: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		signature, err = exportservices.SignManifest(content)
+		if errors.Is(err, exportservices.ErrBundleKeyNotConfigured) {
+			utils.RestErrorWrapper(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			utils.RestErrorWrapper(w, "Error signing bundle: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	exportservice.WriteExportTar(w, filename, objectDownloads, state, modtime, manifest, signature)
 }
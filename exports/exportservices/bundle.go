@@ -0,0 +1,173 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package exportservices
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/logs"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// BundleSignatureFile name of the detached manifest signature in signed
+// export tarballs
+const BundleSignatureFile = "manifest.json.sig"
+
+// ErrBundleKeyNotConfigured no dedicated key to sign bundles with is
+// configured; bundles never get signed with the JWT key
+var ErrBundleKeyNotConfigured = errors.New("bundle signing key not configured; set " +
+	utils.EnvPantahubBundleSecret + " and " + utils.EnvPantahubBundlePub)
+
+// BundleKey public key signed export bundles can be verified with
+type BundleKey struct {
+	Pem         string `json:"pem"`
+	Fingerprint string `json:"fingerprint"`
+	Algorithm   string `json:"alg"`
+}
+
+// OfflineReport progress and logs a device collected while offline
+type OfflineReport struct {
+	// Device ID; required when not reported with a device token
+	Device   string            `json:"device,omitempty"`
+	Progress []OfflineProgress `json:"progress"`
+	Logs     []logs.Entry      `json:"logs"`
+}
+
+// OfflineProgress progress of a step as reported by the device at Time
+type OfflineProgress struct {
+	Rev      int                      `json:"rev"`
+	Progress trailmodels.StepProgress `json:"progress"`
+	Time     time.Time                `json:"time"`
+}
+
+// OfflineReportResult result of recording an offline report
+type OfflineReportResult struct {
+	Progress []OfflineProgressResult `json:"progress"`
+	Logs     int                     `json:"logs"`
+}
+
+// OfflineProgressResult result of recording an offline progress; Applied
+// tells if it became the progress of the step
+type OfflineProgressResult struct {
+	Rev     int       `json:"rev"`
+	Time    time.Time `json:"time"`
+	Applied bool      `json:"applied"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// MarshalManifest encodes manifest the way it is stored in export tarballs
+func MarshalManifest(manifest *ExportManifest) ([]byte, error) {
+	return json.MarshalIndent(manifest, "", "  ")
+}
+
+// bundleKeys loads the key pair bundles get signed with
+func bundleKeys() (*utils.JwtRsaKeys, error) {
+	if utils.GetEnv(utils.EnvPantahubBundleSecret) == "" || utils.GetEnv(utils.EnvPantahubBundlePub) == "" {
+		return nil, ErrBundleKeyNotConfigured
+	}
+
+	keys, err := utils.GetJwtRsaKeys(utils.EnvPantahubBundleSecret, utils.EnvPantahubBundlePub)
+	if err != nil {
+		return nil, errors.New("invalid bundle signing key: " + err.Error())
+	}
+	return keys, nil
+}
+
+// publicKeyFingerprint hex encoded sha256 of the DER encoded public key
+func publicKeyFingerprint(pub *rsa.PublicKey) (string, []byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), der, nil
+}
+
+// GetBundleKey returns the public key export bundles get signed with
+func GetBundleKey() (*BundleKey, error) {
+	keys, err := bundleKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, der, err := publicKeyFingerprint(keys.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BundleKey{
+		Pem:         string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		Fingerprint: fingerprint,
+		Algorithm:   string(jose.RS256),
+	}, nil
+}
+
+// SignManifest signs the encoded manifest with the bundle key
+func SignManifest(manifest []byte) ([]byte, error) {
+	keys, err := bundleKeys()
+	if err != nil {
+		return nil, err
+	}
+	return signManifest(manifest, keys.PrivateKey)
+}
+
+// signManifest creates a RS256 JWS of manifest with detached payload
+// ("<header>..<signature>"); the key id is the fingerprint of the key.
+func signManifest(manifest []byte, key *rsa.PrivateKey) ([]byte, error) {
+	fingerprint, _, err := publicKeyFingerprint(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: key, KeyID: fingerprint}},
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	jws, err := signer.Sign(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := jws.DetachedCompactSerialize()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(signature), nil
+}
+
+// VerifyManifest verifies the detached signature of manifest against pub
+func VerifyManifest(manifest, signature []byte, pub *rsa.PublicKey) error {
+	jws, err := jose.ParseDetached(string(signature), manifest)
+	if err != nil {
+		return err
+	}
+	_, err = jws.Verify(pub)
+	return err
+}
@@ -20,6 +20,9 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
@@ -52,7 +55,37 @@ type ExportService interface {
 		filename string,
 		objectDownloads []objects.ObjectWithAccess,
 		state []byte,
-		modetime *time.Time)
+		modetime *time.Time,
+		manifest *ExportManifest,
+		signature []byte)
+	WriteFleetSnapshot(
+		ctx context.Context,
+		w io.Writer,
+		owner string,
+		at time.Time,
+		objectFn func(object objects.Object) error,
+	) (*FleetSummary, error)
+}
+
+// ManifestFile name of the manifest in delta and signed export tarballs
+const ManifestFile = "manifest.json"
+
+// ExportManifest describes the content of a delta or signed export tarball.
+// Objects are included in the tarball, BaseObjects are used by the state but
+// expected to be available from the base revision already.
+type ExportManifest struct {
+	Device      string           `json:"device,omitempty"`
+	Rev         string           `json:"rev"`
+	Base        string           `json:"base,omitempty"`
+	StateSha    string           `json:"state-sha256"`
+	Objects     []ManifestObject `json:"objects"`
+	BaseObjects []string         `json:"base-objects"`
+}
+
+// ManifestObject an object included in an export tarball
+type ManifestObject struct {
+	Sha  string `json:"sha256"`
+	Size int64  `json:"size"`
 }
 
 type EService struct {
@@ -109,6 +142,72 @@ func (s *EService) GetStepRev(ctx context.Context, trailID, rev, frags string) (
 	return r, state, &step.TimeModified, rerr
 }
 
+// DeltaObjects splits objectDownloads in the ones to include in a delta
+// export and the shas of the ones already used by the state baseState.
+func DeltaObjects(objectDownloads []objects.ObjectWithAccess, baseState []byte) (
+	included []objects.ObjectWithAccess,
+	baseObjects []string,
+	err error,
+) {
+	state := map[string]interface{}{}
+	err = json.Unmarshal(baseState, &state)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inBase := map[string]bool{}
+	for key, v := range state {
+		if strings.HasSuffix(key, ".json") || key == "#spec" {
+			continue
+		}
+		if sha, ok := v.(string); ok {
+			inBase[sha] = true
+		}
+	}
+
+	included = []objects.ObjectWithAccess{}
+	baseObjects = []string{}
+	seen := map[string]bool{}
+	for _, object := range objectDownloads {
+		if seen[object.Sha] {
+			continue
+		}
+		seen[object.Sha] = true
+		if inBase[object.Sha] {
+			baseObjects = append(baseObjects, object.Sha)
+		} else {
+			included = append(included, object)
+		}
+	}
+	sort.Strings(baseObjects)
+
+	return included, baseObjects, nil
+}
+
+// NewExportManifest creates the manifest of a delta export of state at rev
+func NewExportManifest(rev, base string, state []byte, included []objects.ObjectWithAccess, baseObjects []string) *ExportManifest {
+	sum := sha256.Sum256(state)
+	manifest := &ExportManifest{
+		Rev:         rev,
+		Base:        base,
+		StateSha:    hex.EncodeToString(sum[:]),
+		Objects:     []ManifestObject{},
+		BaseObjects: baseObjects,
+	}
+
+	for _, object := range included {
+		manifest.Objects = append(manifest.Objects, ManifestObject{
+			Sha:  object.Sha,
+			Size: object.SizeInt,
+		})
+	}
+	sort.Slice(manifest.Objects, func(i, j int) bool {
+		return manifest.Objects[i].Sha < manifest.Objects[j].Sha
+	})
+
+	return manifest
+}
+
 func (s *EService) GetTrailObjects(ctx context.Context, deviceID, rev, owner, authType string, isPublic bool, frags string) (owa []objects.ObjectWithAccess, rerr *utils.RError) {
 	trailservice := trailservices.CreateService(s.storage, s.db.Name())
 
@@ -182,6 +281,8 @@ func (s *EService) WriteExportTar(
 	objectDownloads []objects.ObjectWithAccess,
 	state []byte,
 	modtime *time.Time,
+	manifest *ExportManifest,
+	signature []byte,
 ) {
 	var fileWriter io.Writer = w
 
@@ -206,6 +307,27 @@ func (s *EService) WriteExportTar(
 		return
 	}
 
+	if manifest != nil {
+		content, err := MarshalManifest(manifest)
+		if err != nil {
+			utils.RestErrorWrapper(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = addToTarFileFromBytes(tw, ManifestFile, content, modtime)
+		if err != nil {
+			utils.RestErrorWrapper(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if signature != nil {
+		err = addToTarFileFromBytes(tw, BundleSignatureFile, signature, modtime)
+		if err != nil {
+			utils.RestErrorWrapper(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	sort.Sort(ByObjectName(objectDownloads))
 
 	for _, object := range objectDownloads {
@@ -0,0 +1,140 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package exportservices
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/devices"
+	"gitlab.com/pantacor/pantahub-base/objects"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDeltaObjects(t *testing.T) {
+	objectDownloads := []objects.ObjectWithAccess{
+		{Object: objects.Object{ID: "aaa", Sha: "aaa", SizeInt: 1}},
+		{Object: objects.Object{ID: "bbb", Sha: "bbb", SizeInt: 2}},
+		{Object: objects.Object{ID: "ccc", Sha: "ccc", SizeInt: 3}},
+		{Object: objects.Object{ID: "ccc", Sha: "ccc", SizeInt: 3}},
+	}
+	baseState := []byte(`{
+		"#spec": "pantavisor-multi-platform@1",
+		"bsp/kernel.img": "aaa",
+		"bsp/run.json": {"linux": "kernel.img"},
+		"app/root.squashfs": "ddd"
+	}`)
+
+	included, baseObjects, err := DeltaObjects(objectDownloads, baseState)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if len(included) != 2 || included[0].Sha != "bbb" || included[1].Sha != "ccc" {
+		t.Errorf("unexpected included objects %v", included)
+	}
+	if !reflect.DeepEqual(baseObjects, []string{"aaa"}) {
+		t.Errorf("unexpected base objects %v", baseObjects)
+	}
+
+	manifest := NewExportManifest("7", "5", []byte("{}"), included, baseObjects)
+	want := &ExportManifest{
+		Rev:         "7",
+		Base:        "5",
+		StateSha:    "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+		Objects:     []ManifestObject{{Sha: "bbb", Size: 2}, {Sha: "ccc", Size: 3}},
+		BaseObjects: []string{"aaa"},
+	}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("got %+v, want %+v", manifest, want)
+	}
+
+	if _, _, err := DeltaObjects(objectDownloads, []byte("[")); err == nil {
+		t.Errorf("expected error for invalid base state")
+	}
+}
+
+func TestSignManifest(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := MarshalManifest(&ExportManifest{Device: "dev", Rev: "7", StateSha: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := signManifest(manifest, key)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err.Error())
+	}
+	if parts := strings.Split(string(signature), "."); len(parts) != 3 || parts[1] != "" {
+		t.Errorf("expected detached compact JWS, got %s", signature)
+	}
+
+	if err := VerifyManifest(manifest, signature, &key.PublicKey); err != nil {
+		t.Errorf("unexpected verify error %s", err.Error())
+	}
+
+	tampered := []byte(strings.Replace(string(manifest), `"7"`, `"8"`, 1))
+	if err := VerifyManifest(tampered, signature, &key.PublicKey); err == nil {
+		t.Errorf("expected verify error for tampered manifest")
+	}
+}
+
+func TestNewFleetDevice(t *testing.T) {
+	device := &devices.Device{
+		ID:         primitive.NewObjectID(),
+		Prn:        "prn:::devices:/1",
+		Nick:       "dev1",
+		UserMeta:   map[string]interface{}{"fleet\uFF2Egroup": "lab"},
+		DeviceMeta: map[string]interface{}{},
+	}
+
+	record := newFleetDevice(device, nil)
+	if record.Rev != -1 || record.StepTime != nil || len(record.Objects) != 0 {
+		t.Errorf("unexpected record for device without steps %+v", record)
+	}
+	if record.UserMeta["fleet.group"] != "lab" {
+		t.Errorf("expected unquoted user-meta, got %v", record.UserMeta)
+	}
+
+	step := &trailmodels.Step{
+		Rev:      3,
+		StateSha: "abc",
+		StepTime: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		State: map[string]interface{}{
+			"#spec":                   "pantavisor-multi-platform@1",
+			"bsp/kernel\uFF2Eimg":     "bbb",
+			"bsp/run\uFF2Ejson":       map[string]interface{}{},
+			"app/root\uFF2Esquashfs":  "aaa",
+			"app2/root\uFF2Esquashfs": "aaa",
+		},
+		StepProgress: trailmodels.StepProgress{Status: "DONE"},
+	}
+	record = newFleetDevice(device, step)
+	if record.Type != FleetRecordDevice || record.Rev != 3 || record.Status != "DONE" || record.StateSha != "abc" {
+		t.Errorf("unexpected record %+v", record)
+	}
+	if !reflect.DeepEqual(record.Objects, []string{"aaa", "bbb"}) {
+		t.Errorf("unexpected objects %v", record.Objects)
+	}
+}
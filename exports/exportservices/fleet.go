@@ -0,0 +1,349 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package exportservices
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+
+	"gitlab.com/pantacor/pantahub-base/devices"
+	"gitlab.com/pantacor/pantahub-base/objects"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/trails/trailservices"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// FleetSnapshotFile name of the NDJSON manifest in fleet snapshot archives
+const FleetSnapshotFile = "fleet.ndjson"
+
+// Record types of the fleet snapshot manifest
+const (
+	FleetRecordSnapshot = "snapshot"
+	FleetRecordDevice   = "device"
+	FleetRecordObject   = "object"
+	FleetRecordSummary  = "summary"
+)
+
+// FleetSnapshot first record of a fleet snapshot manifest
+type FleetSnapshot struct {
+	Type        string    `json:"type"`
+	Owner       string    `json:"owner"`
+	Time        time.Time `json:"time"`
+	TimeCreated time.Time `json:"time-created"`
+}
+
+// FleetDevice record of a device and the revision it ran at the time of the
+// snapshot; Rev is -1 if no step of the device was DONE by then. Only the
+// revision is point in time: meta data is the current one.
+type FleetDevice struct {
+	Type       string                 `json:"type"`
+	ID         string                 `json:"id"`
+	Prn        string                 `json:"prn"`
+	Nick       string                 `json:"nick"`
+	Rev        int                    `json:"rev"`
+	Status     string                 `json:"status,omitempty"`
+	StateSha   string                 `json:"state-sha256,omitempty"`
+	StepTime   *time.Time             `json:"step-time,omitempty"`
+	UserMeta   map[string]interface{} `json:"user-meta"`
+	DeviceMeta map[string]interface{} `json:"device-meta"`
+	Objects    []string               `json:"objects"`
+}
+
+// FleetObject record of an object, written once when first used by a device
+type FleetObject struct {
+	Type string `json:"type"`
+	Sha  string `json:"sha256"`
+	Size int64  `json:"size"`
+}
+
+// FleetSummary last record of a fleet snapshot manifest
+type FleetSummary struct {
+	Type    string `json:"type"`
+	Devices int    `json:"devices"`
+	Objects int    `json:"objects"`
+}
+
+// stateObjectShas returns the sorted object shas used by state
+func stateObjectShas(state map[string]interface{}) []string {
+	shas := []string{}
+	seen := map[string]bool{}
+	for key, v := range state {
+		if strings.HasSuffix(key, ".json") || key == "#spec" {
+			continue
+		}
+		sha, ok := v.(string)
+		if !ok || seen[sha] {
+			continue
+		}
+		seen[sha] = true
+		shas = append(shas, sha)
+	}
+	sort.Strings(shas)
+	return shas
+}
+
+// newFleetDevice makes the record of device at step, which may be nil
+func newFleetDevice(device *devices.Device, step *trailmodels.Step) *FleetDevice {
+	record := &FleetDevice{
+		Type:       FleetRecordDevice,
+		ID:         device.ID.Hex(),
+		Prn:        device.Prn,
+		Nick:       device.Nick,
+		Rev:        -1,
+		UserMeta:   utils.BsonUnquoteMap(&device.UserMeta),
+		DeviceMeta: utils.BsonUnquoteMap(&device.DeviceMeta),
+		Objects:    []string{},
+	}
+	if step == nil {
+		return record
+	}
+
+	state := utils.BsonUnquoteMap(&step.State)
+	stepTime := step.StepTime
+	record.Rev = step.Rev
+	record.Status = step.StepProgress.Status
+	record.StateSha = step.StateSha
+	record.StepTime = &stepTime
+	record.Objects = stateObjectShas(state)
+
+	return record
+}
+
+// findStepAt finds the step of the trail of device that the device ran at
+// at: the one that got DONE last until then, like the progress revision of
+// the device summary
+func (s *EService) findStepAt(ctx context.Context, device *devices.Device, at time.Time) (*trailmodels.Step, error) {
+	ctxi, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	step := &trailmodels.Step{}
+	findOptions := options.FindOne()
+	findOptions.SetSort(bson.M{"progress-time": -1})
+	err := s.db.Collection("pantahub_steps").FindOne(ctxi, bson.M{
+		"trail-id":        device.ID,
+		"progress.status": "DONE",
+		"progress-time":   bson.M{"$lte": at},
+		"garbage":         bson.M{"$ne": true},
+	}, findOptions).Decode(step)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rerr := trailservices.CreateService(s.storage, s.db.Name()).ResolveRelease(ctx, step)
+	if rerr != nil {
+		return nil, errors.New(rerr.Error)
+	}
+	return step, nil
+}
+
+// findFleetObject finds the object sha of owner; objects that cannot be
+// found are returned with their sha only
+func (s *EService) findFleetObject(ctx context.Context, owner, sha string) (objects.Object, error) {
+	object := objects.Object{ID: sha, Sha: sha}
+	shaBytes, err := utils.DecodeSha256HexString(sha)
+	if err != nil {
+		return object, nil
+	}
+	storageID := objects.MakeStorageID(owner, shaBytes)
+
+	ctxi, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	err = s.db.Collection("pantahub_objects").FindOne(ctxi, bson.M{
+		"_id":     storageID,
+		"garbage": bson.M{"$ne": true},
+	}).Decode(&object)
+	if err == mongo.ErrNoDocuments {
+		return object, nil
+	}
+	return object, err
+}
+
+// WriteFleetSnapshot writes the NDJSON manifest of the devices of owner and
+// the revisions they had at to w. Devices and steps get streamed from mongo;
+// only the shas of the objects written so far are kept to deduplicate them.
+// objectFn, if not nil, gets called for every object once.
+func (s *EService) WriteFleetSnapshot(
+	ctx context.Context,
+	w io.Writer,
+	owner string,
+	at time.Time,
+	objectFn func(object objects.Object) error,
+) (*FleetSummary, error) {
+	encoder := json.NewEncoder(w)
+	summary := &FleetSummary{Type: FleetRecordSummary}
+
+	err := encoder.Encode(FleetSnapshot{
+		Type:        FleetRecordSnapshot,
+		Owner:       owner,
+		Time:        at,
+		TimeCreated: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"_id": 1})
+	findOptions.SetNoCursorTimeout(true)
+	cursor, err := s.db.Collection("pantahub_devices").Find(ctx, bson.M{
+		"owner":       owner,
+		"timecreated": bson.M{"$lte": at},
+		"garbage":     bson.M{"$ne": true},
+	}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	seen := map[string]bool{}
+	for cursor.Next(ctx) {
+		device := devices.Device{}
+		if err := cursor.Decode(&device); err != nil {
+			return nil, err
+		}
+
+		step, err := s.findStepAt(ctx, &device, at)
+		if err != nil {
+			return nil, err
+		}
+
+		record := newFleetDevice(&device, step)
+		if err := encoder.Encode(record); err != nil {
+			return nil, err
+		}
+		summary.Devices++
+
+		for _, sha := range record.Objects {
+			if seen[sha] {
+				continue
+			}
+			seen[sha] = true
+
+			object, err := s.findFleetObject(ctx, owner, sha)
+			if err != nil {
+				return nil, err
+			}
+			err = encoder.Encode(FleetObject{Type: FleetRecordObject, Sha: sha, Size: object.SizeInt})
+			if err != nil {
+				return nil, err
+			}
+			summary.Objects++
+
+			if objectFn != nil && object.StorageID != "" {
+				if err := objectFn(object); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := encoder.Encode(summary); err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// WriteFleetArchive writes a tar, or tar.gz if filename ends with .gz or
+// .tgz, with the fleet snapshot manifest in snapshot and the content of
+// fleetObjects to w. Object downloads get signed one by one as the archive
+// gets written, so they do not expire while streaming.
+func WriteFleetArchive(
+	w rest.ResponseWriter,
+	filename string,
+	snapshot *os.File,
+	fleetObjects []objects.Object,
+	modtime time.Time,
+) {
+	var fileWriter io.Writer = w
+
+	w.Header().Add("Content-disposition", "attachment; filename="+filename)
+	w.Header().Add("Content-type", "application/octet-stream")
+	w.Header().Add("Pragma", "no-cache")
+	w.Header().Add("Expires", "0")
+
+	if strings.HasSuffix(strings.ToLower(filename), ".gz") ||
+		strings.HasSuffix(strings.ToLower(filename), ".tgz") {
+		f := gzip.NewWriter(w)
+		defer f.Close()
+		fileWriter = f
+	}
+
+	tw := tar.NewWriter(fileWriter)
+	defer tw.Close()
+
+	stat, err := snapshot.Stat()
+	if err != nil {
+		utils.RestErrorWrapper(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err = snapshot.Seek(0, io.SeekStart); err != nil {
+		utils.RestErrorWrapper(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	header := new(tar.Header)
+	header.Name = FleetSnapshotFile
+	header.Size = stat.Size()
+	header.Mode = 0600
+	header.Format = tar.FormatUSTAR
+	header.ModTime = modtime
+	if err = tw.WriteHeader(header); err != nil {
+		utils.RestErrorWrapper(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if _, err = io.Copy(tw, snapshot); err != nil {
+		utils.RestErrorWrapper(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, o := range fleetObjects {
+		object := objects.GetObjectWithAccess(o, "/exports")
+		resp, err := http.Get(object.SignedGetURL)
+		if err != nil {
+			utils.RestErrorWrapper(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = addToTarFromResponse(tw, "objects/"+object.Sha, resp, &modtime)
+		resp.Body.Close()
+		if err != nil {
+			utils.RestErrorWrapper(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package exports
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"gitlab.com/pantacor/pantahub-base/exports/exportservices"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// handleGetBundleKey Get the public key signed bundles can be verified with
+// @Summary Get the public key signed bundles can be verified with
+// @Description Get the PEM encoded public key and its fingerprint that signed
+// @Description export bundles (signed=true) can be verified with. Without a configured
+// @Description bundle key the server does not offer signed bundles (501).
+// @Accept  json
+// @Produce  json
+// @Tags exports
+// @Success 200 {object} exportservices.BundleKey
+// @Failure 500 {object} utils.RError
+// @Failure 501 {object} utils.RError
+// @Router /exports/bundle-key [get]
+func (a *App) handleGetBundleKey(w rest.ResponseWriter, r *rest.Request) {
+	key, err := exportservices.GetBundleKey()
+	if errors.Is(err, exportservices.ErrBundleKeyNotConfigured) {
+		utils.RestErrorWrapper(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		utils.RestErrorWrapper(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(key)
+}
@@ -0,0 +1,137 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package exports
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/exports/exportservices"
+	"gitlab.com/pantacor/pantahub-base/objects"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// handleGetFleetSnapshot Export a snapshot of all devices of the caller
+// @Summary Export a snapshot of all devices of the caller
+// @Description Export an NDJSON manifest of all devices of the caller with the revision
+// @Description they ran at the given time (the one DONE last until then): rev, status, state
+// @Description sha, user-meta, device-meta and objects used, followed by a record for every
+// @Description object the first time it is used. Only the revision is point in time; meta
+// @Description data is the current one and devices deleted since are missing. With a
+// @Description filename ending in .ndjson the manifest gets streamed as is; otherwise a
+// @Description tar (or tar.gz for .gz/.tgz) with the manifest as fleet.ndjson and, with
+// @Description objects=true, the objects as objects/<sha> gets returned.
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags exports
+// @Param filename path string true "Filename"
+// @Param at query string false "RFC3339 time of the revisions of the snapshot; default now"
+// @Param objects query string false "true to include the objects in the archive"
+// @Success 200 {binary} []byte
+// @Failure 400 {object} utils.RError
+// @Failure 403 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /exports/fleet/{filename} [get]
+func (a *App) handleGetFleetSnapshot(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+	filename := r.PathParam("filename")
+
+	jwtPayload, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+	authType, _ := jwtPayload["type"].(string)
+	if authType != "USER" && authType != "SESSION" {
+		utils.RestErrorWrapper(w, "Need to be logged in as USER to export the fleet", http.StatusForbidden)
+		return
+	}
+
+	ownerPtr, ok := jwtPayload["owner"]
+	if !ok {
+		ownerPtr = jwtPayload["prn"]
+	}
+	owner, ok := ownerPtr.(string)
+	if !ok || owner == "" {
+		utils.RestErrorWrapper(w, "Session has no owner info", http.StatusBadRequest)
+		return
+	}
+
+	at := time.Now()
+	if atStr := r.URL.Query().Get("at"); atStr != "" {
+		var err error
+		at, err = time.Parse(time.RFC3339, atStr)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Invalid at time: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	withObjects := r.URL.Query().Get("objects") == "true"
+
+	exportservice := exportservices.CreateService(a.mongoClient, utils.MongoDb)
+
+	if strings.HasSuffix(strings.ToLower(filename), ".ndjson") {
+		if withObjects {
+			utils.RestErrorWrapper(w, "objects can only be included in tar archives", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Add("Content-disposition", "attachment; filename="+filename)
+		w.Header().Add("Content-type", "application/x-ndjson")
+		w.Header().Add("Pragma", "no-cache")
+		w.Header().Add("Expires", "0")
+
+		_, err := exportservice.WriteFleetSnapshot(rContext, w, owner, at, nil)
+		if err != nil {
+			log.Printf("ERROR: writing fleet snapshot of %s: %s\n", owner, err.Error())
+		}
+		return
+	}
+
+	// the manifest gets spooled to disk as the archive needs its size upfront
+	snapshot, err := os.CreateTemp(os.TempDir(), "fleet-*.ndjson")
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error creating snapshot file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(snapshot.Name())
+	defer snapshot.Close()
+
+	fleetObjects := []objects.Object{}
+	var objectFn func(object objects.Object) error
+	if withObjects {
+		objectFn = func(object objects.Object) error {
+			fleetObjects = append(fleetObjects, object)
+			return nil
+		}
+	}
+
+	_, err = exportservice.WriteFleetSnapshot(rContext, snapshot, owner, at, objectFn)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error writing fleet snapshot: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	exportservices.WriteFleetArchive(w, filename, snapshot, fleetObjects, at)
+}
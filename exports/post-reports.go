@@ -0,0 +1,141 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package exports
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/devices"
+	"gitlab.com/pantacor/pantahub-base/exports/exportservices"
+	"gitlab.com/pantacor/pantahub-base/trails"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// handlePostReports Submit the progress and logs a device collected offline
+// @Summary Submit the progress and logs a device collected offline
+// @Description Submit the step progress and log entries a device collected while it was
+// @Description offline, e.g. after applying a signed bundle. They get recorded as if the
+// @Description device had reported them online at the time given: progress gets added to
+// @Description the progress history of the step and becomes its progress unless newer one
+// @Description got reported meanwhile. Devices submit their own reports; owners have to
+// @Description give the device ID and their progress gets recorded with source owner-report.
+// @Description Logs get stored first, so a report failing on them can be submitted again;
+// @Description progress submitted again is not added to the history twice.
+// @Accept  json
+// @Produce  json
+// @Security ApiKeyAuth
+// @Tags exports
+// @Param body body exportservices.OfflineReport true "Offline report"
+// @Success 200 {object} exportservices.OfflineReportResult
+// @Failure 400 {object} utils.RError
+// @Failure 403 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /exports/reports [post]
+func (a *App) handlePostReports(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+
+	jwtPayload, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+	authType, _ := jwtPayload["type"].(string)
+	caller, _ := jwtPayload["prn"].(string)
+
+	report := exportservices.OfflineReport{}
+	err := r.DecodeJsonPayload(&report)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error decoding report: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	deviceID := report.Device
+	switch authType {
+	case "DEVICE":
+		deviceID = utils.PrnGetID(caller)
+	case "USER", "SESSION":
+		if deviceID == "" {
+			utils.RestErrorWrapper(w, "device is required", http.StatusBadRequest)
+			return
+		}
+	default:
+		utils.RestErrorWrapper(w, "Need to be logged in as DEVICE or USER to submit reports", http.StatusForbidden)
+		return
+	}
+
+	deviceObjectID, err := primitive.ObjectIDFromHex(deviceID)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid device ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	device := devices.Device{}
+	err = devices.Build(a.mongoClient).FindDeviceByID(rContext, deviceObjectID, &device)
+	if err != nil || device.Garbage {
+		utils.RestErrorWrapper(w, "Device not found", http.StatusNotFound)
+		return
+	}
+	if (authType == "DEVICE" && device.Prn != caller) ||
+		(authType != "DEVICE" && device.Owner != caller) {
+		utils.RestErrorWrapper(w, "No access to device", http.StatusForbidden)
+		return
+	}
+
+	// logs first: a report failing on them got nothing recorded and can be
+	// submitted again
+	err = a.logs.PostDeviceLogs(rContext, device.Prn, device.Owner, report.Logs)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error posting logs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// replay the progress in the order it got reported
+	sort.SliceStable(report.Progress, func(i, j int) bool {
+		return report.Progress[i].Time.Before(report.Progress[j].Time)
+	})
+
+	source := trails.ProgressSourceDevice
+	if authType != "DEVICE" {
+		source = trails.ProgressSourceOwnerReport
+	}
+
+	result := exportservices.OfflineReportResult{
+		Progress: []exportservices.OfflineProgressResult{},
+		Logs:     len(report.Logs),
+	}
+	trailsApp := trails.Build(a.mongoClient)
+	for _, p := range report.Progress {
+		applied, rerr := trailsApp.RecordOfflineProgress(rContext, device.Prn, device.ID, p.Rev, p.Progress, p.Time, source)
+		progressResult := exportservices.OfflineProgressResult{
+			Rev:     p.Rev,
+			Time:    p.Time,
+			Applied: applied,
+		}
+		if rerr != nil {
+			progressResult.Error = rerr.Error
+		}
+		result.Progress = append(result.Progress, progressResult)
+	}
+
+	w.WriteJson(result)
+}
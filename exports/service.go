@@ -26,6 +26,7 @@ import (
 	"github.com/ant0ine/go-json-rest/rest"
 	jwt "github.com/pantacor/go-json-rest-middleware-jwt"
 	"gitlab.com/pantacor/pantahub-base/auth/authservices"
+	"gitlab.com/pantacor/pantahub-base/logs"
 	"gitlab.com/pantacor/pantahub-base/metrics"
 	"gitlab.com/pantacor/pantahub-base/utils"
 	"gitlab.com/pantacor/pantahub-base/utils/tracer"
@@ -41,6 +42,7 @@ type App struct {
 	jwtMiddleware *jwt.JWTMiddleware
 	API           *rest.Api
 	mongoClient   *mongo.Client
+	logs          *logs.App
 }
 
 // Build factory a new Device App only with mongoClient
@@ -50,11 +52,12 @@ func Build(mongoClient *mongo.Client) *App {
 	}
 }
 
-// New create exports app
-func New(jwtMiddleware *jwt.JWTMiddleware, mongoClient *mongo.Client) *App {
+// New create exports app; offline reports store their logs with logsApp
+func New(jwtMiddleware *jwt.JWTMiddleware, mongoClient *mongo.Client, logsApp *logs.App) *App {
 	app := new(App)
 	app.jwtMiddleware = jwtMiddleware
 	app.mongoClient = mongoClient
+	app.logs = logsApp
 	app.API = rest.NewApi()
 
 	// we dont use default stack because we dont want content type enforcement
@@ -120,8 +123,17 @@ func New(jwtMiddleware *jwt.JWTMiddleware, mongoClient *mongo.Client) *App {
 		utils.Scopes.ReadDevices,
 	}
 
+	writeDevicesScopes := []utils.Scope{
+		utils.Scopes.API,
+		utils.Scopes.Devices,
+		utils.Scopes.WriteDevices,
+	}
+
 	// /auth_status endpoints
 	apiRouter, _ := rest.MakeRouter(
+		rest.Get("/bundle-key", utils.ScopeFilter(readDevicesScopes, app.handleGetBundleKey)),
+		rest.Post("/reports", utils.ScopeFilter(writeDevicesScopes, app.handlePostReports)),
+		rest.Get("/fleet/#filename", utils.ScopeFilter(readDevicesScopes, app.handleGetFleetSnapshot)),
 		rest.Get("/#owner/#nick/#rev/#filename", utils.ScopeFilter(readDevicesScopes, app.handleGetExport)),
 	)
 
@@ -88,17 +88,11 @@ func (a *App) handlePostLogs(w rest.ResponseWriter, r *rest.Request) {
 	newEntries := []Entry{}
 
 	for _, v := range entries {
-		v.ID, err = primitive.ObjectIDFromHex(bson.NewObjectId().Hex())
+		v, err = newDeviceEntry(v, device.(string), owner.(string), time.Now())
 		if err != nil {
 			utils.RestErrorWrapper(w, "Invalid Hex:"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		v.Device = device.(string)
-		v.Owner = owner.(string)
-		v.TimeCreated = time.Now()
-		if v.LogLevel == "" {
-			v.LogLevel = "INFO"
-		}
 		newEntries = append(newEntries, v)
 	}
 
@@ -113,6 +107,45 @@ func (a *App) handlePostLogs(w rest.ResponseWriter, r *rest.Request) {
 	w.WriteJson(response)
 }
 
+// newDeviceEntry stamps a log entry posted by device of owner
+func newDeviceEntry(v Entry, device, owner string, timeCreated time.Time) (Entry, error) {
+	var err error
+	v.ID, err = primitive.ObjectIDFromHex(bson.NewObjectId().Hex())
+	if err != nil {
+		return v, err
+	}
+	v.Device = device
+	v.Owner = owner
+	v.TimeCreated = timeCreated
+	if v.LogLevel == "" {
+		v.LogLevel = "INFO"
+	}
+	return v, nil
+}
+
+// PostDeviceLogs stores log entries a device collected while offline as if
+// it had posted them when they got logged
+func (a *App) PostDeviceLogs(ctx context.Context, device, owner string, entries []Entry) error {
+	newEntries := []Entry{}
+	now := time.Now()
+	for _, v := range entries {
+		timeCreated := now
+		if v.LogTSec > 0 && time.Unix(v.LogTSec, v.LogTNano).Before(now) {
+			timeCreated = time.Unix(v.LogTSec, v.LogTNano)
+		}
+		v, err := newDeviceEntry(v, device, owner, timeCreated)
+		if err != nil {
+			return err
+		}
+		newEntries = append(newEntries, v)
+	}
+	if len(newEntries) == 0 {
+		return nil
+	}
+
+	return a.backend.postLogs(ctx, newEntries)
+}
+
 func readLogsBody(ctx context.Context, buff io.ReadCloser) ([]Entry, error) {
 	t := tracer.GetFunctionTracer()
 	if t != nil {
@@ -0,0 +1,170 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package releases
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CollectionName releases collection
+const CollectionName = "pantahub_releases"
+
+func (a *App) setIndexes() error {
+	CreateIndexesOptions := options.CreateIndexesOptions{}
+	CreateIndexesOptions.SetMaxTime(10 * time.Second)
+
+	t := true
+
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(CollectionName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "owner", Value: 1},
+				{Key: "sha", Value: 1},
+			},
+			Options: &options.IndexOptions{
+				Background: &t,
+				Unique:     &t,
+			},
+		},
+	}, &CreateIndexesOptions)
+	if err != nil {
+		return err
+	}
+
+	// steps referencing a release
+	collection = a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "release", Value: 1},
+				{Key: "owner", Value: 1},
+				{Key: "garbage", Value: 1},
+			},
+			Options: &options.IndexOptions{
+				Background: &t,
+				Sparse:     &t,
+			},
+		},
+	}, &CreateIndexesOptions)
+
+	return err
+}
+
+func (a *App) insertRelease(pctx context.Context, release *trailmodels.Release) error {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(CollectionName)
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, release)
+	return err
+}
+
+func (a *App) findReleases(pctx context.Context, query bson.M, opts ...*options.FindOptions) ([]trailmodels.Release, error) {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(CollectionName)
+
+	ctx, cancel := context.WithTimeout(pctx, 30*time.Second)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, query, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	releases := []trailmodels.Release{}
+	err = cur.All(ctx, &releases)
+	if err != nil {
+		return nil, err
+	}
+
+	return releases, nil
+}
+
+// findReleaseDevices returns the trails of owner whose latest step references
+// the release sha
+func (a *App) findReleaseDevices(pctx context.Context, owner string, sha string) ([]ReleaseDevice, error) {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+
+	ctx, cancel := context.WithTimeout(pctx, 30*time.Second)
+	defer cancel()
+
+	trailIDs, err := collection.Distinct(ctx, "trail-id", bson.M{
+		"owner":   owner,
+		"release": sha,
+		"garbage": bson.M{"$ne": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []ReleaseDevice{}
+	if len(trailIDs) == 0 {
+		return result, nil
+	}
+
+	cur, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"trail-id": bson.M{"$in": trailIDs},
+			"garbage":  bson.M{"$ne": true},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "trail-id", Value: 1}, {Key: "rev", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":           "$trail-id",
+			"device":        bson.M{"$first": "$device"},
+			"rev":           bson.M{"$first": "$rev"},
+			"release":       bson.M{"$first": "$release"},
+			"status":        bson.M{"$first": "$progress.status"},
+			"progress-time": bson.M{"$first": "$progress-time"},
+		}}},
+		{{Key: "$match", Value: bson.M{"release": sha}}},
+		{{Key: "$sort", Value: bson.M{"device": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		device := struct {
+			ReleaseDevice `bson:",inline"`
+			TrailID       primitive.ObjectID `bson:"_id"`
+		}{}
+		err := cur.Decode(&device)
+		if err != nil {
+			return nil, err
+		}
+		device.ReleaseDevice.TrailID = device.TrailID.Hex()
+		result = append(result, device.ReleaseDevice)
+	}
+
+	return result, cur.Err()
+}
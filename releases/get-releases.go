@@ -0,0 +1,148 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package releases
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails/trailservices"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// handleGetReleases list releases of the calling owner
+// @Summary List releases of the calling owner
+// @Description List releases of the calling owner, newest first. States are left out;
+// @Description use GET /releases/{sha} to get the state of a release.
+// @Accept  json
+// @Produce  json
+// @Tags releases
+// @Security ApiKeyAuth
+// @Param name query string false "Release name"
+// @Success 200 {array} trailmodels.Release
+// @Failure 500 {object} utils.RError
+// @Router /releases [get]
+func (a *App) handleGetReleases(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	query := bson.M{
+		"owner":   owner,
+		"garbage": bson.M{"$ne": true},
+	}
+	name := r.URL.Query().Get("name")
+	if name != "" {
+		query["name"] = name
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"time-created": -1})
+	findOptions.SetProjection(bson.M{"state": 0, "used_objects": 0})
+
+	releases, err := a.findReleases(r.Context(), query, findOptions)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error finding releases: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(releases)
+}
+
+// handleGetRelease get a release of the calling owner
+// @Summary Get a release of the calling owner
+// @Description Get a release with its state by sha
+// @Accept  json
+// @Produce  json
+// @Tags releases
+// @Security ApiKeyAuth
+// @Param sha path string true "Release sha"
+// @Success 200 {object} trailmodels.Release
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /releases/{sha} [get]
+func (a *App) handleGetRelease(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	trailService := trailservices.CreateService(a.mongoClient, utils.MongoDb)
+	release, rerr := trailService.GetRelease(r.Context(), owner, r.PathParam("sha"))
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
+
+	w.WriteJson(unquoted(release))
+}
+
+// handleGetReleaseDevices list the devices running a release
+// @Summary List the devices running a release
+// @Description List the devices whose latest step references the release. Use
+// @Description status=<STATUS> to only get devices whose step has that progress status
+// @Description (e.g. DONE for the devices that completed the update).
+// @Accept  json
+// @Produce  json
+// @Tags releases
+// @Security ApiKeyAuth
+// @Param sha path string true "Release sha"
+// @Param status query string false "Step progress status"
+// @Success 200 {array} ReleaseDevice
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /releases/{sha}/devices [get]
+func (a *App) handleGetReleaseDevices(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	sha := r.PathParam("sha")
+	trailService := trailservices.CreateService(a.mongoClient, utils.MongoDb)
+	_, rerr := trailService.GetRelease(r.Context(), owner, sha)
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
+
+	devices, err := a.findReleaseDevices(r.Context(), owner, sha)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error finding release devices: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" {
+		filtered := []ReleaseDevice{}
+		for _, d := range devices {
+			if d.Status == status {
+				filtered = append(filtered, d)
+			}
+		}
+		devices = filtered
+	}
+
+	w.WriteJson(devices)
+}
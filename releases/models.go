@@ -0,0 +1,46 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package releases
+
+import (
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// NewRelease payload to create a release
+type NewRelease struct {
+	Name  string                 `json:"name"`
+	State map[string]interface{} `json:"state"`
+}
+
+// ReleaseDevice device whose latest step references a release
+type ReleaseDevice struct {
+	TrailID      string    `json:"trail-id" bson:"-"`
+	Device       string    `json:"device" bson:"device"`
+	Rev          int       `json:"rev" bson:"rev"`
+	Status       string    `json:"status" bson:"status"`
+	ProgressTime time.Time `json:"progress-time" bson:"progress-time"`
+}
+
+// unquoted returns a copy of release with its state bson unquoted
+func unquoted(release *trailmodels.Release) *trailmodels.Release {
+	r := *release
+	r.State = utils.BsonUnquoteMap(&release.State)
+	return &r
+}
@@ -0,0 +1,134 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package releases
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails"
+	"gitlab.com/pantacor/pantahub-base/trails/statevalidator"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/trails/trailservices"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// handlePostRelease create a new release
+// @Summary Create a new release
+// @Description Create an immutable release of a state. Releases are addressed by the
+// @Description state sha; posting a state that is already released returns the existing
+// @Description release. Steps can then be posted with {"release": "<sha>"} instead of a state.
+// @Accept  json
+// @Produce  json
+// @Tags releases
+// @Security ApiKeyAuth
+// @Param body body NewRelease true "Release payload"
+// @Success 200 {object} trailmodels.Release
+// @Failure 400 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /releases [post]
+func (a *App) handlePostRelease(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	newRelease := NewRelease{}
+	err := r.DecodeJsonPayload(&newRelease)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error decoding release: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(newRelease.State) == 0 {
+		utils.RestErrorWrapper(w, "Release needs a state", http.StatusBadRequest)
+		return
+	}
+
+	if trails.ValidationRequested(r) {
+		violations := statevalidator.Validate(newRelease.State)
+		if len(violations) > 0 {
+			trails.WriteStateViolations(w, violations)
+			return
+		}
+	}
+
+	// IMPORTANT: statesha has to be before state as that will be escaped
+	sha, err := utils.StateSha(&newRelease.State)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error calculating Sha "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	trailService := trailservices.CreateService(a.mongoClient, utils.MongoDb)
+	existing, rerr := trailService.GetRelease(rContext, owner, sha)
+	if rerr == nil {
+		w.WriteJson(unquoted(existing))
+		return
+	}
+	if rerr.Code != http.StatusNotFound {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
+
+	autoLink := true
+	autolinkValue, ok := r.URL.Query()["autolink"]
+	if ok && autolinkValue[0] == "no" {
+		autoLink = false
+	}
+
+	objectList, err := trails.ProcessObjectsInState(rContext, owner, newRelease.State, autoLink, trails.Build(a.mongoClient))
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error processing release objects in state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	release := &trailmodels.Release{
+		ID:          primitive.NewObjectID(),
+		Owner:       owner,
+		Sha:         sha,
+		Name:        newRelease.Name,
+		State:       utils.BsonQuoteMap(&newRelease.State),
+		UsedObjects: objectList,
+		TimeCreated: time.Now(),
+	}
+
+	err = a.insertRelease(rContext, release)
+	if mongo.IsDuplicateKeyError(err) {
+		// released concurrently; releases are immutable so return that one
+		existing, rerr = trailService.GetRelease(rContext, owner, sha)
+		if rerr != nil {
+			utils.RestErrorWrite(w, rerr)
+			return
+		}
+		w.WriteJson(unquoted(existing))
+		return
+	}
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error inserting release: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(unquoted(release))
+}
@@ -0,0 +1,197 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package rollouts
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CollectionName rollouts collection
+const CollectionName = "pantahub_rollouts"
+
+func (a *App) setIndexes() error {
+	CreateIndexesOptions := options.CreateIndexesOptions{}
+	CreateIndexesOptions.SetMaxTime(10 * time.Second)
+
+	t := true
+
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(CollectionName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "owner", Value: 1},
+				{Key: "garbage", Value: 1},
+			},
+			Options: &options.IndexOptions{
+				Background: &t,
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "status", Value: 1},
+				{Key: "garbage", Value: 1},
+			},
+			Options: &options.IndexOptions{
+				Background: &t,
+			},
+		},
+	}, &CreateIndexesOptions)
+
+	return err
+}
+
+func (a *App) insertRollout(pctx context.Context, rollout *Rollout) error {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(CollectionName)
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, rollout)
+	return err
+}
+
+// ErrRolloutChanged the rollout got changed by someone else since it was loaded
+var ErrRolloutChanged = errors.New("rollout got changed meanwhile")
+
+// saveRollout replaces the rollout if nobody changed it since it was loaded.
+// The cron job and the owner change rollouts concurrently; whoever saves
+// second gets ErrRolloutChanged and must not act on its copy anymore.
+func (a *App) saveRollout(pctx context.Context, rollout *Rollout) error {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(CollectionName)
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	version := rollout.Version
+	rollout.Version = version + 1
+	rollout.TimeModified = time.Now()
+	result, err := collection.ReplaceOne(ctx, bson.M{
+		"_id":     rollout.ID,
+		"version": version,
+	}, rollout)
+	if err != nil {
+		rollout.Version = version
+		return err
+	}
+	if result.MatchedCount == 0 {
+		rollout.Version = version
+		return ErrRolloutChanged
+	}
+
+	return nil
+}
+
+// saveRolloutTargets stores the targets and waves of a rollout after a wave got
+// posted. It leaves status alone so that a pause or abort of the owner that
+// landed meanwhile stays in place.
+func (a *App) saveRolloutTargets(pctx context.Context, rollout *Rollout) error {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(CollectionName)
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	rollout.TimeModified = time.Now()
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": rollout.ID}, bson.M{
+		"$set": bson.M{
+			"targets":       rollout.Targets,
+			"waves":         rollout.Waves,
+			"time-modified": rollout.TimeModified,
+		},
+		"$inc": bson.M{"version": 1},
+	})
+	if err != nil {
+		return err
+	}
+	rollout.Version++
+
+	return nil
+}
+
+// findRolloutStatus loads only the current status of a rollout
+func (a *App) findRolloutStatus(pctx context.Context, id primitive.ObjectID) (string, error) {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(CollectionName)
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	result := struct {
+		Status string `bson:"status"`
+	}{}
+	err := collection.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"status": 1})).Decode(&result)
+	if err != nil {
+		return "", err
+	}
+
+	return result.Status, nil
+}
+
+func (a *App) findRollout(pctx context.Context, id string, owner string) (*Rollout, error) {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(CollectionName)
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	rollout := &Rollout{}
+	err = collection.FindOne(ctx, bson.M{
+		"_id":     objectID,
+		"owner":   owner,
+		"garbage": bson.M{"$ne": true},
+	}).Decode(rollout)
+	if err != nil {
+		return nil, err
+	}
+
+	return rollout, nil
+}
+
+func (a *App) findRollouts(pctx context.Context, query bson.M, opts ...*options.FindOptions) ([]Rollout, error) {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(CollectionName)
+
+	ctx, cancel := context.WithTimeout(pctx, 30*time.Second)
+	defer cancel()
+
+	cur, err := collection.Find(ctx, query, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	rollouts := []Rollout{}
+	for cur.Next(ctx) {
+		rollout := Rollout{}
+		err := cur.Decode(&rollout)
+		if err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, rollout)
+	}
+
+	return rollouts, nil
+}
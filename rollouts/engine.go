@@ -0,0 +1,388 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package rollouts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type gateDecision int
+
+const (
+	gateWait gateDecision = iota
+	gateAdvance
+	gateHalt
+)
+
+// failedStatuses step progress status that count as failure of a wave
+var failedStatuses = []string{"ERROR", "WONTGO"}
+
+// finalStatuses step progress status after which a device won't progress anymore
+var finalStatuses = []string{"DONE", "ERROR", "WONTGO", "CANCEL"}
+
+// waveSizes calculates how many of total devices go into each wave.
+// Wave percents are cumulative, must be increasing and the last one must be 100.
+func waveSizes(total int, waves []Wave) ([]int, error) {
+	if len(waves) == 0 {
+		return nil, errors.New("rollout needs at least one wave")
+	}
+
+	sizes := make([]int, len(waves))
+	reached := 0
+	lastPercent := 0.0
+	for i, w := range waves {
+		if w.Percent <= lastPercent || w.Percent > 100 {
+			return nil, fmt.Errorf("wave %d: percent must be increasing and within (0,100]", i)
+		}
+		lastPercent = w.Percent
+
+		cumulative := int(math.Ceil(float64(total) * w.Percent / 100))
+		if cumulative > total {
+			cumulative = total
+		}
+		sizes[i] = cumulative - reached
+		reached = cumulative
+	}
+
+	if lastPercent != 100 {
+		return nil, errors.New("last wave must reach 100 percent")
+	}
+
+	return sizes, nil
+}
+
+// gateWave decides on the next action for a wave based on the step status
+// counts of its devices. Counts in accepted were acknowledged by the owner on
+// resume and don't halt the wave again.
+func gateWave(counts, accepted map[string]int, failureThreshold, successThreshold float64) gateDecision {
+	total := 0
+	for status, c := range counts {
+		if status == TargetStatusSkipped {
+			continue
+		}
+		total += c
+	}
+	if total == 0 {
+		// a wave where no step could be posted at all must not pass silently
+		if counts[TargetStatusSkipped] > accepted[TargetStatusSkipped] {
+			return gateHalt
+		}
+		return gateAdvance
+	}
+
+	failed := 0
+	for _, s := range failedStatuses {
+		failed += counts[s] - accepted[s]
+	}
+	final := 0
+	for _, s := range finalStatuses {
+		final += counts[s]
+	}
+
+	if float64(failed)*100/float64(total) > failureThreshold {
+		return gateHalt
+	}
+	if float64(counts["DONE"])*100/float64(total) >= successThreshold {
+		return gateAdvance
+	}
+	if final == total {
+		return gateAdvance
+	}
+
+	return gateWait
+}
+
+// acceptedCounts picks the failed and skipped counts of a wave that the owner
+// accepts when resuming it
+func acceptedCounts(counts map[string]int) map[string]int {
+	accepted := map[string]int{}
+	for _, s := range append([]string{TargetStatusSkipped}, failedStatuses...) {
+		if counts[s] > 0 {
+			accepted[s] = counts[s]
+		}
+	}
+
+	return accepted
+}
+
+// startWave posts a step for every target device of wave
+func (a *App) startWave(ctx context.Context, rollout *Rollout, wave int) {
+	trailsApp := trails.Build(a.mongoClient)
+	state := utils.BsonUnquoteMap(&rollout.State)
+	meta := utils.BsonUnquoteMap(&rollout.Meta)
+
+	for i := range rollout.Targets {
+		target := &rollout.Targets[i]
+		if target.Wave != wave || target.Status != TargetStatusPending {
+			continue
+		}
+
+		trailID, err := primitive.ObjectIDFromHex(target.DeviceID)
+		if err != nil {
+			target.Status = TargetStatusSkipped
+			target.Error = "invalid device id: " + err.Error()
+			continue
+		}
+
+		trail, err := trailsApp.FindTrail(ctx, trailID)
+		if err != nil {
+			target.Status = TargetStatusSkipped
+			target.Error = "device has no trail: " + err.Error()
+			continue
+		}
+
+		if trail.Owner != rollout.Owner {
+			target.Status = TargetStatusSkipped
+			target.Error = "device not owned by rollout owner"
+			continue
+		}
+
+		stepMeta := map[string]interface{}{}
+		for k, v := range meta {
+			stepMeta[k] = v
+		}
+		stepMeta["rollout"] = rollout.ID.Hex()
+		stepMeta["rollout-wave"] = wave
+
+		step := trailmodels.Step{
+			Rev:       -1,
+			CommitMsg: rollout.CommitMsg,
+			State:     state,
+			Release:   rollout.Release,
+			Meta:      stepMeta,
+		}
+
+		rerr := trailsApp.CreateStep(ctx, trail, &step, true, &trails.StepChecks{
+			SkipValidation:    rollout.SkipValidation,
+			SkipCompatibility: rollout.SkipCompatibility,
+		})
+		if rerr != nil {
+			target.Status = TargetStatusSkipped
+			target.Error = rerr.Error
+			continue
+		}
+
+		target.Status = TargetStatusPosted
+		target.StepID = step.ID
+		target.Rev = step.Rev
+	}
+
+	now := time.Now()
+	rollout.Waves[wave].StartTime = &now
+}
+
+// saveWave stores the targets of a wave that just got posted. An abort that
+// landed while posting only cancelled the steps that existed back then, so
+// the steps get cancelled once more and ErrRolloutChanged is returned.
+func (a *App) saveWave(ctx context.Context, rollout *Rollout) error {
+	err := a.saveRolloutTargets(ctx, rollout)
+	if err != nil {
+		return err
+	}
+
+	status, err := a.findRolloutStatus(ctx, rollout.ID)
+	if err != nil {
+		return err
+	}
+	if status != StatusAborted {
+		return nil
+	}
+
+	rollout.Status = StatusAborted
+	rollout.StatusMsg = "aborted by owner"
+	_, err = a.cancelRolloutSteps(ctx, rollout)
+	if err != nil {
+		return err
+	}
+
+	return ErrRolloutChanged
+}
+
+// countWave collects the progress status counts of the steps of a wave
+func (a *App) countWave(pctx context.Context, rollout *Rollout, wave int) (map[string]int, error) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+
+	counts := map[string]int{}
+	stepIDs := []string{}
+	for _, t := range rollout.Targets {
+		if t.Wave != wave {
+			continue
+		}
+		if t.Status == TargetStatusSkipped {
+			counts[TargetStatusSkipped]++
+			continue
+		}
+		if t.StepID != "" {
+			stepIDs = append(stepIDs, t.StepID)
+		}
+	}
+
+	if len(stepIDs) == 0 {
+		return counts, nil
+	}
+
+	ctx, cancel := context.WithTimeout(pctx, 30*time.Second)
+	defer cancel()
+	cur, err := coll.Aggregate(ctx, []bson.M{
+		{"$match": bson.M{
+			"_id":     bson.M{"$in": stepIDs},
+			"owner":   rollout.Owner,
+			"garbage": bson.M{"$ne": true},
+		}},
+		{"$group": bson.M{
+			"_id":   "$progress.status",
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		result := struct {
+			Status string `bson:"_id"`
+			Count  int    `bson:"count"`
+		}{}
+		err := cur.Decode(&result)
+		if err != nil {
+			return nil, err
+		}
+		counts[result.Status] = result.Count
+	}
+
+	return counts, nil
+}
+
+// ProcessRollout refreshes the progress of the current wave of a running
+// rollout and halts it, starts the next wave or finishes it accordingly.
+func (a *App) ProcessRollout(ctx context.Context, rollout *Rollout) error {
+	if rollout.Status != StatusRunning {
+		return nil
+	}
+
+	wave := rollout.CurrentWave
+	for {
+		counts, err := a.countWave(ctx, rollout, wave)
+		if err != nil {
+			return err
+		}
+		rollout.Waves[wave].Counts = counts
+
+		decision := gateWave(counts, rollout.Waves[wave].Accepted, rollout.FailureThreshold, rollout.SuccessThreshold)
+		if decision == gateWait {
+			break
+		}
+
+		now := time.Now()
+		if decision == gateHalt {
+			rollout.Status = StatusHalted
+			rollout.StatusMsg = fmt.Sprintf("wave %d passed failure threshold of %.1f%%", wave, rollout.FailureThreshold)
+			if counts[TargetStatusSkipped] == rollout.Waves[wave].Devices {
+				rollout.StatusMsg = fmt.Sprintf("wave %d could not post a step to any of its devices", wave)
+			}
+			break
+		}
+
+		rollout.Waves[wave].EndTime = &now
+		if wave == len(rollout.Waves)-1 {
+			rollout.Status = StatusDone
+			rollout.StatusMsg = "all waves finished"
+			break
+		}
+
+		wave++
+		rollout.CurrentWave = wave
+
+		// claim the wave before posting so a pause or abort that
+		// landed meanwhile keeps it from starting
+		err = a.saveRollout(ctx, rollout)
+		if err != nil {
+			return err
+		}
+
+		a.startWave(ctx, rollout, wave)
+		err = a.saveWave(ctx, rollout)
+		if err != nil {
+			return err
+		}
+	}
+
+	return a.saveRollout(ctx, rollout)
+}
+
+// ProcessRollouts processes all running rollouts
+func (a *App) ProcessRollouts(ctx context.Context) ([]ProcessResult, error) {
+	rollouts, err := a.findRollouts(ctx, bson.M{
+		"status":  StatusRunning,
+		"garbage": bson.M{"$ne": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []ProcessResult{}
+	for i := range rollouts {
+		rollout := &rollouts[i]
+		result := ProcessResult{ID: rollout.ID.Hex()}
+		err := a.ProcessRollout(ctx, rollout)
+		if err != nil {
+			log.Printf("Error processing rollout %s: %s\n", rollout.ID.Hex(), err.Error())
+			result.Error = err.Error()
+		}
+		result.Status = rollout.Status
+		result.CurrentWave = rollout.CurrentWave
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// abortRollout marks the rollout aborted and cancels all its steps that
+// devices did not pick up yet
+func (a *App) abortRollout(ctx context.Context, rollout *Rollout) (int64, error) {
+	rollout.Status = StatusAborted
+	rollout.StatusMsg = "aborted by owner"
+
+	err := a.saveRollout(ctx, rollout)
+	if err != nil {
+		return 0, err
+	}
+
+	return a.cancelRolloutSteps(ctx, rollout)
+}
+
+// cancelRolloutSteps cancels the steps of rollout still NEW or WAITING. It
+// looks them up by their meta rather than by the targets so that steps a wave
+// posted concurrently get cancelled too.
+func (a *App) cancelRolloutSteps(ctx context.Context, rollout *Rollout) (int64, error) {
+	trailsApp := trails.Build(a.mongoClient)
+	return trailsApp.CancelNewSteps(ctx, rollout.Owner, map[string]interface{}{
+		"meta.rollout": rollout.ID.Hex(),
+	}, "Cancel as rollout "+rollout.ID.Hex()+" got aborted")
+}
@@ -0,0 +1,207 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package rollouts
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_waveSizes(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   int
+		waves   []Wave
+		want    []int
+		wantErr bool
+	}{
+		{
+			name:  "single wave",
+			total: 7,
+			waves: []Wave{{Percent: 100}},
+			want:  []int{7},
+		},
+		{
+			name:  "canary waves",
+			total: 200,
+			waves: []Wave{{Percent: 1}, {Percent: 10}, {Percent: 100}},
+			want:  []int{2, 18, 180},
+		},
+		{
+			name:  "small fleet rounds up",
+			total: 3,
+			waves: []Wave{{Percent: 10}, {Percent: 50}, {Percent: 100}},
+			want:  []int{1, 1, 1},
+		},
+		{
+			name:  "empty waves on tiny fleet",
+			total: 1,
+			waves: []Wave{{Percent: 10}, {Percent: 100}},
+			want:  []int{1, 0},
+		},
+		{
+			name:    "no waves",
+			total:   3,
+			waves:   []Wave{},
+			wantErr: true,
+		},
+		{
+			name:    "not increasing",
+			total:   3,
+			waves:   []Wave{{Percent: 50}, {Percent: 50}, {Percent: 100}},
+			wantErr: true,
+		},
+		{
+			name:    "not reaching 100",
+			total:   3,
+			waves:   []Wave{{Percent: 10}, {Percent: 50}},
+			wantErr: true,
+		},
+		{
+			name:    "above 100",
+			total:   3,
+			waves:   []Wave{{Percent: 10}, {Percent: 150}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := waveSizes(tt.total, tt.waves)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("waveSizes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("waveSizes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_gateWave(t *testing.T) {
+	tests := []struct {
+		name     string
+		counts   map[string]int
+		accepted map[string]int
+		failure  float64
+		success  float64
+		want     gateDecision
+	}{
+		{
+			name:    "empty wave advances",
+			counts:  map[string]int{},
+			failure: 10,
+			success: 100,
+			want:    gateAdvance,
+		},
+		{
+			name:    "only skipped halts",
+			counts:  map[string]int{TargetStatusSkipped: 2},
+			failure: 10,
+			success: 100,
+			want:    gateHalt,
+		},
+		{
+			name:     "accepted skipped advances",
+			counts:   map[string]int{TargetStatusSkipped: 2},
+			accepted: map[string]int{TargetStatusSkipped: 2},
+			failure:  10,
+			success:  100,
+			want:     gateAdvance,
+		},
+		{
+			name:    "in progress waits",
+			counts:  map[string]int{"NEW": 3, "DONE": 7},
+			failure: 10,
+			success: 100,
+			want:    gateWait,
+		},
+		{
+			name:    "success threshold reached",
+			counts:  map[string]int{"INPROGRESS": 1, "DONE": 9},
+			failure: 10,
+			success: 90,
+			want:    gateAdvance,
+		},
+		{
+			name:    "failure threshold passed",
+			counts:  map[string]int{"ERROR": 2, "DONE": 8},
+			failure: 10,
+			success: 100,
+			want:    gateHalt,
+		},
+		{
+			name:    "failure at threshold does not halt",
+			counts:  map[string]int{"WONTGO": 1, "DONE": 9},
+			failure: 10,
+			success: 100,
+			want:    gateAdvance,
+		},
+		{
+			name:    "all final advances",
+			counts:  map[string]int{"CANCEL": 1, "DONE": 9},
+			failure: 10,
+			success: 100,
+			want:    gateAdvance,
+		},
+		{
+			name:     "accepted failures advance",
+			counts:   map[string]int{"ERROR": 2, "DONE": 8},
+			accepted: map[string]int{"ERROR": 2},
+			failure:  10,
+			success:  100,
+			want:     gateAdvance,
+		},
+		{
+			name:     "accepted failures wait for the rest",
+			counts:   map[string]int{"ERROR": 2, "NEW": 1, "DONE": 7},
+			accepted: map[string]int{"ERROR": 2},
+			failure:  10,
+			success:  100,
+			want:     gateWait,
+		},
+		{
+			name:     "failures beyond accepted halt",
+			counts:   map[string]int{"ERROR": 2, "WONTGO": 2, "DONE": 6},
+			accepted: map[string]int{"ERROR": 2},
+			failure:  10,
+			success:  100,
+			want:     gateHalt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gateWave(tt.counts, tt.accepted, tt.failure, tt.success); got != tt.want {
+				t.Errorf("gateWave() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_acceptedCounts(t *testing.T) {
+	got := acceptedCounts(map[string]int{
+		"ERROR":             2,
+		"WONTGO":            1,
+		"DONE":              6,
+		"NEW":               3,
+		TargetStatusSkipped: 1,
+	})
+	want := map[string]int{"ERROR": 2, "WONTGO": 1, TargetStatusSkipped: 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("acceptedCounts() = %v, want %v", got, want)
+	}
+}
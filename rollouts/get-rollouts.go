@@ -0,0 +1,176 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package rollouts
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RolloutStatus live status view of a rollout
+type RolloutStatus struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Status      string         `json:"status"`
+	StatusMsg   string         `json:"status-msg"`
+	CurrentWave int            `json:"current-wave"`
+	Waves       []Wave         `json:"waves"`
+	Totals      map[string]int `json:"totals"`
+}
+
+// unquoted returns a copy of the rollout with state and meta unquoted for output
+func (r Rollout) unquoted() Rollout {
+	r.State = utils.BsonUnquoteMap(&r.State)
+	r.Meta = utils.BsonUnquoteMap(&r.Meta)
+	return r
+}
+
+// handleGetRollouts list rollouts of the calling owner
+// @Summary List rollouts of the calling owner
+// @Description List rollouts of the calling owner, newest first. Use status=<STATUS> to filter.
+// @Accept  json
+// @Produce  json
+// @Tags rollouts
+// @Security ApiKeyAuth
+// @Param status query string false "Rollout status"
+// @Success 200 {array} Rollout
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /rollouts [get]
+func (a *App) handleGetRollouts(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	query := bson.M{
+		"owner":   owner,
+		"garbage": bson.M{"$ne": true},
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" {
+		query["status"] = status
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"time-created": -1})
+	findOptions.SetProjection(bson.M{"targets": 0})
+
+	rollouts, err := a.findRollouts(r.Context(), query, findOptions)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error fetching rollouts: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for i := range rollouts {
+		rollouts[i] = rollouts[i].unquoted()
+	}
+
+	w.WriteJson(rollouts)
+}
+
+// handleGetRollout get a rollout including its targets
+// @Summary Get a rollout
+// @Description Get a rollout including its target devices and their steps
+// @Accept  json
+// @Produce  json
+// @Tags rollouts
+// @Security ApiKeyAuth
+// @Param id path string true "Rollout ID"
+// @Success 200 {object} Rollout
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /rollouts/{id} [get]
+func (a *App) handleGetRollout(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	rollout, err := a.findRollout(r.Context(), r.PathParam("id"), owner)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Rollout not found: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteJson(rollout.unquoted())
+}
+
+// handleGetRolloutStatus get live status of a rollout
+// @Summary Get live status of a rollout
+// @Description Get the status of a rollout with step progress counts per wave as
+// @Description currently found in the steps of the target devices.
+// @Accept  json
+// @Produce  json
+// @Tags rollouts
+// @Security ApiKeyAuth
+// @Param id path string true "Rollout ID"
+// @Success 200 {object} RolloutStatus
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /rollouts/{id}/status [get]
+func (a *App) handleGetRolloutStatus(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	rollout, err := a.findRollout(r.Context(), r.PathParam("id"), owner)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Rollout not found: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	status := RolloutStatus{
+		ID:          rollout.ID.Hex(),
+		Name:        rollout.Name,
+		Status:      rollout.Status,
+		StatusMsg:   rollout.StatusMsg,
+		CurrentWave: rollout.CurrentWave,
+		Waves:       rollout.Waves,
+		Totals:      map[string]int{},
+	}
+
+	for i := range status.Waves {
+		if status.Waves[i].StartTime == nil {
+			status.Waves[i].Counts = map[string]int{TargetStatusPending: status.Waves[i].Devices}
+		} else {
+			counts, err := a.countWave(r.Context(), rollout, i)
+			if err != nil {
+				utils.RestErrorWrapper(w, "Error counting wave progress: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			status.Waves[i].Counts = counts
+		}
+		for k, v := range status.Waves[i].Counts {
+			status.Totals[k] += v
+		}
+	}
+
+	w.WriteJson(status)
+}
@@ -0,0 +1,124 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package rollouts
+
+import (
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/devices"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// StatusRunning rollout is posting and watching waves
+	StatusRunning = "RUNNING"
+
+	// StatusPaused rollout was paused by the owner; no new waves get started
+	StatusPaused = "PAUSED"
+
+	// StatusHalted rollout stopped because a wave passed the failure threshold
+	StatusHalted = "HALTED"
+
+	// StatusAborted rollout was aborted by the owner
+	StatusAborted = "ABORTED"
+
+	// StatusDone all waves got posted and finished
+	StatusDone = "DONE"
+)
+
+const (
+	// TargetStatusPending target device is part of a wave not started yet
+	TargetStatusPending = "PENDING"
+
+	// TargetStatusPosted a step got posted for the target device
+	TargetStatusPosted = "POSTED"
+
+	// TargetStatusSkipped posting a step for the target device failed
+	TargetStatusSkipped = "SKIPPED"
+)
+
+const (
+	// DefaultFailureThreshold percent of failed steps in a wave that halts a rollout
+	DefaultFailureThreshold = 10.0
+
+	// DefaultSuccessThreshold percent of DONE steps in a wave that starts the next wave
+	DefaultSuccessThreshold = 100.0
+)
+
+// Rollout a staged deployment of one state to a selection of devices
+type Rollout struct {
+	ID        primitive.ObjectID     `json:"id" bson:"_id"`
+	Owner     string                 `json:"owner" bson:"owner"`
+	Name      string                 `json:"name" bson:"name"`
+	CommitMsg string                 `json:"commit-msg" bson:"commit-msg"`
+	State     map[string]interface{} `json:"state" bson:"state"`
+	// Release sha of a release to post instead of State
+	Release  string                 `json:"release,omitempty" bson:"release,omitempty"`
+	Meta     map[string]interface{} `json:"meta" bson:"meta"`
+	Selector devices.Selector       `json:"selector" bson:"selector"`
+	Waves    []Wave                 `json:"waves" bson:"waves"`
+
+	// FailureThreshold percent of ERROR/WONTGO steps in a wave that halts the rollout
+	FailureThreshold float64 `json:"failure-threshold" bson:"failure-threshold"`
+	// SuccessThreshold percent of DONE steps in a wave needed to start the next wave
+	SuccessThreshold float64 `json:"success-threshold" bson:"success-threshold"`
+	// SkipValidation the rollout got created with validate=no
+	SkipValidation bool `json:"skip-validation,omitempty" bson:"skip-validation,omitempty"`
+	// SkipCompatibility the rollout got created with compatibility=no
+	SkipCompatibility bool `json:"skip-compatibility,omitempty" bson:"skip-compatibility,omitempty"`
+
+	Status       string    `json:"status" bson:"status"`
+	StatusMsg    string    `json:"status-msg" bson:"status-msg"`
+	CurrentWave  int       `json:"current-wave" bson:"current-wave"`
+	Targets      []Target  `json:"targets" bson:"targets"`
+	Garbage      bool      `json:"-" bson:"garbage"`
+	Version      int       `json:"-" bson:"version"`
+	TimeCreated  time.Time `json:"time-created" bson:"time-created"`
+	TimeModified time.Time `json:"time-modified" bson:"time-modified"`
+}
+
+// Wave one stage of a rollout
+type Wave struct {
+	// Percent cumulative percent of target devices reached once this wave is posted
+	Percent   float64        `json:"percent" bson:"percent"`
+	Devices   int            `json:"devices" bson:"devices"`
+	Counts    map[string]int `json:"counts,omitempty" bson:"counts,omitempty"`
+	StartTime *time.Time     `json:"start-time,omitempty" bson:"start-time,omitempty"`
+	EndTime   *time.Time     `json:"end-time,omitempty" bson:"end-time,omitempty"`
+	// Accepted failed and skipped counts the owner accepted when resuming
+	// the halted wave; only failures beyond these halt it again
+	Accepted map[string]int `json:"accepted,omitempty" bson:"accepted,omitempty"`
+}
+
+// Target a device the rollout deploys to
+type Target struct {
+	DeviceID string `json:"device-id" bson:"device-id"`
+	Nick     string `json:"nick" bson:"nick"`
+	Wave     int    `json:"wave" bson:"wave"`
+	Status   string `json:"status" bson:"status"`
+	StepID   string `json:"step-id,omitempty" bson:"step-id,omitempty"`
+	Rev      int    `json:"rev,omitempty" bson:"rev,omitempty"`
+	Error    string `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// ProcessResult outcome of processing a rollout
+type ProcessResult struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`
+	CurrentWave int    `json:"current-wave"`
+	Error       string `json:"error,omitempty"`
+}
@@ -0,0 +1,177 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package rollouts
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/devices"
+	"gitlab.com/pantacor/pantahub-base/trails"
+	"gitlab.com/pantacor/pantahub-base/trails/statevalidator"
+	"gitlab.com/pantacor/pantahub-base/trails/trailservices"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// handlePostRollout create and start a new rollout
+// @Summary Create and start a new rollout
+// @Description Create a staged rollout of a state to the devices matched by selector.
+// @Description Devices get split into cumulative waves (e.g. 1, 10, 100 percent). The first
+// @Description wave gets posted right away; the following waves get posted by the rollouts
+// @Description cron job once the previous wave reached the success threshold. A wave with
+// @Description more failed steps than the failure threshold halts the rollout.
+// @Accept  json
+// @Produce  json
+// @Tags rollouts
+// @Security ApiKeyAuth
+// @Param body body Rollout true "Rollout payload"
+// @Param validate query string false "no to skip the state validation of all waves"
+// @Param compatibility query string false "no to skip the device compatibility check of all waves"
+// @Success 200 {object} Rollout
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /rollouts [post]
+func (a *App) handlePostRollout(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	newRollout := Rollout{}
+	err := r.DecodeJsonPayload(&newRollout)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error decoding rollout: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if newRollout.Release != "" {
+		if len(newRollout.State) > 0 {
+			utils.RestErrorWrapper(w, "Rollout can either have a state or a release", http.StatusBadRequest)
+			return
+		}
+		trailService := trailservices.CreateService(a.mongoClient, utils.MongoDb)
+		_, rerr := trailService.GetRelease(rContext, owner, newRollout.Release)
+		if rerr != nil {
+			utils.RestErrorWrite(w, rerr)
+			return
+		}
+	} else if len(newRollout.State) == 0 {
+		utils.RestErrorWrapper(w, "Rollout needs a state or a release", http.StatusBadRequest)
+		return
+	}
+
+	newRollout.SkipValidation = !trails.ValidationRequested(r)
+	newRollout.SkipCompatibility = !trails.CompatibilityRequested(r)
+	if !newRollout.SkipValidation {
+		violations := statevalidator.Validate(newRollout.State)
+		if len(violations) > 0 {
+			trails.WriteStateViolations(w, violations)
+			return
+		}
+	}
+
+	if newRollout.FailureThreshold == 0 {
+		newRollout.FailureThreshold = DefaultFailureThreshold
+	}
+	if newRollout.SuccessThreshold == 0 {
+		newRollout.SuccessThreshold = DefaultSuccessThreshold
+	}
+	if newRollout.FailureThreshold < 0 || newRollout.FailureThreshold > 100 ||
+		newRollout.SuccessThreshold < 0 || newRollout.SuccessThreshold > 100 {
+		utils.RestErrorWrapper(w, "Thresholds must be percent values between 0 and 100", http.StatusBadRequest)
+		return
+	}
+
+	devicesApp := devices.Build(a.mongoClient)
+	targetDevices, err := devicesApp.FindDevicesBySelector(rContext, owner, &newRollout.Selector)
+	if err == devices.ErrEmptySelector {
+		utils.RestErrorWrapper(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error finding devices for selector: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(targetDevices) == 0 {
+		utils.RestErrorWrapper(w, "Selector does not match any device", http.StatusBadRequest)
+		return
+	}
+
+	sizes, err := waveSizes(len(targetDevices), newRollout.Waves)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid waves: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	newRollout.Targets = make([]Target, 0, len(targetDevices))
+	next := 0
+	for wave, size := range sizes {
+		newRollout.Waves[wave].Devices = size
+		newRollout.Waves[wave].Counts = nil
+		newRollout.Waves[wave].StartTime = nil
+		newRollout.Waves[wave].EndTime = nil
+		for i := 0; i < size; i++ {
+			newRollout.Targets = append(newRollout.Targets, Target{
+				DeviceID: targetDevices[next].ID.Hex(),
+				Nick:     targetDevices[next].Nick,
+				Wave:     wave,
+				Status:   TargetStatusPending,
+			})
+			next++
+		}
+	}
+
+	if newRollout.Meta == nil {
+		newRollout.Meta = map[string]interface{}{}
+	}
+
+	now := time.Now()
+	newRollout.ID = primitive.NewObjectID()
+	newRollout.Owner = owner
+	newRollout.State = utils.BsonQuoteMap(&newRollout.State)
+	newRollout.Meta = utils.BsonQuoteMap(&newRollout.Meta)
+	newRollout.Status = StatusRunning
+	newRollout.StatusMsg = ""
+	newRollout.CurrentWave = 0
+	newRollout.Garbage = false
+	newRollout.TimeCreated = now
+	newRollout.TimeModified = now
+
+	err = a.insertRollout(rContext, &newRollout)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error inserting rollout: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	a.startWave(rContext, &newRollout, 0)
+
+	err = a.saveWave(rContext, &newRollout)
+	if err != nil && err != ErrRolloutChanged {
+		utils.RestErrorWrapper(w, "Error saving rollout: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(newRollout.unquoted())
+}
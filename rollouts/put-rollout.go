@@ -0,0 +1,188 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package rollouts
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// handlePutRolloutPause pause a running rollout
+// @Summary Pause a running rollout
+// @Description Pause a running rollout. Steps already posted stay; no new wave gets started.
+// @Accept  json
+// @Produce  json
+// @Tags rollouts
+// @Security ApiKeyAuth
+// @Param id path string true "Rollout ID"
+// @Success 200 {object} Rollout
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 409 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /rollouts/{id}/pause [put]
+func (a *App) handlePutRolloutPause(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	rollout, err := a.findRollout(r.Context(), r.PathParam("id"), owner)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Rollout not found: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if rollout.Status != StatusRunning {
+		utils.RestErrorWrapper(w, "Only RUNNING rollouts can be paused", http.StatusConflict)
+		return
+	}
+
+	rollout.Status = StatusPaused
+	rollout.StatusMsg = "paused by owner"
+	err = a.saveRollout(r.Context(), rollout)
+	if err == ErrRolloutChanged {
+		utils.RestErrorWrapper(w, "Rollout got changed meanwhile, try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error saving rollout: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(rollout.unquoted())
+}
+
+// handlePutRolloutResume resume a paused or halted rollout
+// @Summary Resume a paused or halted rollout
+// @Description Resume a paused or halted rollout. The current wave gets evaluated right
+// @Description away. A halted wave needs accept-failures=yes: its failed and skipped
+// @Description devices get recorded as accepted and only further failures halt it again.
+// @Accept  json
+// @Produce  json
+// @Tags rollouts
+// @Security ApiKeyAuth
+// @Param id path string true "Rollout ID"
+// @Param accept-failures query string false "yes to accept the failures that halted the current wave"
+// @Success 200 {object} Rollout
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 409 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /rollouts/{id}/resume [put]
+func (a *App) handlePutRolloutResume(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	rollout, err := a.findRollout(rContext, r.PathParam("id"), owner)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Rollout not found: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if rollout.Status != StatusPaused && rollout.Status != StatusHalted {
+		utils.RestErrorWrapper(w, "Only PAUSED or HALTED rollouts can be resumed", http.StatusConflict)
+		return
+	}
+
+	if rollout.Status == StatusHalted {
+		if r.URL.Query().Get("accept-failures") != "yes" {
+			utils.RestErrorWrapper(w, "Failed steps are final; resume a HALTED rollout with accept-failures=yes", http.StatusConflict)
+			return
+		}
+
+		wave := rollout.CurrentWave
+		counts, err := a.countWave(rContext, rollout, wave)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Error counting wave: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rollout.Waves[wave].Accepted = acceptedCounts(counts)
+	}
+
+	rollout.Status = StatusRunning
+	rollout.StatusMsg = "resumed by owner"
+	err = a.ProcessRollout(rContext, rollout)
+	if err == ErrRolloutChanged {
+		utils.RestErrorWrapper(w, "Rollout got changed meanwhile, try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error processing rollout: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(rollout.unquoted())
+}
+
+// handlePutRolloutAbort abort a rollout
+// @Summary Abort a rollout
+// @Description Abort a rollout. All steps of the rollout that devices did not pick up
+// @Description yet (status NEW or WAITING) get cancelled.
+// @Accept  json
+// @Produce  json
+// @Tags rollouts
+// @Security ApiKeyAuth
+// @Param id path string true "Rollout ID"
+// @Success 200 {object} Rollout
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 409 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /rollouts/{id}/abort [put]
+func (a *App) handlePutRolloutAbort(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	rollout, err := a.findRollout(rContext, r.PathParam("id"), owner)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Rollout not found: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if rollout.Status == StatusDone || rollout.Status == StatusAborted {
+		utils.RestErrorWrapper(w, "Rollout already finished", http.StatusConflict)
+		return
+	}
+
+	_, err = a.abortRollout(rContext, rollout)
+	if err == ErrRolloutChanged {
+		utils.RestErrorWrapper(w, "Rollout got changed meanwhile, try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error aborting rollout: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(rollout.unquoted())
+}
@@ -19,6 +19,7 @@ package s3
 import (
 	"context"
 	"fmt"
+	"io"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -32,6 +33,7 @@ type S3 interface {
 	Delete(ctx context.Context, key string) error
 	Rename(ctx context.Context, oldKey, newKey string) error
 	UploadURL(ctx context.Context, key string) (string, error)
+	Put(ctx context.Context, key string, body io.Reader, size int64) error
 	DownloadURL(ctx context.Context, key string) (string, error)
 	GetConnectionParams(ctx context.Context) ConnectionParameters
 }
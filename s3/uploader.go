@@ -19,6 +19,8 @@ package s3
 import (
 	"context"
 	"fmt"
+	"io"
+	"path"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -28,6 +30,7 @@ import (
 // Uploader Uploader interface
 type Uploader interface {
 	UploadURL(ctx context.Context, key string) (string, error)
+	Put(ctx context.Context, key string, body io.Reader, size int64) error
 }
 
 func (s *s3impl) UploadURL(ctx context.Context, key string) (string, error) {
@@ -42,3 +45,21 @@ func (s *s3impl) UploadURL(ctx context.Context, key string) (string, error) {
 	}
 	return presignURL.URL, nil
 }
+
+// Put uploads size bytes of body as key. Objects are stored flat in the
+// bucket like the s3 file server does, so only the base name of key is used.
+func (s *s3impl) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(s.connectionParams.Bucket),
+		Key:           aws.String(path.Base(key)),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String("application/octet-stream"),
+	}
+
+	_, err := s.session.PutObject(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to put object: %v", err)
+	}
+	return nil
+}
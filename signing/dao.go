@@ -0,0 +1,157 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package signing
+
+import (
+	"context"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// KeysCollectionName trusted signing keys collection
+	KeysCollectionName = "pantahub_signing_keys"
+
+	// PoliciesCollectionName signing policies collection
+	PoliciesCollectionName = "pantahub_signing_policies"
+)
+
+func (a *App) setIndexes() error {
+	CreateIndexesOptions := options.CreateIndexesOptions{}
+	CreateIndexesOptions.SetMaxTime(10 * time.Second)
+
+	t := true
+
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(KeysCollectionName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "owner", Value: 1},
+				{Key: "garbage", Value: 1},
+			},
+			Options: &options.IndexOptions{
+				Background: &t,
+			},
+		},
+	}, &CreateIndexesOptions)
+
+	return err
+}
+
+func (a *App) insertKey(pctx context.Context, key *Key) error {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(KeysCollectionName)
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	_, err := collection.InsertOne(ctx, key)
+	return err
+}
+
+// deleteKey marks the key id of owner as garbage; returns mongo.ErrNoDocuments
+// if there is no such key
+func (a *App) deleteKey(pctx context.Context, id string, owner string) error {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(KeysCollectionName)
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	result, err := collection.UpdateOne(ctx, bson.M{
+		"_id":     objectID,
+		"owner":   owner,
+		"garbage": bson.M{"$ne": true},
+	}, bson.M{"$set": bson.M{"garbage": true}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// Keys returns the trusted signing keys of owner
+func (a *App) Keys(pctx context.Context, owner string) ([]Key, error) {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(KeysCollectionName)
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"time-created": 1})
+
+	cur, err := collection.Find(ctx, bson.M{
+		"owner":   owner,
+		"garbage": bson.M{"$ne": true},
+	}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	keys := []Key{}
+	err = cur.All(ctx, &keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// GetPolicy returns the signing policy of owner; owners without a stored
+// policy get the default policy which does not require signatures
+func (a *App) GetPolicy(pctx context.Context, owner string) (*Policy, error) {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(PoliciesCollectionName)
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	policy := &Policy{}
+	err := collection.FindOne(ctx, bson.M{"_id": owner}).Decode(policy)
+	if err == mongo.ErrNoDocuments {
+		return &Policy{Owner: owner}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+func (a *App) savePolicy(pctx context.Context, policy *Policy) error {
+	collection := a.mongoClient.Database(utils.MongoDb).Collection(PoliciesCollectionName)
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	policy.TimeModified = time.Now()
+	_, err := collection.ReplaceOne(ctx, bson.M{"_id": policy.Owner}, policy, options.Replace().SetUpsert(true))
+	return err
+}
@@ -0,0 +1,60 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package signing
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// handleDeleteKey remove a trusted signing key
+// @Summary Remove a trusted signing key
+// @Description Remove a trusted signing key. Steps verified with the key before
+// @Description keep their stored verification result.
+// @Accept  json
+// @Produce  json
+// @Tags signing
+// @Security ApiKeyAuth
+// @Param id path string true "Key ID"
+// @Success 200 {object} Key
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /signing/keys/{id} [delete]
+func (a *App) handleDeleteKey(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	err := a.deleteKey(r.Context(), r.PathParam("id"), owner)
+	if err == mongo.ErrNoDocuments {
+		utils.RestErrorWrapper(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error deleting key: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
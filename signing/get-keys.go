@@ -0,0 +1,52 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package signing
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// handleGetKeys list the trusted signing keys of the owner
+// @Summary List the trusted signing keys of the owner
+// @Description List the public keys trail step states get verified against
+// @Accept  json
+// @Produce  json
+// @Tags signing
+// @Security ApiKeyAuth
+// @Success 200 {array} Key
+// @Failure 400 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /signing/keys [get]
+func (a *App) handleGetKeys(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	keys, err := a.Keys(r.Context(), owner)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error finding keys: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(keys)
+}
@@ -0,0 +1,53 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package signing
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// handleGetPolicy get the signing policy of the owner
+// @Summary Get the signing policy of the owner
+// @Description Get the signing policy applied to all trails of the owner that
+// @Description do not set require-signed in their own policy
+// @Accept  json
+// @Produce  json
+// @Tags signing
+// @Security ApiKeyAuth
+// @Success 200 {object} Policy
+// @Failure 400 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /signing/policy [get]
+func (a *App) handleGetPolicy(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	policy, err := a.GetPolicy(r.Context(), owner)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error finding policy: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(policy)
+}
@@ -0,0 +1,51 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package signing
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Key trusted public key an owner signs states with
+type Key struct {
+	ID    primitive.ObjectID `json:"id" bson:"_id"`
+	Owner string             `json:"owner" bson:"owner"`
+	Name  string             `json:"name" bson:"name"`
+	// Pem PEM encoded public key or certificate
+	Pem string `json:"pem" bson:"pem"`
+	// Fingerprint hex encoded sha256 of the DER encoded public key
+	Fingerprint string    `json:"fingerprint" bson:"fingerprint"`
+	Garbage     bool      `json:"-" bson:"garbage"`
+	TimeCreated time.Time `json:"time-created" bson:"time-created"`
+}
+
+// NewKey payload to register a trusted public key
+type NewKey struct {
+	Name string `json:"name"`
+	Pem  string `json:"pem"`
+}
+
+// Policy signing policy of an owner
+type Policy struct {
+	Owner string `json:"owner" bson:"_id"`
+	// RequireSigned reject steps whose state signatures do not verify or do
+	// not cover all state entries
+	RequireSigned bool      `json:"require-signed" bson:"require-signed"`
+	TimeModified  time.Time `json:"time-modified" bson:"time-modified"`
+}
@@ -0,0 +1,95 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package signing
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// handlePostKey register a trusted signing key
+// @Summary Register a trusted signing key
+// @Description Register a PEM encoded RSA or ECDSA public key (or certificate)
+// @Description that signatures of trail step states get verified against
+// @Accept  json
+// @Produce  json
+// @Tags signing
+// @Security ApiKeyAuth
+// @Param body body NewKey true "Key payload"
+// @Success 200 {object} Key
+// @Failure 400 {object} utils.RError
+// @Failure 409 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /signing/keys [post]
+func (a *App) handlePostKey(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	newKey := NewKey{}
+	err := r.DecodeJsonPayload(&newKey)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error decoding key payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, fingerprint, err := ParsePublicKey(newKey.Pem)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid public key: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	keys, err := a.Keys(r.Context(), owner)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error finding keys: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, k := range keys {
+		if k.Fingerprint == fingerprint {
+			utils.RestErrorWrapper(w, "Key already registered as "+k.ID.Hex(), http.StatusConflict)
+			return
+		}
+	}
+
+	key := Key{
+		ID:          primitive.NewObjectID(),
+		Owner:       owner,
+		Name:        strings.TrimSpace(newKey.Name),
+		Pem:         newKey.Pem,
+		Fingerprint: fingerprint,
+		TimeCreated: time.Now(),
+	}
+	if key.Name == "" {
+		key.Name = fingerprint[:16]
+	}
+
+	err = a.insertKey(r.Context(), &key)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error saving key: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(key)
+}
@@ -0,0 +1,62 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package signing
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// handlePutPolicy update the signing policy of the owner
+// @Summary Update the signing policy of the owner
+// @Description With require-signed set, new steps get rejected unless all state
+// @Description signatures verify against a trusted key and cover all state entries
+// @Accept  json
+// @Produce  json
+// @Tags signing
+// @Security ApiKeyAuth
+// @Param body body Policy true "Policy payload"
+// @Success 200 {object} Policy
+// @Failure 400 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /signing/policy [put]
+func (a *App) handlePutPolicy(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"].(string)
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	policy := Policy{}
+	err := r.DecodeJsonPayload(&policy)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error decoding policy payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	policy.Owner = owner
+
+	err = a.savePolicy(r.Context(), &policy)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error saving policy: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(policy)
+}
@@ -0,0 +1,150 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+// Package signing manages the trusted keys owners sign states with and the
+// policy that decides if trail steps must carry verified signatures
+package signing
+
+import (
+	"log"
+	"os"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwt "github.com/pantacor/go-json-rest-middleware-jwt"
+	"gitlab.com/pantacor/pantahub-base/accounts"
+	"gitlab.com/pantacor/pantahub-base/metrics"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"gitlab.com/pantacor/pantahub-base/utils/tracer"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// App define a new rest application for signing
+type App struct {
+	jwtMiddleware *jwt.JWTMiddleware
+	API           *rest.Api
+	mongoClient   *mongo.Client
+}
+
+// Build factory a new signing App only with mongoClient
+func Build(mongoClient *mongo.Client) *App {
+	return &App{
+		mongoClient: mongoClient,
+	}
+}
+
+// New create a signing rest application
+func New(jwtMiddleware *jwt.JWTMiddleware,
+	mongoClient *mongo.Client) *App {
+
+	app := new(App)
+	app.jwtMiddleware = jwtMiddleware
+	app.mongoClient = mongoClient
+
+	err := app.setIndexes()
+	if err != nil {
+		log.Fatalln("Error setting up index for " + KeysCollectionName + ": " + err.Error())
+		return nil
+	}
+
+	app.API = rest.NewApi()
+	// we dont use default stack because we dont want content type enforcement
+	app.API.Use(&rest.AccessLogJsonMiddleware{Logger: log.New(os.Stdout,
+		"/signing:", log.Lshortfile)})
+	app.API.Use(&utils.AccessLogFluentMiddleware{Prefix: "signing"})
+	app.API.Use(&rest.StatusMiddleware{})
+	app.API.Use(&rest.TimerMiddleware{})
+	app.API.Use(&metrics.Middleware{})
+	app.API.Use(rest.DefaultCommonStack...)
+	app.API.Use(&rest.CorsMiddleware{
+		RejectNonCorsRequests: false,
+		OriginValidator: func(origin string, request *rest.Request) bool {
+			return true
+		},
+		AllowedMethods:                []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:                []string{"Accept", "Content-Type", "X-Custom-Header", "Origin", "Authorization", "Content-Length"},
+		AccessControlAllowCredentials: true,
+		AccessControlMaxAge:           3600,
+	})
+
+	app.API.Use(&rest.IfMiddleware{
+		Condition: func(request *rest.Request) bool {
+			// all need auth
+			return true
+		},
+		IfTrue: app.jwtMiddleware,
+	})
+
+	app.API.Use(&rest.IfMiddleware{
+		Condition: func(request *rest.Request) bool {
+			// all need auth
+			return true
+		},
+		IfTrue: &utils.AuthMiddleware{},
+	})
+
+	readScopes := []utils.Scope{
+		utils.Scopes.API,
+		utils.Scopes.Trails,
+		utils.Scopes.ReadTrails,
+	}
+
+	writeScopes := []utils.Scope{
+		utils.Scopes.API,
+		utils.Scopes.Trails,
+		utils.Scopes.WriteTrails,
+	}
+
+	onlyUserFilter := []accounts.AccountType{
+		accounts.AccountTypeUser,
+		accounts.AccountTypeSessionUser,
+	}
+
+	read := func(handler rest.HandlerFunc) rest.HandlerFunc {
+		return rest.WrapMiddlewares(
+			[]rest.Middleware{
+				utils.InitUserTypeFilterMiddleware(onlyUserFilter),
+				utils.InitScopeFilterMiddleware(readScopes),
+			},
+			handler,
+		)
+	}
+
+	write := func(handler rest.HandlerFunc) rest.HandlerFunc {
+		return rest.WrapMiddlewares(
+			[]rest.Middleware{
+				utils.InitUserTypeFilterMiddleware(onlyUserFilter),
+				utils.InitScopeFilterMiddleware(writeScopes),
+			},
+			handler,
+		)
+	}
+
+	apiRouter, _ := rest.MakeRouter(
+		rest.Get("/keys", read(app.handleGetKeys)),
+		rest.Post("/keys", write(app.handlePostKey)),
+		rest.Delete("/keys/#id", write(app.handleDeleteKey)),
+		rest.Get("/policy", read(app.handleGetPolicy)),
+		rest.Put("/policy", write(app.handlePutPolicy)),
+	)
+
+	app.API.Use(&tracer.OtelMiddleware{
+		ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
+		Router:      apiRouter,
+	})
+	app.API.SetApp(apiRouter)
+
+	return app
+}
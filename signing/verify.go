@@ -0,0 +1,341 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar"
+	cjson "github.com/gibson042/canonicaljson-go"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pvr/utils/pvjson"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const (
+	// SigsPrefix state key prefix of pvs signature entries
+	SigsPrefix = "_sigs/"
+
+	// SpecPvs #spec of the pvs signature entries that can be verified
+	SpecPvs = "pvs@2"
+)
+
+// unsignedPatterns state keys that never need to be covered by a signature;
+// src.json files are excluded by pvr when signing by default
+var unsignedPatterns = []string{"#spec", SigsPrefix + "**", "**/src.json", "src.json"}
+
+// pvsMatch pvs protected header selecting the signed state entries
+type pvsMatch struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+type trustedKey struct {
+	id  string
+	key interface{}
+}
+
+// ParsePublicKey parses a PEM encoded RSA or ECDSA public key or certificate
+// and returns the public key with its fingerprint
+func ParsePublicKey(pemData string) (interface{}, string, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil {
+		return nil, "", errors.New("no PEM block found")
+	}
+
+	var pub interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			pub = cert.PublicKey
+		}
+	default:
+		return nil, "", errors.New("unsupported PEM block type " + block.Type)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch pub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return nil, "", errors.New("only RSA and ECDSA keys are supported")
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(der)
+
+	return pub, hex.EncodeToString(sum[:]), nil
+}
+
+// HasSignatures tells if state carries any pvs signature entry
+func HasSignatures(state map[string]interface{}) bool {
+	for key := range state {
+		if strings.HasPrefix(key, SigsPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeState re-reads state the way pvr does so that the payload of a
+// signature gets assembled byte by byte as it was signed
+func normalizeState(state map[string]interface{}) (map[string]interface{}, error) {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized := map[string]interface{}{}
+	err = pvjson.Unmarshal(buf, &normalized)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalized, nil
+}
+
+// matchAny tells if key matches one of the doublestar patterns
+func matchAny(patterns []string, key string) (bool, error) {
+	for _, pattern := range patterns {
+		m, err := doublestar.Match(pattern, key)
+		if err != nil {
+			return false, err
+		}
+		if m {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// selectEntries returns the entries of state included and not excluded by match
+// as well as the keys excluded explicitly
+func selectEntries(state map[string]interface{}, match pvsMatch) (map[string]interface{}, []string, error) {
+	selected := map[string]interface{}{}
+	excluded := []string{}
+	for key, v := range state {
+		excl, err := matchAny(match.Exclude, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if excl {
+			excluded = append(excluded, key)
+			continue
+		}
+		incl, err := matchAny(match.Include, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if incl {
+			selected[key] = v
+		}
+	}
+	return selected, excluded, nil
+}
+
+// protectedMatch reads the pvs header from the (first) protected header of
+// the JWS JSON serialization doc
+func protectedMatch(doc map[string]interface{}) (*pvsMatch, error) {
+	protected, ok := doc["protected"].(string)
+	if !ok {
+		sigs, _ := doc["signatures"].([]interface{})
+		if len(sigs) > 0 {
+			if first, ok := sigs[0].(map[string]interface{}); ok {
+				protected, _ = first["protected"].(string)
+			}
+		}
+	}
+	if protected == "" {
+		return nil, errors.New("JWS has no protected header")
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(protected, "="))
+	if err != nil {
+		return nil, errors.New("invalid JWS protected header: " + err.Error())
+	}
+
+	header := struct {
+		Pvs *pvsMatch `json:"pvs"`
+	}{}
+	err = json.Unmarshal(buf, &header)
+	if err != nil {
+		return nil, errors.New("invalid JWS protected header: " + err.Error())
+	}
+	if header.Pvs == nil || header.Pvs.Include == nil {
+		return nil, errors.New("JWS has no valid pvs protected header")
+	}
+
+	return header.Pvs, nil
+}
+
+// verifySignature verifies the pvs signature entry name of state against keys
+func verifySignature(state map[string]interface{}, name string, keys []trustedKey) trailmodels.SignatureCheck {
+	check := trailmodels.SignatureCheck{Name: name}
+
+	doc, ok := state[name].(map[string]interface{})
+	if !ok {
+		check.Error = "signature entry is not a json object"
+		return check
+	}
+	if spec, _ := doc["#spec"].(string); spec != SpecPvs {
+		check.Error = "signature must be of #spec " + SpecPvs
+		return check
+	}
+
+	match, err := protectedMatch(doc)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	selected, excluded, err := selectEntries(state, *match)
+	if err != nil {
+		check.Error = "invalid pvs pattern: " + err.Error()
+		return check
+	}
+	payload, err := cjson.Marshal(selected)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+
+	// pvs signatures are detached; put the payload back in for parsing
+	attached := map[string]interface{}{}
+	for k, v := range doc {
+		attached[k] = v
+	}
+	attached["payload"] = base64.RawURLEncoding.EncodeToString(payload)
+	buf, err := json.Marshal(attached)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	sig, err := jose.ParseSigned(string(buf))
+	if err != nil {
+		check.Error = "invalid JWS: " + err.Error()
+		return check
+	}
+
+	for _, k := range keys {
+		if sig.DetachedVerify(payload, k.key) != nil {
+			continue
+		}
+		check.Verified = true
+		check.KeyID = k.id
+		break
+	}
+	if !check.Verified {
+		check.Error = "no trusted key verifies the signature"
+		return check
+	}
+
+	for key := range selected {
+		check.Protected = append(check.Protected, key)
+	}
+	sort.Strings(check.Protected)
+	sort.Strings(excluded)
+	if len(excluded) > 0 {
+		check.Excluded = excluded
+	}
+
+	return check
+}
+
+// VerifyState verifies all pvs signatures of state against keys. The result
+// is Verified if there is at least one signature and all of them verify; it
+// is Complete if, in addition, every state entry is protected by one of them.
+func VerifyState(state map[string]interface{}, keys []Key) (*trailmodels.StepSignatures, error) {
+	normalized, err := normalizeState(state)
+	if err != nil {
+		return nil, err
+	}
+
+	trusted := []trustedKey{}
+	for _, k := range keys {
+		pub, _, err := ParsePublicKey(k.Pem)
+		if err != nil {
+			continue
+		}
+		trusted = append(trusted, trustedKey{id: k.ID.Hex(), key: pub})
+	}
+
+	names := []string{}
+	for key := range normalized {
+		if strings.HasPrefix(key, SigsPrefix) {
+			names = append(names, key)
+		}
+	}
+	sort.Strings(names)
+
+	result := &trailmodels.StepSignatures{
+		Verified:   len(names) > 0,
+		Signatures: []trailmodels.SignatureCheck{},
+		Time:       time.Now(),
+	}
+
+	protected := map[string]bool{}
+	for _, name := range names {
+		check := verifySignature(normalized, name, trusted)
+		result.Signatures = append(result.Signatures, check)
+		if !check.Verified {
+			result.Verified = false
+			continue
+		}
+		for _, key := range check.Protected {
+			protected[key] = true
+		}
+	}
+
+	for key := range normalized {
+		if protected[key] {
+			continue
+		}
+		unsigned, err := matchAny(unsignedPatterns, key)
+		if err != nil {
+			return nil, err
+		}
+		if !unsigned {
+			result.Uncovered = append(result.Uncovered, key)
+		}
+	}
+	sort.Strings(result.Uncovered)
+	result.Complete = result.Verified && len(result.Uncovered) == 0
+
+	return result, nil
+}
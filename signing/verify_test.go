@@ -0,0 +1,193 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package signing
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"reflect"
+	"testing"
+
+	cjson "github.com/gibson042/canonicaljson-go"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	jose "gopkg.in/square/go-jose.v2"
+)
+
+const sha = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func newState() map[string]interface{} {
+	return map[string]interface{}{
+		"#spec":             "pantavisor-multi-platform@1",
+		"bsp/run.json":      map[string]interface{}{"linux": "kernel.img", "initrd": "pantavisor"},
+		"bsp/kernel.img":    sha,
+		"bsp/pantavisor":    sha,
+		"bsp/src.json":      map[string]interface{}{"#spec": "bsp-manifest-src@1"},
+		"app/run.json":      map[string]interface{}{"name": "app"},
+		"app/root.squashfs": sha,
+	}
+}
+
+// sign adds a pvs signature entry name to state protecting the entries
+// selected by include and exclude the way pvr does
+func sign(t *testing.T, state map[string]interface{}, key *rsa.PrivateKey, name string, include, exclude []string) {
+	match := pvsMatch{Include: include, Exclude: exclude}
+	selected, _, err := selectEntries(state, match)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := cjson.Marshal(selected)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithHeader("pvs", match).WithType("PVS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := map[string]interface{}{}
+	err = json.Unmarshal([]byte(jws.FullSerialize()), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(doc, "payload")
+	doc["#spec"] = SpecPvs
+	state[SigsPrefix+name+".json"] = doc
+}
+
+func publicKeyPem(t *testing.T, key *rsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestParsePublicKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, fingerprint, err := ParsePublicKey(publicKeyPem(t, key))
+	if err != nil {
+		t.Fatalf("ParsePublicKey() error = %v", err)
+	}
+	if len(fingerprint) != 64 {
+		t.Errorf("ParsePublicKey() fingerprint = %s, want hex sha256", fingerprint)
+	}
+
+	_, _, err = ParsePublicKey("not a pem")
+	if err == nil {
+		t.Errorf("ParsePublicKey() expected error for invalid pem")
+	}
+}
+
+func TestVerifyState(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusted := []Key{{ID: primitive.NewObjectID(), Pem: publicKeyPem(t, key)}}
+
+	tests := []struct {
+		name          string
+		build         func() map[string]interface{}
+		wantVerified  bool
+		wantComplete  bool
+		wantUncovered []string
+	}{
+		{
+			name: "unsigned",
+			build: func() map[string]interface{} {
+				return newState()
+			},
+			wantUncovered: []string{"app/root.squashfs", "app/run.json", "bsp/kernel.img", "bsp/pantavisor", "bsp/run.json"},
+		},
+		{
+			name: "fully signed",
+			build: func() map[string]interface{} {
+				state := newState()
+				sign(t, state, key, "bsp", []string{"bsp/**"}, []string{"**/src.json"})
+				sign(t, state, key, "app", []string{"app/**"}, nil)
+				return state
+			},
+			wantVerified: true,
+			wantComplete: true,
+		},
+		{
+			name: "platform not covered",
+			build: func() map[string]interface{} {
+				state := newState()
+				sign(t, state, key, "bsp", []string{"bsp/**"}, []string{"**/src.json"})
+				return state
+			},
+			wantVerified:  true,
+			wantUncovered: []string{"app/root.squashfs", "app/run.json"},
+		},
+		{
+			name: "tampered",
+			build: func() map[string]interface{} {
+				state := newState()
+				sign(t, state, key, "bsp", []string{"bsp/**"}, []string{"**/src.json"})
+				sign(t, state, key, "app", []string{"app/**"}, nil)
+				state["app/run.json"] = map[string]interface{}{"name": "evil"}
+				return state
+			},
+			wantUncovered: []string{"app/root.squashfs", "app/run.json"},
+		},
+		{
+			name: "untrusted key",
+			build: func() map[string]interface{} {
+				state := newState()
+				sign(t, state, key, "bsp", []string{"bsp/**"}, []string{"**/src.json"})
+				sign(t, state, otherKey, "app", []string{"app/**"}, nil)
+				return state
+			},
+			wantUncovered: []string{"app/root.squashfs", "app/run.json"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyState(tt.build(), trusted)
+			if err != nil {
+				t.Fatalf("VerifyState() error = %v", err)
+			}
+			if got.Verified != tt.wantVerified {
+				t.Errorf("VerifyState() verified = %v, want %v (%+v)", got.Verified, tt.wantVerified, got.Signatures)
+			}
+			if got.Complete != tt.wantComplete {
+				t.Errorf("VerifyState() complete = %v, want %v", got.Complete, tt.wantComplete)
+			}
+			if !reflect.DeepEqual(got.Uncovered, tt.wantUncovered) {
+				t.Errorf("VerifyState() uncovered = %v, want %v", got.Uncovered, tt.wantUncovered)
+			}
+		})
+	}
+}
@@ -18,7 +18,10 @@ package storagedriver
 
 import (
 	"context"
+	"errors"
+	"io"
 	"os"
+	"path/filepath"
 )
 
 type localStorageDriver struct{}
@@ -28,6 +31,35 @@ func (l *localStorageDriver) Exists(ctx context.Context, filepath string) bool {
 	return err == nil
 }
 
+// Put writes size bytes of body to a temporary file next to key and
+// renames it into place once complete
+func (l *localStorageDriver) Put(ctx context.Context, key string, body io.Reader, size int64) error {
+	dir := filepath.Dir(key)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(dir, filepath.Base(key)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	written, err := io.CopyN(file, body, size)
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return errors.New("size mismatch writing " + key)
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), key)
+}
+
 // NewLocalStorageDriver create new local storage driver
 func NewLocalStorageDriver() StorageDriver {
 	return &localStorageDriver{}
@@ -18,6 +18,7 @@ package storagedriver
 
 import (
 	"context"
+	"io"
 
 	"gitlab.com/pantacor/pantahub-base/s3"
 	"gitlab.com/pantacor/pantahub-base/utils"
@@ -26,6 +27,7 @@ import (
 // StorageDriver storage drive interface
 type StorageDriver interface {
 	Exists(ctx context.Context, key string) bool
+	Put(ctx context.Context, key string, body io.Reader, size int64) error
 }
 
 // FromEnv get storage driver from environment
@@ -116,26 +116,34 @@ var (
 	// SubscriptionProperties define the subscriptions capabilities
 	SubscriptionProperties = map[utils.Prn]interface{}{
 		SubscriptionTypeFree: map[string]interface{}{
-			"OBJECTS":   "2GiB",
-			"BANDWIDTH": "2GiB",
-			"DEVICES":   "25",
+			"OBJECTS":                         "2GiB",
+			"BANDWIDTH":                       "2GiB",
+			"DEVICES":                         "25",
+			"RETENTION-KEEP-REVS":             "0",
+			"RETENTION-DROP-NOT-APPLIED-DAYS": "0",
 		},
 		SubscriptionTypeVIP: map[string]interface{}{
-			"OBJECTS":   "20GiB",
-			"BANDWIDTH": "10GiB",
-			"DEVICES":   "100",
+			"OBJECTS":                         "20GiB",
+			"BANDWIDTH":                       "10GiB",
+			"DEVICES":                         "100",
+			"RETENTION-KEEP-REVS":             "0",
+			"RETENTION-DROP-NOT-APPLIED-DAYS": "0",
 		},
 		SubscriptionTypeLocked:    nil,
 		SubscriptionTypeCancelled: nil,
 		SubscriptionTypeCustom: map[string]interface{}{
-			"OBJECTS":   "0GiB",
-			"BANDWIDTH": "0GiB",
-			"DEVICES":   "0",
+			"OBJECTS":                         "0GiB",
+			"BANDWIDTH":                       "0GiB",
+			"DEVICES":                         "0",
+			"RETENTION-KEEP-REVS":             "0",
+			"RETENTION-DROP-NOT-APPLIED-DAYS": "0",
 		},
 		SubscriptionTypeStripe: map[string]interface{}{
-			"OBJECTS":   "0GiB",
-			"BANDWIDTH": "0GiB",
-			"DEVICES":   "0",
+			"OBJECTS":                         "0GiB",
+			"BANDWIDTH":                       "0GiB",
+			"DEVICES":                         "0",
+			"RETENTION-KEEP-REVS":             "0",
+			"RETENTION-DROP-NOT-APPLIED-DAYS": "0",
 		},
 	}
 )
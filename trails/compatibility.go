@@ -0,0 +1,248 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"gitlab.com/pantacor/pantahub-base/devices"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// CompatibilityKey state key holding the device compatibility requirements
+// of a state. It maps device-meta keys to the required values.
+const CompatibilityKey = "_compat.json"
+
+// Incompatibility a requirement of a state that a device does not meet
+type Incompatibility struct {
+	Key      string      `json:"key"`
+	Required interface{} `json:"required"`
+	Actual   interface{} `json:"actual,omitempty"`
+	Msg      string      `json:"msg"`
+}
+
+// CompatibilityError error response for a step the device is not compatible with
+type CompatibilityError struct {
+	Error             string            `json:"error"`
+	Code              int               `json:"code"`
+	Device            string            `json:"device"`
+	Incompatibilities []Incompatibility `json:"incompatibilities"`
+}
+
+// CompatibilityRequested tells if the compatibility of a posted state with
+// the device should get checked; clients can override with compatibility=no
+func CompatibilityRequested(r *rest.Request) bool {
+	value, ok := r.URL.Query()["compatibility"]
+	return !ok || value[0] != "no"
+}
+
+// WriteIncompatibilities writes the error response for a step device is not
+// compatible with
+func WriteIncompatibilities(w rest.ResponseWriter, device string, incompatibilities []Incompatibility) {
+	w.WriteHeader(http.StatusConflict)
+	w.WriteJson(CompatibilityError{
+		Error:             "Device not compatible with state",
+		Code:              http.StatusConflict,
+		Device:            device,
+		Incompatibilities: incompatibilities,
+	})
+}
+
+// compareVersions compares two version strings like "019-rc2" run by run:
+// digit runs numerically, everything else as text. It returns -1, 0 or 1.
+func compareVersions(a, b string) int {
+	ra, rb := versionRuns(a), versionRuns(b)
+	for i := 0; i < len(ra) && i < len(rb); i++ {
+		na, errA := strconv.ParseUint(ra[i], 10, 64)
+		nb, errB := strconv.ParseUint(rb[i], 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			if na != nb {
+				if na < nb {
+					return -1
+				}
+				return 1
+			}
+		case ra[i] != rb[i]:
+			if ra[i] < rb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(ra) < len(rb):
+		return -1
+	case len(ra) > len(rb):
+		return 1
+	}
+	return 0
+}
+
+// versionRuns splits v in runs of digits and runs of other characters,
+// dropping the separators . - _ and +
+func versionRuns(v string) []string {
+	runs := []string{}
+	current := ""
+	digits := false
+	for _, c := range v {
+		if strings.ContainsRune(".-_+", c) {
+			if current != "" {
+				runs = append(runs, current)
+			}
+			current = ""
+			continue
+		}
+		isDigit := c >= '0' && c <= '9'
+		if current != "" && isDigit != digits {
+			runs = append(runs, current)
+			current = ""
+		}
+		digits = isDigit
+		current += string(c)
+	}
+	if current != "" {
+		runs = append(runs, current)
+	}
+	return runs
+}
+
+// matchRequirement tells if actual meets the requirement req. req can be a
+// comparison (">=019", "<020", "!=018", ...) against a version, a pattern with
+// wildcards ("rpi4*") or a plain value that must match exactly.
+func matchRequirement(req string, actual string) bool {
+	for _, op := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if !strings.HasPrefix(req, op) {
+			continue
+		}
+		c := compareVersions(actual, strings.TrimSpace(strings.TrimPrefix(req, op)))
+		switch op {
+		case ">=":
+			return c >= 0
+		case "<=":
+			return c <= 0
+		case "!=":
+			return c != 0
+		case ">":
+			return c > 0
+		case "<":
+			return c < 0
+		default:
+			return c == 0
+		}
+	}
+	if strings.ContainsAny(req, "*?[") {
+		ok, err := path.Match(req, actual)
+		return err == nil && ok
+	}
+	return req == actual
+}
+
+// checkCompatibility checks the requirements against the device-meta of a
+// device. A requirement is a string or a list of strings of which one has to
+// match (see matchRequirement); devices not reporting a required key are not
+// compatible. Incompatibilities are sorted by key.
+func checkCompatibility(requirements map[string]interface{}, deviceMeta map[string]interface{}) []Incompatibility {
+	incompatibilities := []Incompatibility{}
+
+	for key, required := range requirements {
+		var reqs []string
+		switch v := required.(type) {
+		case []interface{}:
+			for _, r := range v {
+				reqs = append(reqs, fmt.Sprint(r))
+			}
+		default:
+			reqs = []string{fmt.Sprint(v)}
+		}
+
+		actual, ok := deviceMeta[key]
+		if !ok {
+			incompatibilities = append(incompatibilities, Incompatibility{
+				Key:      key,
+				Required: required,
+				Msg:      "device does not report " + key,
+			})
+			continue
+		}
+
+		actualStr := fmt.Sprint(actual)
+		matched := false
+		for _, r := range reqs {
+			if matchRequirement(r, actualStr) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			incompatibilities = append(incompatibilities, Incompatibility{
+				Key:      key,
+				Required: required,
+				Actual:   actual,
+				Msg:      key + " is " + actualStr + ", needs " + strings.Join(reqs, " or "),
+			})
+		}
+	}
+
+	sort.Slice(incompatibilities, func(i, j int) bool {
+		return incompatibilities[i].Key < incompatibilities[j].Key
+	})
+	return incompatibilities
+}
+
+// compatibilityRequirements returns the requirements of state, if any
+func compatibilityRequirements(state map[string]interface{}) (map[string]interface{}, *utils.RError) {
+	value, ok := state[CompatibilityKey]
+	if !ok {
+		return nil, nil
+	}
+	requirements, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, &utils.RError{Error: CompatibilityKey + " must be an object", Code: http.StatusBadRequest}
+	}
+	return requirements, nil
+}
+
+// CheckStepCompatibility checks the requirements of the (unquoted) state of
+// step against the device-meta of the device of trail. Steps referencing a
+// release must have the state of the release loaded.
+func (a *App) CheckStepCompatibility(
+	ctx context.Context,
+	trail *trailmodels.Trail,
+	step *trailmodels.Step,
+) ([]Incompatibility, *utils.RError) {
+	requirements, rerr := compatibilityRequirements(step.State)
+	if rerr != nil || len(requirements) == 0 {
+		return nil, rerr
+	}
+
+	device := devices.Device{}
+	err := devices.Build(a.mongoClient).FindDeviceByID(ctx, trail.ID, &device)
+	if err != nil {
+		return nil, &utils.RError{Error: "Error finding device of trail: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	return checkCompatibility(requirements, utils.BsonUnquoteMap(&device.DeviceMeta)), nil
+}
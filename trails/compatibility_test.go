@@ -0,0 +1,101 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"testing"
+
+	"gitlab.com/pantacor/pantahub-base/trails/statevalidator"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"019", "019", 0},
+		{"019", "020", -1},
+		{"020", "019", 1},
+		{"019-rc2", "019-rc10", -1},
+		{"1.10.0", "1.9.3", 1},
+		{"018-45-gabc", "019", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	deviceMeta := map[string]interface{}{
+		"pantavisor.arch":    "aarch64/64/EL",
+		"pantavisor.version": "019-rc3",
+		"pantavisor.dtmodel": "Raspberry Pi 4 Model B Rev 1.4",
+	}
+
+	tests := []struct {
+		name         string
+		requirements map[string]interface{}
+		want         []string
+	}{
+		{"compatible", map[string]interface{}{
+			"pantavisor.arch":    []interface{}{"armv7l/32/EL", "aarch64/64/EL"},
+			"pantavisor.version": ">=019-rc1",
+			"pantavisor.dtmodel": "Raspberry Pi 4*",
+		}, []string{}},
+		{"wrong arch", map[string]interface{}{
+			"pantavisor.arch": "armv7l/32/EL",
+		}, []string{"pantavisor.arch"}},
+		{"too old and unknown key", map[string]interface{}{
+			"pantavisor.version": ">=020",
+			"pantavisor.bsp":     "rpi4",
+		}, []string{"pantavisor.bsp", "pantavisor.version"}},
+		{"excluded version", map[string]interface{}{
+			"pantavisor.version": "!=019-rc3",
+		}, []string{"pantavisor.version"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkCompatibility(tt.requirements, deviceMeta)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want keys %v", got, tt.want)
+			}
+			for i, inc := range got {
+				if inc.Key != tt.want[i] || inc.Msg == "" {
+					t.Errorf("got %v, want keys %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompatibilityKeyIsValid(t *testing.T) {
+	state := map[string]interface{}{
+		"#spec":          "pantavisor-multi-platform@1",
+		CompatibilityKey: map[string]interface{}{"pantavisor.arch": "aarch64/64/EL"},
+	}
+	if _, rerr := compatibilityRequirements(state); rerr != nil {
+		t.Fatalf("unexpected error %s", rerr.Error)
+	}
+	for _, v := range statevalidator.Validate(state) {
+		if v.Key == CompatibilityKey {
+			t.Errorf("unexpected violation %v", v)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/trails/trailservices"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"gitlab.com/pantacor/pvr/libpvr"
+)
+
+// composeState returns a copy of base with the entries selected by frags
+// replaced by the entries of fragment
+func composeState(base map[string]interface{}, frags string, fragment map[string]interface{}) (map[string]interface{}, error) {
+	if frags == "" {
+		return nil, errors.New("frags must not be empty")
+	}
+	for _, frag := range strings.Split(frags, ",") {
+		if frag == "" || strings.HasPrefix(frag, "-") {
+			return nil, errors.New("invalid frag '" + frag + "'")
+		}
+	}
+
+	replaced, err := libpvr.FilterByFrags(base, frags)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]interface{}{}
+	for k, v := range base {
+		if _, ok := replaced[k]; ok {
+			continue
+		}
+		result[k] = v
+	}
+	for k, v := range fragment {
+		result[k] = v
+	}
+
+	return result, nil
+}
+
+// findComposeStep finds the step rev (number, tag or latest) of trailID if the
+// caller has read access to it
+func (a *App) findComposeStep(pctx context.Context, trailID string, rev string, owner interface{}, authType interface{}) (*trailmodels.Step, *utils.RError) {
+	if rev == "" || rev == "latest" {
+		trailService := trailservices.CreateService(a.mongoClient, utils.MongoDb)
+		latest, rerr := trailService.GetStepRev(pctx, trailID, "latest")
+		if rerr != nil {
+			return nil, &utils.RError{Error: "No step found for trail " + trailID, Code: http.StatusNotFound}
+		}
+		rev = strconv.Itoa(latest.Rev)
+	} else {
+		var rerr *utils.RError
+		rev, rerr = a.resolveRev(pctx, trailID, rev)
+		if rerr != nil {
+			return nil, rerr
+		}
+	}
+
+	step, err := a.findStepForRead(pctx, trailID, rev, owner, authType)
+	if err != nil {
+		return nil, &utils.RError{Error: "No access to step " + trailID + "-" + rev, Code: http.StatusNotFound}
+	}
+
+	return step, nil
+}
+
+// ComposeState builds the state of the base step of trail with the fragments
+// of compose.Parts taken from the steps of other trails
+func (a *App) ComposeState(
+	pctx context.Context,
+	trail *trailmodels.Trail,
+	compose *trailmodels.ComposeStep,
+	owner interface{},
+	authType interface{},
+) (map[string]interface{}, *utils.RError) {
+	if len(compose.Parts) == 0 {
+		return nil, &utils.RError{Error: "Compose needs at least one part", Code: http.StatusBadRequest}
+	}
+
+	baseStep, rerr := a.findComposeStep(pctx, trail.ID.Hex(), compose.Base, owner, authType)
+	if rerr != nil {
+		return nil, rerr
+	}
+	state := utils.BsonUnquoteMap(&baseStep.State)
+
+	for i, part := range compose.Parts {
+		partStep, rerr := a.findComposeStep(pctx, part.Trail, part.Rev, owner, authType)
+		if rerr != nil {
+			return nil, rerr
+		}
+		partState := utils.BsonUnquoteMap(&partStep.State)
+
+		fragment, err := libpvr.FilterByFrags(partState, part.Frags)
+		if err != nil {
+			return nil, &utils.RError{Error: "Error filtering part " + strconv.Itoa(i) + ": " + err.Error(), Code: http.StatusBadRequest}
+		}
+		if part.Frags == "" || len(fragment) == 0 {
+			return nil, &utils.RError{
+				Error: "Part " + strconv.Itoa(i) + " frags '" + part.Frags + "' match nothing in step " + partStep.ID,
+				Code:  http.StatusBadRequest,
+			}
+		}
+
+		state, err = composeState(state, part.Frags, fragment)
+		if err != nil {
+			return nil, &utils.RError{Error: "Error composing part " + strconv.Itoa(i) + ": " + err.Error(), Code: http.StatusBadRequest}
+		}
+	}
+
+	return state, nil
+}
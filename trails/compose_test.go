@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComposeState(t *testing.T) {
+	base := map[string]interface{}{
+		"#spec":                   "pantavisor-multi-platform@1",
+		"bsp/kernel.img":          "a1",
+		"bsp/modules.squashfs":    "a2",
+		"nginx/run.json":          map[string]interface{}{"name": "nginx"},
+		"nginx/root.squashfs":     "a3",
+		"awconnect/root.squashfs": "a4",
+	}
+
+	tests := []struct {
+		name     string
+		frags    string
+		fragment map[string]interface{}
+		want     map[string]interface{}
+		wantErr  bool
+	}{
+		{
+			name:  "replace bsp",
+			frags: "bsp",
+			fragment: map[string]interface{}{
+				"bsp/kernel.img": "b1",
+			},
+			want: map[string]interface{}{
+				"#spec":                   "pantavisor-multi-platform@1",
+				"bsp/kernel.img":          "b1",
+				"nginx/run.json":          map[string]interface{}{"name": "nginx"},
+				"nginx/root.squashfs":     "a3",
+				"awconnect/root.squashfs": "a4",
+			},
+		},
+		{
+			name:  "add new app",
+			frags: "pvr-sdk",
+			fragment: map[string]interface{}{
+				"pvr-sdk/root.squashfs": "c1",
+			},
+			want: map[string]interface{}{
+				"#spec":                   "pantavisor-multi-platform@1",
+				"bsp/kernel.img":          "a1",
+				"bsp/modules.squashfs":    "a2",
+				"nginx/run.json":          map[string]interface{}{"name": "nginx"},
+				"nginx/root.squashfs":     "a3",
+				"awconnect/root.squashfs": "a4",
+				"pvr-sdk/root.squashfs":   "c1",
+			},
+		},
+		{
+			name:    "empty frags",
+			frags:   "",
+			wantErr: true,
+		},
+		{
+			name:    "negative frags",
+			frags:   "bsp,-nginx",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := composeState(base, tt.frags, tt.fragment)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("composeState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("composeState() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
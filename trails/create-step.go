@@ -0,0 +1,456 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/statevalidator"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// FindTrail find a non garbage trail by its ID
+func (a *App) FindTrail(pctx context.Context, trailID primitive.ObjectID) (*trailmodels.Trail, error) {
+	collTrails := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+
+	trail := &trailmodels.Trail{}
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	err := collTrails.FindOne(ctx, bson.M{
+		"_id":     trailID,
+		"garbage": bson.M{"$ne": true},
+	}).Decode(trail)
+	if err != nil {
+		return nil, err
+	}
+
+	return trail, nil
+}
+
+// StepChecks selects the checks a new step has to pass before it gets stored
+// and receives what they found. The zero value runs all checks.
+type StepChecks struct {
+	// SkipValidation skips the validation of the state for its #spec
+	SkipValidation bool
+
+	// SkipCompatibility skips checking the state against the device-meta of
+	// the device
+	SkipCompatibility bool
+
+	// Violations of the state found by the validation
+	Violations []statevalidator.Violation
+
+	// Incompatibilities of the state with the device
+	Incompatibilities []Incompatibility
+
+	// device checked for compatibility
+	device string
+}
+
+// commitEffects collects the effects of steps created within a transaction
+// that are not part of it (history entries, watch notifications); they only
+// run once the transaction got committed
+type commitEffects struct {
+	effects []func(context.Context)
+}
+
+type commitEffectsKey struct{}
+
+// withCommitEffects returns a context that makes afterCommit collect the
+// effects in the returned commitEffects instead of running them
+func withCommitEffects(pctx context.Context) (context.Context, *commitEffects) {
+	effects := &commitEffects{}
+	return context.WithValue(pctx, commitEffectsKey{}, effects), effects
+}
+
+// afterCommit runs effect right away or, if pctx got made by
+// withCommitEffects, once the transaction of pctx got committed
+func afterCommit(pctx context.Context, effect func(context.Context)) {
+	if effects, ok := pctx.Value(commitEffectsKey{}).(*commitEffects); ok {
+		effects.effects = append(effects.effects, effect)
+		return
+	}
+	effect(pctx)
+}
+
+// run runs the collected effects with pctx
+func (c *commitEffects) run(pctx context.Context) {
+	for _, effect := range c.effects {
+		effect(pctx)
+	}
+}
+
+// checkStep runs checks on the state of step. Steps referencing a release
+// must have the state of the release loaded; it gets checked like any other
+// state as the release may have been created with validate=no and was never
+// checked against the device.
+func (a *App) checkStep(
+	pctx context.Context,
+	trail *trailmodels.Trail,
+	step *trailmodels.Step,
+	checks *StepChecks,
+) *utils.RError {
+	if !checks.SkipValidation {
+		checks.Violations = statevalidator.Validate(step.State)
+		if len(checks.Violations) > 0 {
+			return &utils.RError{
+				Error: "State validation failed: " + checks.Violations[0].Key + ": " + checks.Violations[0].Msg,
+				Code:  http.StatusBadRequest,
+			}
+		}
+	}
+
+	if !checks.SkipCompatibility {
+		incompatibilities, rerr := a.CheckStepCompatibility(pctx, trail, step)
+		if rerr != nil {
+			return rerr
+		}
+		checks.Incompatibilities = incompatibilities
+		checks.device = trail.Device
+		if len(incompatibilities) > 0 {
+			return &utils.RError{
+				Error: "Device not compatible with state: " + incompatibilities[0].Msg,
+				Code:  http.StatusConflict,
+			}
+		}
+	}
+
+	return nil
+}
+
+// CreateStep appends newStep to the head of trail.
+//
+// If newStep.Rev is -1 the next free rev gets assigned; otherwise the rev
+// must be exactly one higher than an existing step of the trail. Ownership
+// of the trail must have been checked by the caller. The state has to pass
+// checks; nil runs all of them. On success newStep is updated to what got
+// stored, with state and meta unquoted.
+func (a *App) CreateStep(
+	pctx context.Context,
+	trail *trailmodels.Trail,
+	newStep *trailmodels.Step,
+	autoLink bool,
+	checks *StepChecks,
+) *utils.RError {
+	return a.createStep(pctx, trail, newStep, autoLink, checks, nil, false)
+}
+
+// createStep is CreateStep resolving objects through cache, if not nil. With
+// keepProgress the step gets stored with the progress and progress-time set
+// by the caller instead of as a NEW step.
+func (a *App) createStep(
+	pctx context.Context,
+	trail *trailmodels.Trail,
+	newStep *trailmodels.Step,
+	autoLink bool,
+	checks *StepChecks,
+	cache *stateObjects,
+	keepProgress bool,
+) *utils.RError {
+	var err error
+
+	collSteps := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	if collSteps == nil {
+		return &utils.RError{Error: "Error with Database connectivity", Code: http.StatusInternalServerError}
+	}
+
+	collTrails := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+	if collTrails == nil {
+		return &utils.RError{Error: "Error with Database connectivity", Code: http.StatusInternalServerError}
+	}
+
+	previousStep := trailmodels.Step{}
+
+	if newStep.Rev == -1 {
+		ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+		defer cancel()
+		newStep.Rev, err = a.getLatestStepRev(ctx, trail.ID)
+		if err != nil {
+			return &utils.RError{Error: "Error with getLatestStepRev: " + err.Error(), Code: http.StatusInternalServerError}
+		}
+		newStep.Rev++
+	}
+
+	stepID := trail.ID.Hex() + "-" + strconv.Itoa(newStep.Rev-1)
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	err = collSteps.FindOne(ctx, bson.M{
+		"_id":     stepID,
+		"garbage": bson.M{"$ne": true},
+	}).Decode(&previousStep)
+
+	if err != nil {
+		// XXX: figure how to be better on error cases here...
+		return &utils.RError{Error: "No access to resource or bad step " + stepID, Code: http.StatusInternalServerError}
+	}
+
+	// XXX: introduce step diffs here and store them precalced
+
+	var rerr *utils.RError
+	var release *trailmodels.Release
+	if newStep.Release != "" {
+		if len(newStep.State) > 0 {
+			return &utils.RError{Error: "A step can either have a state or a release", Code: http.StatusBadRequest}
+		}
+		release, rerr = a.findRelease(pctx, trail.Owner, newStep.Release)
+		if rerr != nil {
+			return rerr
+		}
+		newStep.State = utils.BsonUnquoteMap(&release.State)
+	}
+
+	if checks == nil {
+		checks = &StepChecks{}
+	}
+	rerr = a.checkStep(pctx, trail, newStep, checks)
+	if rerr != nil {
+		return rerr
+	}
+
+	newStep.ID = trail.ID.Hex() + "-" + strconv.Itoa(newStep.Rev)
+	newStep.Owner = trail.Owner
+	newStep.Device = trail.Device
+	if !keepProgress {
+		newStep.StepProgress = trailmodels.StepProgress{
+			Status: "NEW",
+		}
+		newStep.ProgressTime = time.Unix(0, 0)
+	}
+	if trail.Policy.Sequential && !keepProgress {
+		// the step queue releases the step once it got stored
+		newStep.StepProgress = trailmodels.StepProgress{
+			Status:    StepStatusWaiting,
+			StatusMsg: "Waiting for the step queue",
+		}
+		blocking, err := a.blockingStep(pctx, trail.ID, trail.QueueRev, newStep.Rev)
+		if err != nil {
+			return &utils.RError{Error: "Error finding unfinished steps: " + err.Error(), Code: http.StatusInternalServerError}
+		}
+		if blocking != nil {
+			newStep.StepProgress = waitingProgress(blocking.Rev)
+		}
+	}
+	newStep.TrailID = trail.ID
+	now := time.Now()
+	newStep.StepTime = now
+	newStep.TimeCreated = now
+	newStep.TimeModified = now
+	newStep.IsPublic = previousStep.IsPublic
+
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	isDevicePublic, err := a.IsDevicePublic(ctx, newStep.TrailID)
+	if err != nil {
+		return &utils.RError{Error: "Error checking device is public or not: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+	newStep.IsPublic = isDevicePublic
+
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	newStep.Signatures, rerr = a.checkStateSignatures(ctx, trail, newStep.State)
+	if rerr != nil {
+		return rerr
+	}
+
+	// IMPORTANT: statesha has to be before state as that will be escaped
+	newStep.StateSha, err = utils.StateSha(&newStep.State)
+	if err != nil {
+		return &utils.RError{Error: "Error calculating Sha " + err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	stepState := newStep.State
+	newStep.StateKeys = stateKeys(stepState)
+	if release != nil {
+		// objects got processed when the release was created; the state
+		// is not stored with the step but resolved from the release on read
+		newStep.UsedObjects = release.UsedObjects
+		newStep.State = map[string]interface{}{}
+	} else {
+		ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+		defer cancel()
+
+		objectList, err := processObjectsInState(ctx, newStep.Owner, newStep.State, autoLink, a, cache)
+		if err != nil {
+			return &utils.RError{Error: "Error processing step objects in state: " + err.Error(), Code: http.StatusInternalServerError}
+		}
+
+		newStep.UsedObjects = objectList
+		newStep.State = utils.BsonQuoteMap(&newStep.State)
+	}
+	if newStep.Meta == nil {
+		newStep.Meta = map[string]interface{}{}
+	}
+	newStep.Meta = utils.BsonQuoteMap(&newStep.Meta)
+	newStep.TimeModified = time.Now()
+	newStep.TimeCreated = time.Now()
+
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	_, err = collSteps.InsertOne(
+		ctx,
+		newStep,
+	)
+
+	if mongo.IsDuplicateKeyError(err) {
+		return &utils.RError{Error: "Step rev " + strconv.Itoa(newStep.Rev) + " already exists", Code: http.StatusConflict}
+	}
+	if err != nil {
+		// XXX: figure how to be better on error cases here...
+		return &utils.RError{Error: "No access to resource or bad step rev1 " + err.Error(), Code: http.StatusInternalServerError}
+	}
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	updateResult, err := collTrails.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":     trail.ID,
+			"garbage": bson.M{"$ne": true},
+		},
+		bson.M{"$set": bson.M{
+			"last-touched": newStep.StepTime,
+		}},
+	)
+	if err != nil {
+		// XXX: figure how to be better on error cases here...
+		log.Printf("Error updating last-touched for trail in poststep; not failing because step was written: %s\n  => ERROR: %s\n ", trail.ID.Hex(), err.Error())
+	} else if updateResult.MatchedCount == 0 {
+		return &utils.RError{Error: "Trail not found", Code: http.StatusBadRequest}
+	}
+
+	trailID, queued := trail.ID, trail.Policy.Sequential && !keepProgress
+	newStepID, newStepRev := newStep.ID, newStep.Rev
+	afterCommit(pctx, func(ctx context.Context) {
+		if queued {
+			err := a.cancelSupersededSteps(ctx, trailID, newStepRev)
+			if err != nil {
+				log.Printf("Error cancelling steps superseded by %s; not failing because step was written: %s\n", newStepID, err.Error())
+			}
+			// a step before it may have become final since it got checked
+			released, err := a.advanceStepQueue(ctx, trailID, false)
+			if err != nil {
+				log.Printf("Error advancing step queue of trail %s; not failing because step was written: %s\n", trailID.Hex(), err.Error())
+			} else if released != nil && released.ID == newStepID {
+				newStep.StepProgress = released.StepProgress
+				newStep.ProgressTime = released.ProgressTime
+			}
+		}
+		stepWatch.notify(trailID.Hex())
+	})
+
+	newStep.State = stepState
+	newStep.Meta = utils.BsonUnquoteMap(&newStep.Meta)
+
+	return nil
+}
+
+// CancelNewSteps cancels the steps of owner matching filter that devices did
+// not pick up yet (NEW or WAITING). It returns the number of steps that got
+// cancelled.
+func (a *App) CancelNewSteps(pctx context.Context, owner string, filter map[string]interface{}, statusMsg string) (int64, error) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+
+	query := bson.M{}
+	for k, v := range filter {
+		query[k] = v
+	}
+	query["owner"] = owner
+	query["progress.status"] = bson.M{"$in": []string{"NEW", StepStatusWaiting}}
+	query["garbage"] = bson.M{"$ne": true}
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	ids, err := coll.Distinct(ctx, "_id", query)
+	if err != nil {
+		return 0, err
+	}
+	stepIDs := []string{}
+	for _, id := range ids {
+		if stepID, ok := id.(string); ok {
+			stepIDs = append(stepIDs, stepID)
+		}
+	}
+	if len(stepIDs) == 0 {
+		return 0, nil
+	}
+
+	stepProgress := trailmodels.StepProgress{
+		Status:    "CANCEL",
+		Progress:  100,
+		StatusMsg: statusMsg,
+	}
+
+	now := time.Now()
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	updateResult, err := coll.UpdateMany(
+		ctx,
+		bson.M{
+			"_id":             bson.M{"$in": stepIDs},
+			"owner":           owner,
+			"progress.status": query["progress.status"],
+			"garbage":         bson.M{"$ne": true},
+		},
+		bson.M{"$set": bson.M{
+			"progress":      stepProgress,
+			"progress-time": now,
+			"timemodified":  now,
+		}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, stepID := range stepIDs {
+		stepWatch.notify(trailIDFromStepID(stepID))
+	}
+
+	// record the cancel in the progress history of the steps that got cancelled
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	cancelled, err := coll.Distinct(ctx, "_id", bson.M{
+		"_id":             bson.M{"$in": stepIDs},
+		"progress.status": "CANCEL",
+		"progress-time":   now,
+	})
+	if err != nil {
+		log.Printf("Error finding cancelled steps for progress history: %s\n", err.Error())
+	}
+	advanced := map[string]bool{}
+	for _, stepID := range cancelled {
+		if id, ok := stepID.(string); ok {
+			a.recordStepProgress(pctx, id, stepProgress, now, ProgressSourceSystem)
+
+			trailID := trailIDFromStepID(id)
+			if trailObjectID, err := primitive.ObjectIDFromHex(trailID); err == nil && !advanced[trailID] {
+				advanced[trailID] = true
+				a.advanceStepQueueOnStatus(pctx, trailObjectID, stepProgress.Status)
+			}
+		}
+	}
+
+	return updateResult.ModifiedCount, nil
+}
@@ -0,0 +1,177 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/devices"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const stepsProgressDefaultSlowest = 10
+
+// handleGetStepsProgress Get the aggregated update progress of a set of steps
+// @Summary Get the aggregated update progress of a set of steps
+// @Description Aggregates the progress of the latest matching step of every trail of the
+// @Description owner: step counts per status, total, downloaded and remaining bytes, the
+// @Description summed download throughput (bytes/s), an ETA in seconds (-1 if unknown)
+// @Description and the slowest active downloads.
+// @Description Steps get selected by rollout, tag, release, commit-msg and/or a device
+// @Description group (devices=<id|nick>,... user-meta.<key>=<value> device-meta.<key>=<value>).
+// @Description At least one selector is required; all given selectors must match.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param rollout query string false "Rollout ID"
+// @Param tag query string false "Trail tag"
+// @Param release query string false "Release sha"
+// @Param commit-msg query string false "Commit message"
+// @Param devices query string false "Comma separated device IDs or nicks"
+// @Param slowest query int false "Number of slowest devices to list (default 10)"
+// @Success 200 {object} trailmodels.StepsProgress
+// @Failure 400 {object} utils.RError
+// @Failure 403 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/steps/progress [get]
+func (a *App) handleGetStepsProgress(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	authType := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+	if authType != "USER" && authType != "SESSION" {
+		utils.RestErrorWrapper(w, "Need to be logged in as USER/SESSION to get steps progress", http.StatusForbidden)
+		return
+	}
+	ownerStr, _ := owner.(string)
+
+	query := r.URL.Query()
+	match := bson.M{
+		"owner":   ownerStr,
+		"garbage": bson.M{"$ne": true},
+	}
+	selected := false
+
+	if v := query.Get("rollout"); v != "" {
+		match["meta.rollout"] = v
+		selected = true
+	}
+	if v := query.Get("release"); v != "" {
+		match["release"] = v
+		selected = true
+	}
+	if v := query.Get("commit-msg"); v != "" {
+		match["commit-msg"] = v
+		selected = true
+	}
+
+	if tag := query.Get("tag"); tag != "" {
+		if !ValidTagName(tag) {
+			utils.RestErrorWrapper(w, "Invalid tag name "+tag, http.StatusBadRequest)
+			return
+		}
+		stepIDs, err := a.findTaggedStepIDs(r.Context(), ownerStr, tag)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Error finding tagged trails: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		match["_id"] = bson.M{"$in": stepIDs}
+		selected = true
+	}
+
+	selector := devices.SelectorFromQuery(query)
+	if !selector.IsEmpty() {
+		devs, err := devices.Build(a.mongoClient).FindDevicesBySelector(r.Context(), ownerStr, selector)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Error finding devices: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		prns := []string{}
+		for _, d := range devs {
+			prns = append(prns, d.Prn)
+		}
+		match["device"] = bson.M{"$in": prns}
+		selected = true
+	}
+
+	if !selected {
+		utils.RestErrorWrapper(w, "Need a rollout, tag, release, commit-msg or device selector", http.StatusBadRequest)
+		return
+	}
+
+	slowest := stepsProgressDefaultSlowest
+	if v := query.Get("slowest"); v != "" {
+		s, err := strconv.Atoi(v)
+		if err != nil || s <= 0 {
+			utils.RestErrorWrapper(w, "Invalid slowest "+v, http.StatusBadRequest)
+			return
+		}
+		slowest = s
+	}
+
+	progress, err := a.AggregateStepsProgress(r.Context(), match, slowest)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error aggregating steps progress: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(progress)
+}
+
+// findTaggedStepIDs returns the IDs of the steps tag points to in the trails of owner
+func (a *App) findTaggedStepIDs(pctx context.Context, owner, tag string) ([]string, error) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+
+	findOptions := options.Find()
+	findOptions.SetProjection(bson.M{"tags": 1})
+
+	ctx, cancel := context.WithTimeout(pctx, 30*time.Second)
+	defer cancel()
+	cur, err := coll.Find(ctx, bson.M{
+		"owner":       owner,
+		"tags." + tag: bson.M{"$exists": true},
+		"garbage":     bson.M{"$ne": true},
+	}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	stepIDs := []string{}
+	for cur.Next(ctx) {
+		trail := trailmodels.Trail{}
+		err := cur.Decode(&trail)
+		if err != nil {
+			return nil, err
+		}
+		stepIDs = append(stepIDs, trail.ID.Hex()+"-"+strconv.Itoa(trail.Tags[tag]))
+	}
+
+	return stepIDs, nil
+}
@@ -0,0 +1,223 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/objects"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"gitlab.com/pantacor/pantahub-base/utils/querymongo"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StepSearchResult a page of steps found by a step search
+type StepSearchResult struct {
+	querymongo.Pagination `json:",inline"`
+	Items                 []trailmodels.Step `json:"items"`
+}
+
+// stepSearchFilterKeys step fields that can be filtered on in a step search
+var stepSearchFilterKeys = map[string]bool{
+	"device":          true,
+	"rev":             true,
+	"progress.status": true,
+}
+
+// stateKeys returns the sorted keys of state
+func stateKeys(state map[string]interface{}) []string {
+	keys := make([]string, 0, len(state))
+	for k := range state {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stepSearchQuery builds the query of a step search of owner: q is a text
+// search on commit-msg, meta.<key>=<value> matches meta values, object=<sha>
+// steps using the object and key=<state key> steps with the key in their state
+func stepSearchQuery(owner string, values url.Values) (bson.M, error) {
+	query := bson.M{
+		"owner":   owner,
+		"garbage": bson.M{"$ne": true},
+	}
+
+	if q := values.Get("q"); q != "" {
+		query["$text"] = bson.M{"$search": q}
+	}
+
+	for key, v := range values {
+		if !strings.HasPrefix(key, "meta.") || len(v) == 0 {
+			continue
+		}
+		metaKey := strings.Replace(strings.TrimPrefix(key, "meta."), ".", "\uFF2E", -1)
+		if f, err := strconv.ParseFloat(v[0], 64); err == nil {
+			query["meta."+metaKey] = bson.M{"$in": []interface{}{v[0], f}}
+		} else {
+			query["meta."+metaKey] = v[0]
+		}
+	}
+
+	if sha := values.Get("object"); sha != "" {
+		shaBytes, err := hex.DecodeString(sha)
+		if err != nil {
+			return nil, errors.New("Invalid object sha " + sha)
+		}
+		query["used_objects"] = objects.MakeStorageID(owner, shaBytes)
+	}
+
+	if key := values.Get("key"); key != "" {
+		if _, ok := query["$text"]; ok {
+			// text search only allows indexed $or clauses
+			query["state-keys"] = key
+		} else {
+			// steps posted before state keys got recorded only have their state
+			query["$or"] = []bson.M{
+				{"state-keys": key},
+				{"state." + strings.Replace(key, ".", "\uFF2E", -1): bson.M{"$exists": true}},
+			}
+		}
+	}
+
+	return query, nil
+}
+
+// handleGetStepsSearch Search steps of the owner
+// @Summary Search steps of the owner
+// @Description Search the steps of all trails of the owner. q is a text search on the
+// @Description commit-msg, meta.<key>=<value> matches meta values, object=<sha> finds the
+// @Description steps using an object (e.g. which devices have a rev containing it) and
+// @Description key=<state key> the steps with that key in their state. device, rev and
+// @Description progress.status can be used as filters too, as well as a filter expression
+// @Description (e.g. filter=step-time >= now-7d). Steps are returned without
+// @Description state unless requested with fields, newest first, paginated with
+// @Description page[size] and page[offset].
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param q query string false "Text to search in commit-msg"
+// @Param object query string false "Object sha"
+// @Param key query string false "State key"
+// @Param filter query string false "Filter expression"
+// @Param page[size] query int false "Page size (default 100)"
+// @Param page[offset] query int false "Page offset"
+// @Success 200 {object} StepSearchResult
+// @Failure 400 {object} utils.RError
+// @Failure 403 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/steps/search [get]
+func (a *App) handleGetStepsSearch(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	authType := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+	if authType != "USER" && authType != "SESSION" {
+		utils.RestErrorWrapper(w, "Need to be logged in as USER/SESSION to search steps", http.StatusForbidden)
+		return
+	}
+	ownerStr, _ := owner.(string)
+
+	pageURL := *r.URL
+	if u, err := url.Parse(r.RequestURI); err == nil {
+		pageURL = *u
+	}
+
+	query, err := stepSearchQuery(ownerStr, r.URL.Query())
+	if err != nil {
+		utils.RestErrorWrapper(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = stepFilter(r, query)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Illegal Filter "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	asp := querymongo.GetAllQueryPagination(r.URL, stepSearchFilterKeys)
+	for key, value := range asp.Filters {
+		query[key] = value
+	}
+	if _, ok := asp.Pagination["limit"]; !ok {
+		asp.Pagination["limit"] = querymongo.DefaultPageSize
+	}
+
+	findOptions := options.Find()
+	if len(asp.Fields) > 0 {
+		findOptions.Projection = querymongo.MergeDefaultProjection(asp.Fields)
+	} else {
+		findOptions.SetProjection(bson.M{"state": 0, "used_objects": 0, "state-keys": 0})
+	}
+
+	sortBy := asp.Sort
+	if len(sortBy) == 0 {
+		sortBy = bson.M{"step-time": -1}
+	}
+	querymongo.SetMongoPagination(query, sortBy, asp.Pagination, findOptions)
+
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	total, err := coll.CountDocuments(ctx, query)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error searching steps: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cur, err := coll.Find(ctx, query, findOptions)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error searching steps: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cur.Close(ctx)
+
+	result := StepSearchResult{
+		Pagination: querymongo.GetOffsetPagination(pageURL, total),
+		Items:      []trailmodels.Step{},
+	}
+	for cur.Next(ctx) {
+		step := trailmodels.Step{}
+		err := cur.Decode(&step)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Cursor Decode Error:"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		step.Meta = utils.BsonUnquoteMap(&step.Meta)
+		step.State = utils.BsonUnquoteMap(&step.State)
+		result.Items = append(result.Items, step)
+	}
+
+	w.WriteJson(result)
+}
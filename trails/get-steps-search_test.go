@@ -0,0 +1,58 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestStepSearchQuery(t *testing.T) {
+	owner := "prn:pantahub.com:auth:/user1"
+
+	values, _ := url.ParseQuery("q=CVE-2026-1234&meta.release=4.2&meta.app.name=nginx&key=bsp/kernel.img")
+	query, err := stepSearchQuery(owner, values)
+	if err != nil {
+		t.Fatalf("stepSearchQuery() error = %v", err)
+	}
+
+	want := bson.M{
+		"owner":              owner,
+		"garbage":            bson.M{"$ne": true},
+		"$text":              bson.M{"$search": "CVE-2026-1234"},
+		"meta.release":       bson.M{"$in": []interface{}{"4.2", 4.2}},
+		"meta.app\uFF2Ename": "nginx",
+		"state-keys":         "bsp/kernel.img",
+	}
+	if !reflect.DeepEqual(query, want) {
+		t.Errorf("stepSearchQuery() = %v, want %v", query, want)
+	}
+
+	values, _ = url.ParseQuery("key=bsp/kernel.img")
+	query, _ = stepSearchQuery(owner, values)
+	if _, ok := query["$or"]; !ok {
+		t.Errorf("stepSearchQuery() without q does not match state keys of older steps: %v", query)
+	}
+
+	values, _ = url.ParseQuery("object=nosha")
+	if _, err := stepSearchQuery(owner, values); err == nil {
+		t.Error("stepSearchQuery() accepted invalid object sha")
+	}
+}
@@ -0,0 +1,203 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	watchDefaultTimeout    = 30 * time.Second
+	watchMaxTimeout        = 300 * time.Second
+	watchSSEDefaultTimeout = 300 * time.Second
+	watchSSEMaxTimeout     = 3600 * time.Second
+	watchSSEHeartbeat      = 25 * time.Second
+)
+
+// watchTimeout parses the timeout query parameter (seconds)
+func watchTimeout(r *rest.Request, def, max time.Duration) time.Duration {
+	timeout := def
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err == nil && secs > 0 {
+			timeout = time.Duration(secs) * time.Second
+		}
+	}
+	if timeout > max {
+		timeout = max
+	}
+	return timeout
+}
+
+// handleGetStepsWatch Wait for new or cancelled steps of the given trail.
+// @Summary Wait for new or cancelled steps of the given trail.
+// @Description Long-poll variant of GET /trails/{id}/steps returning the steps in NEW state
+// @Description (for devices only the first one, like the regular poll). The response has an
+// @Description ETag; passing it back with If-None-Match makes the request wait until a step
+// @Description gets added or cancelled or the timeout (seconds, default 30, max 300) passes,
+// @Description in which case 304 gets returned. Without If-None-Match it returns right away,
+// @Description so clients not knowing about watching behave as if they polled.
+// @Description With Accept: text/event-stream the same lists get streamed as "steps" events
+// @Description (default timeout 300, max 3600); Last-Event-ID is honored like If-None-Match.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param id path string true "ID"
+// @Param timeout query int false "Seconds to wait for changes"
+// @Success 200 {array} trailmodels.Step
+// @Success 304
+// @Failure 400 {object} utils.RError
+// @Failure 403 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/{id}/steps/watch [get]
+func (a *App) handleGetStepsWatch(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	authType := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+
+	trailObjectID, err := primitive.ObjectIDFromHex(r.PathParam("id"))
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid Hex:"+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	trail, err := a.FindTrail(r.Context(), trailObjectID)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Trail not found", http.StatusNotFound)
+		return
+	}
+
+	var limit int64
+	switch {
+	case authType == "DEVICE" && trail.Device == owner:
+		limit = 1
+	case (authType == "USER" || authType == "SESSION") && trail.Owner == owner:
+	default:
+		utils.RestErrorWrapper(w, "No access to trail", http.StatusForbidden)
+		return
+	}
+
+	// subscribe before the first lookup so that no change gets missed
+	changed, unsubscribe := stepWatch.subscribe(trail.ID.Hex())
+	defer unsubscribe()
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		if _, ok := w.(http.Flusher); ok {
+			a.streamPendingSteps(w, r, trail, limit, changed)
+			return
+		}
+	}
+
+	etag := r.Header.Get("If-None-Match")
+	deadline := time.NewTimer(watchTimeout(r, watchDefaultTimeout, watchMaxTimeout))
+	defer deadline.Stop()
+
+	for {
+		steps, err := a.findPendingSteps(r.Context(), trail, limit)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Error on fetching steps:"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tag := pendingETag(steps)
+		if tag != etag {
+			w.Header().Set("ETag", tag)
+			w.WriteJson(steps)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(stepWatch.pollInterval()):
+		case <-deadline.C:
+			w.Header().Set("ETag", tag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// streamPendingSteps streams the pending steps of trail as server sent events
+// every time they change
+func (a *App) streamPendingSteps(w rest.ResponseWriter, r *rest.Request, trail *trailmodels.Trail, limit int64, changed <-chan struct{}) {
+	flusher := w.(http.Flusher)
+	out := w.(http.ResponseWriter)
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.Header.Get("If-None-Match")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	deadline := time.NewTimer(watchTimeout(r, watchSSEDefaultTimeout, watchSSEMaxTimeout))
+	defer deadline.Stop()
+	heartbeat := time.NewTicker(watchSSEHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		steps, err := a.findPendingSteps(r.Context(), trail, limit)
+		if err != nil {
+			fmt.Fprintf(out, "event: error\ndata: %q\n\n", err.Error())
+			flusher.Flush()
+			return
+		}
+		tag := pendingETag(steps)
+		if tag != lastID {
+			data, err := w.EncodeJson(steps)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(out, "id: %s\nevent: steps\ndata: %s\n\n", tag, data)
+			flusher.Flush()
+			lastID = tag
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(stepWatch.pollInterval()):
+		case <-heartbeat.C:
+			fmt.Fprint(out, ": ping\n\n")
+			flusher.Flush()
+		case <-deadline.C:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -38,6 +38,39 @@ import (
 
 var filterByKeys = map[string]bool{}
 
+// stepFilterSchema fields of Step that filters can use
+var stepFilterSchema = &querymongo.FilterSchema{
+	Fields: map[string]querymongo.FilterField{
+		"rev":               {Key: "rev", Type: querymongo.FilterInt},
+		"device":            {Key: "device", Type: querymongo.FilterString},
+		"commit-msg":        {Key: "commit-msg", Type: querymongo.FilterString},
+		"state-sha":         {Key: "statesha", Type: querymongo.FilterString},
+		"release":           {Key: "release", Type: querymongo.FilterString},
+		"progress.status":   {Key: "progress.status", Type: querymongo.FilterString},
+		"progress.progress": {Key: "progress.progress", Type: querymongo.FilterInt},
+		"step-time":         {Key: "step-time", Type: querymongo.FilterTime},
+		"progress-time":     {Key: "progress-time", Type: querymongo.FilterTime},
+	},
+	Maps: map[string]string{
+		"meta.": "meta.",
+	},
+}
+
+// stepFilter adds the filter expression of the filter query parameter, if
+// any, to query
+func stepFilter(r *rest.Request, query bson.M) error {
+	filterParam := r.URL.Query().Get("filter")
+	if filterParam == "" {
+		return nil
+	}
+	filter, err := querymongo.ParseFilter(filterParam, stepFilterSchema)
+	if err != nil {
+		return err
+	}
+	query["$and"] = []interface{}{filter}
+	return nil
+}
+
 // handleGetSteps Get steps of the the given trail.
 // @Summary Get steps of the the given trail.
 // @Description Get steps of the the given trail.
@@ -52,6 +85,7 @@ var filterByKeys = map[string]bool{}
 // @Tags trails
 // @Security ApiKeyAuth
 // @Param id path string true "ID|NICK|PRN"
+// @Param filter query string false "Filter expression, e.g. rev >= 10 and meta.canary = true"
 // @Success 200 {array} trailmodels.Step
 // @Failure 400 {object} utils.RError
 // @Failure 404 {object} utils.RError
@@ -129,6 +163,12 @@ func (a *App) handleGetSteps(w rest.ResponseWriter, r *rest.Request) {
 		}
 	}
 
+	err = stepFilter(r, query)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Illegal Filter "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	findOptions := options.Find()
 	findOptions.SetNoCursorTimeout(true)
 	if authType == "DEVICE" && progressStatus == "" {
@@ -162,6 +202,11 @@ func (a *App) handleGetSteps(w rest.ResponseWriter, r *rest.Request) {
 			utils.RestErrorWrapper(w, "Cursor Decode Error:"+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		rerr := a.resolveRelease(ctx, &result)
+		if rerr != nil {
+			utils.RestErrorWrite(w, rerr)
+			return
+		}
 		result.Meta = utils.BsonUnquoteMap(&result.Meta)
 		result.State = utils.BsonUnquoteMap(&result.State)
 		steps = append(steps, result)
@@ -29,6 +29,7 @@ import (
 	jwtgo "github.com/dgrijalva/jwt-go"
 	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
 	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -41,6 +42,7 @@ import (
 // @Description Devices confirm a step by posting a walk element matching the rev.
 // @Description This conveyes that the devices knows about the step to go and will keep the
 // @Description post updates to the walk elements as they go.
+// @Description For the owner the summary includes the step queue of sequential trails.
 // @Accept  json
 // @Produce  json
 // @Tags trails
@@ -60,7 +62,7 @@ func (a *App) handleGetTrailStepSummary(w rest.ResponseWriter, r *rest.Request)
 		return
 	}
 
-	summaryCol := a.mongoClient.Database("pantabase_devicesummary").Collection("device_summary_short_new_v2")
+	summaryCol := a.mongoClient.Database(trailmodels.SummaryDatabase).Collection(trailmodels.SummaryCollection)
 
 	if summaryCol == nil {
 		utils.RestErrorWrapper(w, "Error with Database connectivity", http.StatusInternalServerError)
@@ -106,6 +108,16 @@ func (a *App) handleGetTrailStepSummary(w rest.ResponseWriter, r *rest.Request)
 		summary.FleetModel = ""
 		summary.FleetRev = ""
 		summary.RealIP = ""
+	} else if trailObjectID, err := primitive.ObjectIDFromHex(trailID); err == nil {
+		trail, err := a.FindTrail(r.Context(), trailObjectID)
+		if err == nil {
+			summary.LastRollback = trail.LastRollback
+			summary.Queue, err = a.StepQueue(r.Context(), trail)
+			if err != nil {
+				utils.RestErrorWrapper(w, "Error getting step queue: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 	}
 	w.WriteJson(summary)
 }
@@ -0,0 +1,73 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// handleGetTrailTags Get the tags of a trail
+// @Summary Get the tags of a trail
+// @Description Get the named tags of a trail and the revs they point to.
+// @Description The trail owner and the device can read the tags.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param id path string true "ID|NICK|PRN"
+// @Success 200 {object} map[string]int
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/{id}/tags [get]
+func (a *App) handleGetTrailTags(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		// XXX: find right error
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	trailID, err := primitive.ObjectIDFromHex(r.PathParam("id"))
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid trail ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	trail, err := a.FindTrail(r.Context(), trailID)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Trail not found", http.StatusNotFound)
+		return
+	}
+
+	if trail.Owner != owner && trail.Device != owner {
+		utils.RestErrorWrapper(w, "No access to trail", http.StatusNotFound)
+		return
+	}
+
+	tags := trail.Tags
+	if tags == nil {
+		tags = map[string]int{}
+	}
+
+	w.WriteJson(tags)
+}
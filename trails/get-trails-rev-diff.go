@@ -0,0 +1,241 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/objects"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"gitlab.com/pantacor/pantahub-base/utils/jsondiff"
+)
+
+// StepDiff difference between the states of two steps of a trail
+type StepDiff struct {
+	Rev     int                  `json:"rev"`
+	Against int                  `json:"against"`
+	Patch   []jsondiff.Operation `json:"patch"`
+	Objects ObjectsDiff          `json:"objects"`
+	// DownloadSize bytes of objects a device on rev Against has to fetch to get to Rev
+	DownloadSize int64 `json:"download-size"`
+}
+
+// ObjectsDiff objects added, removed and changed between two states
+type ObjectsDiff struct {
+	Added   []ObjectChange `json:"added"`
+	Removed []ObjectChange `json:"removed"`
+	Changed []ObjectChange `json:"changed"`
+}
+
+// ObjectChange one object entry of a state that differs between two states
+type ObjectChange struct {
+	Name    string `json:"name"`
+	Sha     string `json:"sha256sum,omitempty"`
+	Size    int64  `json:"size"`
+	OldSha  string `json:"old-sha256sum,omitempty"`
+	OldSize int64  `json:"old-size,omitempty"`
+}
+
+// stateObjectShas returns the object entries of a state by name
+func stateObjectShas(state map[string]interface{}) map[string]string {
+	shas := map[string]string{}
+	for key, v := range state {
+		if strings.HasSuffix(key, ".json") || key == "#spec" {
+			continue
+		}
+		if sha, ok := v.(string); ok {
+			shas[key] = sha
+		}
+	}
+	return shas
+}
+
+// diffStateObjects compares the object entries of two states; sizes are left
+// for the caller to fill in
+func diffStateObjects(from, to map[string]interface{}) ObjectsDiff {
+	fromShas := stateObjectShas(from)
+	toShas := stateObjectShas(to)
+
+	result := ObjectsDiff{
+		Added:   []ObjectChange{},
+		Removed: []ObjectChange{},
+		Changed: []ObjectChange{},
+	}
+
+	for name, sha := range toShas {
+		oldSha, ok := fromShas[name]
+		if !ok {
+			result.Added = append(result.Added, ObjectChange{Name: name, Sha: sha})
+		} else if oldSha != sha {
+			result.Changed = append(result.Changed, ObjectChange{Name: name, Sha: sha, OldSha: oldSha})
+		}
+	}
+	for name, sha := range fromShas {
+		if _, ok := toShas[name]; !ok {
+			result.Removed = append(result.Removed, ObjectChange{Name: name, Sha: sha})
+		}
+	}
+
+	for _, l := range [][]ObjectChange{result.Added, result.Removed, result.Changed} {
+		sort.Slice(l, func(i, j int) bool { return l[i].Name < l[j].Name })
+	}
+
+	return result
+}
+
+// objectSize returns the size of the object sha as stored for owner or 0 if unknown
+func (a *App) objectSize(ctx context.Context, objectsApp *objects.App, owner string, sha string) int64 {
+	shaBytes, err := utils.DecodeSha256HexString(sha)
+	if err != nil {
+		return 0
+	}
+
+	object := objects.Object{}
+	err = objectsApp.FindObjectByStorageID(ctx, objects.MakeStorageID(owner, shaBytes), &object)
+	if err != nil {
+		return 0
+	}
+
+	return object.SizeInt
+}
+
+// handleGetStepDiff Get the difference between the states of two steps
+// @Summary Get the difference between the states of two steps
+// @Description Get a RFC 6902 JSON Patch that turns the state of rev "against" into
+// @Description the state of rev, plus the objects added, removed and changed with their
+// @Description sizes. "against" defaults to the previous rev.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param id path string true "ID|NICK|PRN"
+// @Param rev path string true "REV_ID|TAG"
+// @Param against query string false "REV_ID or tag to compare with"
+// @Success 200 {object} StepDiff
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/{id}/steps/{rev}/diff [get]
+func (a *App) handleGetStepDiff(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		// XXX: find right error
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	authType, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+	if !ok {
+		// XXX: find right error
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	trailID := r.PathParam("id")
+	revParam, rerr := a.resolveRev(r.Context(), trailID, r.PathParam("rev"))
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
+	rev, err := strconv.Atoi(revParam)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid rev: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	against := rev - 1
+	if r.URL.Query().Get("against") != "" {
+		againstParam, rerr := a.resolveRev(r.Context(), trailID, r.URL.Query().Get("against"))
+		if rerr != nil {
+			utils.RestErrorWrite(w, rerr)
+			return
+		}
+		against, err = strconv.Atoi(againstParam)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Invalid against rev: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	step, err := a.findStepForRead(r.Context(), trailID, strconv.Itoa(rev), owner, authType)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Step not found", http.StatusNotFound)
+		return
+	}
+
+	againstStep, err := a.findStepForRead(r.Context(), trailID, strconv.Itoa(against), owner, authType)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Step to compare against not found", http.StatusNotFound)
+		return
+	}
+
+	state := utils.BsonUnquoteMap(&step.State)
+	againstState := utils.BsonUnquoteMap(&againstStep.State)
+
+	from, err := jsondiff.Normalize(againstState)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error reading state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	to, err := jsondiff.Normalize(state)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error reading state: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := StepDiff{
+		Rev:     rev,
+		Against: against,
+		Patch:   jsondiff.Diff(from, to),
+		Objects: diffStateObjects(againstState, state),
+	}
+
+	objectsApp := objects.Build(a.mongoClient)
+	downloads := map[string]bool{}
+	for _, sha := range stateObjectShas(againstState) {
+		downloads[sha] = false
+	}
+	for i := range result.Objects.Added {
+		o := &result.Objects.Added[i]
+		o.Size = a.objectSize(r.Context(), objectsApp, step.Owner, o.Sha)
+		if _, ok := downloads[o.Sha]; !ok {
+			downloads[o.Sha] = true
+			result.DownloadSize += o.Size
+		}
+	}
+	for i := range result.Objects.Changed {
+		o := &result.Objects.Changed[i]
+		o.Size = a.objectSize(r.Context(), objectsApp, step.Owner, o.Sha)
+		o.OldSize = a.objectSize(r.Context(), objectsApp, againstStep.Owner, o.OldSha)
+		if _, ok := downloads[o.Sha]; !ok {
+			downloads[o.Sha] = true
+			result.DownloadSize += o.Size
+		}
+	}
+	for i := range result.Objects.Removed {
+		o := &result.Objects.Removed[i]
+		o.Size = a.objectSize(r.Context(), objectsApp, againstStep.Owner, o.Sha)
+	}
+
+	w.WriteJson(result)
+}
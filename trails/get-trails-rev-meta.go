@@ -42,7 +42,7 @@ type meta map[string]interface{}
 // @Tags trails
 // @Security ApiKeyAuth
 // @Param id path string true "ID|NICK|PRN"
-// @Param rev path string true "REV_ID"
+// @Param rev path string true "REV_ID|TAG"
 // @Success 200 {object} meta
 // @Failure 400 {object} utils.RError
 // @Failure 404 {object} utils.RError
@@ -77,7 +77,11 @@ func (a *App) handleGetStepMeta(w rest.ResponseWriter, r *rest.Request) {
 
 	step := trailmodels.Step{}
 	trailID := r.PathParam("id")
-	rev := r.PathParam("rev")
+	rev, rerr := a.resolveRev(r.Context(), trailID, r.PathParam("rev"))
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
 
 	isPublic, err := a.isTrailPublic(r.Context(), trailID)
 	if err != nil {
@@ -42,7 +42,7 @@ import (
 // @Tags trails
 // @Security ApiKeyAuth
 // @Param id path string true "ID|NICK|PRN"
-// @Param rev path string true "REV_ID"
+// @Param rev path string true "REV_ID|TAG"
 // @Success 200 {object} objects.ObjectWithAccess
 // @Failure 400 {object} utils.RError
 // @Failure 404 {object} utils.RError
@@ -69,7 +69,11 @@ func (a *App) handleGetStepsObjects(w rest.ResponseWriter, r *rest.Request) {
 	}
 
 	trailID := r.PathParam("id")
-	rev := r.PathParam("rev")
+	rev, rerr := a.resolveRev(r.Context(), trailID, r.PathParam("rev"))
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
 
 	isPublic, err := a.isTrailPublic(r.Context(), trailID)
 	if err != nil {
@@ -104,7 +108,7 @@ func (a *App) handleGetStepsObjects(w rest.ResponseWriter, r *rest.Request) {
 // @Tags trails
 // @Security ApiKeyAuth
 // @Param id path string true "ID|NICK|PRN"
-// @Param rev path string true "REV_ID"
+// @Param rev path string true "REV_ID|TAG"
 // @Param object_id path string true "OBJECT_ID"
 // @Success 200 {object} objects.ObjectWithAccess
 // @Failure 400 {object} utils.RError
@@ -131,7 +135,11 @@ func (a *App) handleGetStepsObject(w rest.ResponseWriter, r *rest.Request) {
 	step := trailmodels.Step{}
 
 	trailID := r.PathParam("id")
-	rev := r.PathParam("rev")
+	rev, rerr := a.resolveRev(r.Context(), trailID, r.PathParam("rev"))
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
 	objIDParam := r.PathParam("obj")
 
 	isPublic, err := a.isTrailPublic(r.Context(), trailID)
@@ -165,6 +173,11 @@ func (a *App) handleGetStepsObject(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
+	rerr = a.resolveRelease(r.Context(), &step)
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
 	stateU := utils.BsonUnquoteMap(&step.State)
 
 	var objWithAccess *objects.ObjectWithAccess
@@ -260,7 +273,7 @@ func (a *App) handleGetStepsObject(w rest.ResponseWriter, r *rest.Request) {
 // @Tags trails
 // @Security ApiKeyAuth
 // @Param id path string true "ID|NICK|PRN"
-// @Param rev path string true "REV_ID"
+// @Param rev path string true "REV_ID|TAG"
 // @Param object_id path string true "OBJECT_ID"
 // @Header 200 {string} Location "File location URL"
 // @Success 200
@@ -289,7 +302,11 @@ func (a *App) handleGetStepsObjectFile(w rest.ResponseWriter, r *rest.Request) {
 	step := trailmodels.Step{}
 
 	trailID := r.PathParam("id")
-	rev := r.PathParam("rev")
+	rev, rerr := a.resolveRev(r.Context(), trailID, r.PathParam("rev"))
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
 	objIDParam := r.PathParam("obj")
 
 	isPublic, err := a.isTrailPublic(r.Context(), trailID)
@@ -324,6 +341,11 @@ func (a *App) handleGetStepsObjectFile(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
+	rerr = a.resolveRelease(r.Context(), &step)
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
 	stateU := utils.BsonUnquoteMap(&step.State)
 
 	var objWithAccess *objects.ObjectWithAccess
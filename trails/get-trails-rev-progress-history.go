@@ -0,0 +1,133 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	progressHistoryDefaultLimit = 1000
+	progressHistoryMaxLimit     = 10000
+)
+
+// handleGetStepProgressHistory Get the progress history of a step.
+// @Summary Get the progress history of a step.
+// @Description Get every progress update of a step in the order they were made, with
+// @Description status, download stats and who reported them (device, owner or system).
+// @Description Only the owner and the device of the trail can read the history.
+// @Description Use limit to get more than the first 1000 updates (max 10000).
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param id path string true "ID"
+// @Param rev path string true "REV_ID|TAG"
+// @Param limit query int false "Maximum number of entries"
+// @Success 200 {array} trailmodels.StepProgressEntry
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/{id}/steps/{rev}/progress/history [get]
+func (a *App) handleGetStepProgressHistory(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	authType := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+
+	trailID := r.PathParam("id")
+	rev, rerr := a.resolveRev(r.Context(), trailID, r.PathParam("rev"))
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
+
+	query := bson.M{
+		"_id":     trailID + "-" + rev,
+		"garbage": bson.M{"$ne": true},
+	}
+	switch authType {
+	case "DEVICE":
+		query["device"] = owner
+	case "USER", "SESSION":
+		query["owner"] = owner
+	default:
+		utils.RestErrorWrapper(w, "No access to step", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	count, err := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps").CountDocuments(ctx, query)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error finding step: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if count == 0 {
+		utils.RestErrorWrapper(w, "Step not found", http.StatusNotFound)
+		return
+	}
+
+	limit := int64(progressHistoryDefaultLimit)
+	if v := r.URL.Query().Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 {
+			utils.RestErrorWrapper(w, "Invalid limit "+v, http.StatusBadRequest)
+			return
+		}
+		limit = int64(l)
+	}
+	if limit > progressHistoryMaxLimit {
+		limit = progressHistoryMaxLimit
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "progress-time", Value: 1}, {Key: "_id", Value: 1}})
+	findOptions.SetLimit(limit)
+
+	ctx, cancel = context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	cur, err := a.mongoClient.Database(utils.MongoDb).Collection(ProgressHistoryCollection).
+		Find(ctx, bson.M{"step-id": trailID + "-" + rev}, findOptions)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error finding progress history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer cur.Close(ctx)
+
+	history := []trailmodels.StepProgressEntry{}
+	err = cur.All(ctx, &history)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error reading progress history: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(history)
+}
@@ -41,7 +41,7 @@ import (
 // @Tags trails
 // @Security ApiKeyAuth
 // @Param id path string true "ID|NICK|PRN"
-// @Param rev path string true "REV_ID"
+// @Param rev path string true "REV_ID|TAG"
 // @Success 200 {object} trailmodels.PvrRemote
 // @Failure 400 {object} utils.RError
 // @Failure 404 {object} utils.RError
@@ -67,7 +67,11 @@ func (a *App) handleGetStepPvrInfo(w rest.ResponseWriter, r *rest.Request) {
 	}
 
 	getID := r.PathParam("id")
-	revID := r.PathParam("rev")
+	revID, rerr := a.resolveRev(r.Context(), getID, r.PathParam("rev"))
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
 	stepID := getID + "-" + revID
 	step := trailmodels.Step{}
 
@@ -40,7 +40,7 @@ import (
 // @Tags trails
 // @Security ApiKeyAuth
 // @Param id path string true "ID|NICK|PRN"
-// @Param rev path string true "REV_ID"
+// @Param rev path string true "REV_ID|TAG"
 // @Success 200 {object} meta
 // @Failure 400 {object} utils.RError
 // @Failure 404 {object} utils.RError
@@ -74,7 +74,11 @@ func (a *App) handleGetStepState(w rest.ResponseWriter, r *rest.Request) {
 	step := trailmodels.Step{}
 
 	trailID := r.PathParam("id")
-	rev := r.PathParam("rev")
+	rev, rerr := a.resolveRev(r.Context(), trailID, r.PathParam("rev"))
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
 
 	isPublic, err := a.isTrailPublic(r.Context(), trailID)
 
@@ -105,5 +109,10 @@ func (a *App) handleGetStepState(w rest.ResponseWriter, r *rest.Request) {
 		}).Decode(&step)
 	}
 
+	rerr = a.resolveRelease(r.Context(), &step)
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
 	w.WriteJson(utils.BsonUnquoteMap(&step.State))
 }
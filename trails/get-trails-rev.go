@@ -45,7 +45,7 @@ import (
 // @Tags trails
 // @Security ApiKeyAuth
 // @Param id path string true "ID|NICK|PRN"
-// @Param rev path string true "REV_ID"
+// @Param rev path string true "REV_ID|TAG"
 // @Success 200 {object} trailmodels.Step
 // @Failure 400 {object} utils.RError
 // @Failure 404 {object} utils.RError
@@ -81,7 +81,11 @@ func (a *App) handleGetStep(w rest.ResponseWriter, r *rest.Request) {
 
 	asp := querymongo.GetAllQueryPagination(r.URL, filterByKeys)
 	step := trailmodels.Step{}
-	rev := r.PathParam("rev")
+	rev, rerr := a.resolveRev(r.Context(), trailID, r.PathParam("rev"))
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
 	query := bson.M{
 		"_id":     trailID + "-" + rev,
 		"garbage": bson.M{"$ne": true},
@@ -113,6 +117,11 @@ func (a *App) handleGetStep(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
+	rerr = a.resolveRelease(r.Context(), &step)
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
 	step.Meta = utils.BsonUnquoteMap(&step.Meta)
 	step.State = utils.BsonUnquoteMap(&step.State)
 
@@ -20,7 +20,6 @@
 package trails
 
 import (
-	"encoding/json"
 	"net/http"
 	"time"
 
@@ -30,17 +29,63 @@ import (
 	jwtgo "github.com/dgrijalva/jwt-go"
 	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
 	"gitlab.com/pantacor/pantahub-base/utils"
+	"gitlab.com/pantacor/pantahub-base/utils/querymongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"gopkg.in/mgo.v2/bson"
 )
 
+// summaryFilterSchema fields of TrailSummary that filters can use
+var summaryFilterSchema = withStoredKeys(&querymongo.FilterSchema{
+	Fields: map[string]querymongo.FilterField{
+		"deviceid":           {Key: "deviceid", Type: querymongo.FilterString},
+		"device":             {Key: "device", Type: querymongo.FilterString},
+		"device-nick":        {Key: "device_nick", Type: querymongo.FilterString},
+		"revision":           {Key: "revision", Type: querymongo.FilterInt},
+		"progress-revision":  {Key: "progress_revision", Type: querymongo.FilterInt},
+		"progress":           {Key: "progress", Type: querymongo.FilterInt},
+		"public":             {Key: "public", Type: querymongo.FilterBool},
+		"state-sha":          {Key: "state_sha256", Type: querymongo.FilterString},
+		"status-msg":         {Key: "status_msg", Type: querymongo.FilterString},
+		"status":             {Key: "status", Type: querymongo.FilterString},
+		"timestamp":          {Key: "timestamp", Type: querymongo.FilterTime},
+		"step-time":          {Key: "step_time", Type: querymongo.FilterTime},
+		"progress-time":      {Key: "progress_time", Type: querymongo.FilterTime},
+		"trail-touched-time": {Key: "trail_touched_time", Type: querymongo.FilterTime},
+		"real-ip":            {Key: "real_ip", Type: querymongo.FilterString},
+		"fleet-group":        {Key: "fleet_group", Type: querymongo.FilterString},
+		"fleet-model":        {Key: "fleet_model", Type: querymongo.FilterString},
+		"fleet-location":     {Key: "fleet_location", Type: querymongo.FilterString},
+		"fleet-rev":          {Key: "fleet_rev", Type: querymongo.FilterString},
+	},
+})
+
+// withStoredKeys adds the stored keys (e.g. fleet_group) of the fields of
+// schema as field names; summary filters used to be mongo queries on them
+func withStoredKeys(schema *querymongo.FilterSchema) *querymongo.FilterSchema {
+	stored := map[string]querymongo.FilterField{}
+	for name, field := range schema.Fields {
+		if field.Key != name {
+			stored[field.Key] = field
+		}
+	}
+	for key, field := range stored {
+		schema.Fields[key] = field
+	}
+	return schema
+}
+
 // handleGetTrailSummary  get summary of all trails by the calling owner.
 // @Summary  get summary of all trails by the calling owner.
 // @Description  get summary of all trails by the calling owner.
+// @Description  Use filter to only get matching summaries, e.g.
+// @Description  filter=fleet-group = lab and status in (ERROR, WONTGO) and timestamp >= now-24h
+// @Description  Comparisons (= != < <= > >=), prefix (^=) and in can be combined with and,
+// @Description  or, not and parentheses. Times are RFC3339 or now-<duration> (e.g. now-7d).
 // @Accept  json
 // @Produce  json
 // @Tags trails
 // @Security ApiKeyAuth
+// @Param filter query string false "Filter expression"
 // @Success 200 {object} trailmodels.TrailSummary
 // @Failure 400 {object} utils.RError
 // @Failure 404 {object} utils.RError
@@ -55,7 +100,7 @@ func (a *App) handleGetTrailSummary(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
-	summaryCol := a.mongoClient.Database("pantabase_devicesummary").Collection("device_summary_short_new_v2")
+	summaryCol := a.mongoClient.Database(trailmodels.SummaryDatabase).Collection(trailmodels.SummaryCollection)
 
 	if summaryCol == nil {
 		utils.RestErrorWrapper(w, "Error with Database connectivity", http.StatusInternalServerError)
@@ -78,11 +123,12 @@ func (a *App) handleGetTrailSummary(w rest.ResponseWriter, r *rest.Request) {
 	m := bson.M{}
 	filterParam := r.FormValue("filter")
 	if filterParam != "" {
-		err := json.Unmarshal([]byte(filterParam), &m)
+		filter, err := querymongo.ParseFilter(filterParam, summaryFilterSchema)
 		if err != nil {
 			utils.RestErrorWrapper(w, "Illegal Filter "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		m["$and"] = []interface{}{filter}
 	}
 
 	// always filter by owner...
@@ -0,0 +1,126 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// RecordOfflineProgress records progress that device reported for step rev
+// of its trail while it was offline, as if it had been put at progressTime.
+// The progress gets added to the progress history unless the same entry got
+// recorded before; it only becomes the progress of the step if nothing newer
+// got reported meanwhile.
+// source tells who submitted the progress for the history. Returns whether
+// the progress of the step got updated.
+func (a *App) RecordOfflineProgress(
+	pctx context.Context,
+	device string,
+	trailID primitive.ObjectID,
+	rev int,
+	progress trailmodels.StepProgress,
+	progressTime time.Time,
+	source string,
+) (bool, *utils.RError) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	collTrails := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+
+	if now := time.Now(); progressTime.IsZero() || progressTime.After(now) {
+		progressTime = now
+	}
+
+	stepID := trailID.Hex() + "-" + strconv.Itoa(rev)
+	step := trailmodels.Step{}
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	err := coll.FindOne(ctx, bson.M{
+		"_id":     stepID,
+		"device":  device,
+		"garbage": bson.M{"$ne": true},
+	}).Decode(&step)
+	if err == mongo.ErrNoDocuments {
+		return false, &utils.RError{Error: "Step " + stepID + " not found", Code: http.StatusNotFound}
+	}
+	if err != nil {
+		return false, &utils.RError{Error: "Error finding step " + stepID + ": " + err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	// reports may get submitted again; their entries are only recorded once
+	a.recordStepProgressOnce(pctx, stepID, progress, progressTime, source)
+
+	if step.ProgressTime.After(progressTime) {
+		return false, nil
+	}
+
+	isDevicePublic, err := a.IsDevicePublic(pctx, trailID)
+	if err != nil {
+		return false, &utils.RError{Error: "Error checking device is public or not: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	updateResult, err := coll.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":           stepID,
+			"device":        device,
+			"progress-time": bson.M{"$lte": progressTime},
+			"garbage":       bson.M{"$ne": true},
+		},
+		bson.M{"$set": bson.M{
+			"progress":      progress,
+			"progress-time": progressTime,
+			"timemodified":  time.Now(),
+			"ispublic":      isDevicePublic,
+		}},
+	)
+	if err != nil {
+		return false, &utils.RError{Error: "Cannot update step progress " + err.Error(), Code: http.StatusInternalServerError}
+	}
+	if updateResult.MatchedCount == 0 {
+		return false, nil
+	}
+
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	_, err = collTrails.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":     trailID,
+			"garbage": bson.M{"$ne": true},
+		},
+		bson.M{"$max": bson.M{"last-touched": progressTime}},
+	)
+	if err != nil {
+		log.Printf("Error updating last-touched for trail in offline progress; not failing because step was written: %s\n", trailID.Hex())
+	}
+
+	a.rollbackOnStatus(pctx, trailID, rev, progress.Status)
+	a.advanceStepQueueOnStatus(pctx, trailID, progress.Status)
+
+	return true, nil
+}
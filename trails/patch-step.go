@@ -0,0 +1,132 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	jsonpatch "github.com/asac/json-patch"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// ContentTypeJSONPatch content type of RFC 6902 JSON Patch step postings
+	ContentTypeJSONPatch = "application/json-patch+json"
+
+	// ContentTypeMergePatch content type of RFC 7396 JSON Merge Patch step postings
+	ContentTypeMergePatch = "application/merge-patch+json"
+)
+
+// PatchLatestStep builds a new step by applying patch to the state of the
+// latest step of trail. contentType selects if patch is a JSON Patch or a
+// JSON Merge Patch.
+//
+// If base is not empty it must match the latest rev; otherwise a conflict
+// is returned. The returned step has Rev set to latest rev + 1 so that a
+// concurrent posting for the same base fails on creation.
+func (a *App) PatchLatestStep(
+	pctx context.Context,
+	trail *trailmodels.Trail,
+	contentType string,
+	patch []byte,
+	base string,
+) (*trailmodels.Step, *utils.RError) {
+	collSteps := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	if collSteps == nil {
+		return nil, &utils.RError{Error: "Error with Database connectivity", Code: http.StatusInternalServerError}
+	}
+
+	latestRev, err := a.getLatestStepRev(pctx, trail.ID)
+	if err != nil {
+		return nil, &utils.RError{Error: "Error with getLatestStepRev: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	if base != "" {
+		baseRev, err := strconv.Atoi(base)
+		if err != nil {
+			return nil, &utils.RError{Error: "Invalid base rev: " + err.Error(), Code: http.StatusBadRequest}
+		}
+		if baseRev != latestRev {
+			return nil, &utils.RError{
+				Error: "Base rev " + base + " is not the latest rev " + strconv.Itoa(latestRev),
+				Code:  http.StatusConflict,
+			}
+		}
+	}
+
+	latestStep := trailmodels.Step{}
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	err = collSteps.FindOne(ctx, bson.M{
+		"_id":     trail.ID.Hex() + "-" + strconv.Itoa(latestRev),
+		"garbage": bson.M{"$ne": true},
+	}).Decode(&latestStep)
+	if err != nil {
+		return nil, &utils.RError{Error: "Error finding latest step: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	rerr := a.resolveRelease(pctx, &latestStep)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	latestState := utils.BsonUnquoteMap(&latestStep.State)
+	doc, err := json.Marshal(latestState)
+	if err != nil {
+		return nil, &utils.RError{Error: "Error encoding latest state: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	var patched []byte
+	switch contentType {
+	case ContentTypeJSONPatch:
+		jsonPatch, err := jsonpatch.DecodePatch(patch)
+		if err != nil {
+			return nil, &utils.RError{Error: "Invalid JSON Patch: " + err.Error(), Code: http.StatusBadRequest}
+		}
+		patched, err = jsonPatch.Apply(doc)
+		if err != nil {
+			return nil, &utils.RError{
+				Error: "JSON Patch does not apply to rev " + strconv.Itoa(latestRev) + ": " + err.Error(),
+				Code:  http.StatusConflict,
+			}
+		}
+	case ContentTypeMergePatch:
+		patched, err = jsonpatch.MergePatch(doc, patch)
+		if err != nil {
+			return nil, &utils.RError{Error: "Invalid JSON Merge Patch: " + err.Error(), Code: http.StatusBadRequest}
+		}
+	default:
+		return nil, &utils.RError{Error: "Unsupported patch content type " + contentType, Code: http.StatusUnsupportedMediaType}
+	}
+
+	newStep := &trailmodels.Step{
+		Rev:   latestRev + 1,
+		State: map[string]interface{}{},
+	}
+	err = json.Unmarshal(patched, &newStep.State)
+	if err != nil {
+		return nil, &utils.RError{Error: "Patched state is not a json object: " + err.Error(), Code: http.StatusBadRequest}
+	}
+
+	return newStep, nil
+}
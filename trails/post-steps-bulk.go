@@ -0,0 +1,279 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails/statevalidator"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// bulkStepsMax maximum number of steps in one bulk posting
+const bulkStepsMax = 1000
+
+// BulkStepResult result of posting the step of one trail in a bulk posting
+type BulkStepResult struct {
+	TrailID string `json:"trail-id"`
+	Rev     int    `json:"rev,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Code    int    `json:"code,omitempty"`
+	// Violations of the state if it failed validation
+	Violations []statevalidator.Violation `json:"violations,omitempty"`
+	// Incompatibilities of the state with the device if it is not compatible
+	Incompatibilities []Incompatibility `json:"incompatibilities,omitempty"`
+}
+
+// bulkStep returns the step to post for s with the shared values of bulk
+// filled in
+func bulkStep(bulk *trailmodels.BulkSteps, s *trailmodels.BulkStep) trailmodels.Step {
+	step := trailmodels.Step{
+		Rev:       s.Rev,
+		CommitMsg: s.CommitMsg,
+		State:     s.State,
+		Release:   s.Release,
+		Meta:      map[string]interface{}{},
+	}
+	if step.Rev == 0 {
+		step.Rev = -1
+	}
+	if step.CommitMsg == "" {
+		step.CommitMsg = bulk.CommitMsg
+	}
+	if len(step.State) == 0 && step.Release == "" {
+		step.State = bulk.State
+		step.Release = bulk.Release
+	}
+	for k, v := range bulk.Meta {
+		step.Meta[k] = v
+	}
+	for k, v := range s.Meta {
+		step.Meta[k] = v
+	}
+	return step
+}
+
+// handlePostBulkSteps Post steps to many trails in one call
+// @Summary Post steps to many trails in one call
+// @Description Post a step to each of the listed trails of the caller. Every step can have
+// @Description its own state (or release) or use the shared one of the payload; commit-msg
+// @Description and meta can be shared too. Objects get resolved once for the whole batch.
+// @Description By default every step gets posted on its own and the result lists the new
+// @Description rev or the error of every trail. With atomic set all steps get posted in one
+// @Description transaction: if one fails none gets posted (needs a mongo replica set) and
+// @Description superseded steps get cancelled and watchers notified only after the commit.
+// @Description Results of states failing validation list all violations.
+// @Description States get validated for their #spec unless validate=no is passed and
+// @Description checked against the requirements in their _compat.json unless compatibility=no.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param body body trailmodels.BulkSteps true "Bulk steps payload"
+// @Success 200 {array} BulkStepResult
+// @Failure 400 {object} utils.RError
+// @Failure 403 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/steps [post]
+func (a *App) handlePostBulkSteps(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	authType := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+	if authType != "USER" && authType != "SESSION" {
+		utils.RestErrorWrapper(w, "Need to be logged in as USER/SESSION to post bulk steps", http.StatusForbidden)
+		return
+	}
+	ownerStr, _ := owner.(string)
+
+	bulk := trailmodels.BulkSteps{}
+	err := r.DecodeJsonPayload(&bulk)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error decoding bulk steps payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(bulk.Steps) == 0 || len(bulk.Steps) > bulkStepsMax {
+		utils.RestErrorWrapper(w, "Bulk steps need between 1 and "+strconv.Itoa(bulkStepsMax)+" steps", http.StatusBadRequest)
+		return
+	}
+
+	autoLink := true
+	autolinkValue, ok := r.URL.Query()["autolink"]
+	if ok && autolinkValue[0] == "no" {
+		autoLink = false
+	}
+	checkCompat := CompatibilityRequested(r)
+
+	steps := make([]trailmodels.Step, len(bulk.Steps))
+	results := make([]BulkStepResult, len(bulk.Steps))
+	invalid := false
+	for i := range bulk.Steps {
+		steps[i] = bulkStep(&bulk, &bulk.Steps[i])
+		results[i].TrailID = bulk.Steps[i].TrailID
+
+		if ValidationRequested(r) {
+			violations := statevalidator.Validate(steps[i].State)
+			if len(violations) > 0 {
+				results[i].Error = "State validation failed"
+				results[i].Code = http.StatusBadRequest
+				results[i].Violations = violations
+				invalid = true
+			}
+		}
+	}
+
+	if bulk.Atomic {
+		if invalid {
+			w.WriteHeader(http.StatusBadRequest)
+			w.WriteJson(results)
+			return
+		}
+		rerr := a.postBulkStepsAtomic(rContext, ownerStr, bulk.Steps, steps, results, autoLink, checkCompat)
+		if rerr != nil {
+			w.WriteHeader(rerr.Code)
+			w.WriteJson(results)
+			return
+		}
+		w.WriteJson(results)
+		return
+	}
+
+	cache := newStateObjects()
+	for i := range steps {
+		if results[i].Error != "" {
+			continue
+		}
+		rerr := a.postBulkStep(rContext, ownerStr, bulk.Steps[i].TrailID, &steps[i], autoLink, checkCompat, cache, &results[i])
+		if rerr != nil {
+			results[i].Error = rerr.Error
+			results[i].Code = rerr.Code
+			continue
+		}
+		results[i].Rev = steps[i].Rev
+	}
+
+	w.WriteJson(results)
+}
+
+// postBulkStep posts step to the trail trailID of owner; result gets the
+// incompatibilities if the device is not compatible with it
+func (a *App) postBulkStep(
+	pctx context.Context,
+	owner string,
+	trailID string,
+	step *trailmodels.Step,
+	autoLink bool,
+	checkCompat bool,
+	cache *stateObjects,
+	result *BulkStepResult,
+) *utils.RError {
+	trailObjectID, err := primitive.ObjectIDFromHex(trailID)
+	if err != nil {
+		return &utils.RError{Error: "Invalid trail ID " + trailID, Code: http.StatusBadRequest}
+	}
+
+	trail, err := a.FindTrail(pctx, trailObjectID)
+	if err != nil || trail.Owner != owner {
+		return &utils.RError{Error: "No access to trail", Code: http.StatusNotFound}
+	}
+
+	// states got validated for the whole bulk before posting
+	checks := &StepChecks{SkipValidation: true, SkipCompatibility: !checkCompat}
+	rerr := a.createStep(pctx, trail, step, autoLink, checks, cache, false)
+	result.Incompatibilities = checks.Incompatibilities
+	return rerr
+}
+
+// postBulkStepsAtomic posts all steps in one transaction. results get the new
+// revs or, if a step fails, the error of that step. Steps, trails and the
+// objects they link get written within the transaction; cancelling superseded
+// steps, recording their progress history and notifying watchers only happen
+// once it got committed.
+func (a *App) postBulkStepsAtomic(
+	pctx context.Context,
+	owner string,
+	bulkSteps []trailmodels.BulkStep,
+	steps []trailmodels.Step,
+	results []BulkStepResult,
+	autoLink bool,
+	checkCompat bool,
+) *utils.RError {
+	session, err := a.mongoClient.StartSession()
+	if err != nil {
+		return &utils.RError{Error: "Error starting session: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+	defer session.EndSession(pctx)
+
+	var failed *utils.RError
+	var effects *commitEffects
+	_, err = session.WithTransaction(pctx, func(sctx mongo.SessionContext) (interface{}, error) {
+		// a retried transaction starts from scratch
+		var ctx context.Context
+		ctx, effects = withCommitEffects(sctx)
+		cache := newStateObjects()
+		failed = nil
+		for i := range steps {
+			results[i].Rev, results[i].Error, results[i].Code = 0, "", 0
+			results[i].Incompatibilities = nil
+		}
+
+		for i := range steps {
+			step := steps[i]
+			rerr := a.postBulkStep(ctx, owner, bulkSteps[i].TrailID, &step, autoLink, checkCompat, cache, &results[i])
+			if rerr != nil {
+				results[i].Error = rerr.Error
+				results[i].Code = rerr.Code
+				failed = rerr
+				return nil, errors.New(rerr.Error)
+			}
+			results[i].Rev = step.Rev
+		}
+		return nil, nil
+	})
+	if failed != nil {
+		for i := range results {
+			results[i].Rev = 0
+		}
+		return failed
+	}
+	if err != nil {
+		rerr := &utils.RError{Error: "Error posting steps: " + err.Error(), Code: http.StatusInternalServerError}
+		for i := range results {
+			results[i].Rev = 0
+			results[i].Error = rerr.Error
+			results[i].Code = rerr.Code
+		}
+		return rerr
+	}
+
+	effects.run(pctx)
+
+	return nil
+}
@@ -0,0 +1,52 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"reflect"
+	"testing"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+)
+
+func TestBulkStep(t *testing.T) {
+	shared := map[string]interface{}{"#spec": "pantavisor-service-system@1"}
+	own := map[string]interface{}{"#spec": "pantavisor-multi-platform@1"}
+	bulk := &trailmodels.BulkSteps{
+		State:     shared,
+		CommitMsg: "fleet update",
+		Meta:      map[string]interface{}{"campaign": "spring", "wave": 1},
+	}
+
+	step := bulkStep(bulk, &trailmodels.BulkStep{Meta: map[string]interface{}{"wave": 2}})
+	if step.Rev != -1 || step.CommitMsg != "fleet update" || !reflect.DeepEqual(step.State, shared) {
+		t.Errorf("bulkStep() did not use shared values: %+v", step)
+	}
+	if !reflect.DeepEqual(step.Meta, map[string]interface{}{"campaign": "spring", "wave": 2}) {
+		t.Errorf("bulkStep() meta = %v", step.Meta)
+	}
+
+	step = bulkStep(bulk, &trailmodels.BulkStep{Rev: 4, CommitMsg: "own", State: own})
+	if step.Rev != 4 || step.CommitMsg != "own" || !reflect.DeepEqual(step.State, own) {
+		t.Errorf("bulkStep() did not keep own values: %+v", step)
+	}
+
+	step = bulkStep(bulk, &trailmodels.BulkStep{Release: "abc"})
+	if step.Release != "abc" || len(step.State) != 0 {
+		t.Errorf("bulkStep() mixed own release with shared state: %+v", step)
+	}
+}
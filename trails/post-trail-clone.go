@@ -0,0 +1,262 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// handlePostTrailClone Clone the steps of a trail onto the trail of another device
+// @Summary Clone the steps of a trail onto the trail of another device
+// @Description Clone the latest (or with all set every) step of the trail onto the trail
+// @Description of another device of the owner, e.g. to replace faulty hardware. Steps get
+// @Description appended to the target trail with new revs; objects get linked, so nothing
+// @Description needs to be uploaded again. The factory-state of the trail gets copied too.
+// @Description Cloned steps record cloned-from-trail and cloned-from-rev in their meta.
+// @Description All but the last cloned step keep their progress (steps not final get
+// @Description cancelled), so only the last one gets applied by the device. If cloning
+// @Description fails the target trail is left as it was.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param id path string true "ID"
+// @Param body body trailmodels.CloneTrail true "Clone Payload"
+// @Param validate query string false "no to skip the state validation of the last step"
+// @Param compatibility query string false "no to skip the device compatibility check of the last step"
+// @Success 200 {object} trailmodels.CloneResult
+// @Failure 400 {object} utils.RError
+// @Failure 403 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/{id}/clone [post]
+func (a *App) handlePostTrailClone(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	authType := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+	if authType != "USER" && authType != "SESSION" {
+		utils.RestErrorWrapper(w, "Need to be logged in as USER/SESSION to clone trails", http.StatusForbidden)
+		return
+	}
+
+	trailObjectID, err := primitive.ObjectIDFromHex(r.PathParam("id"))
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid Hex:"+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clone := trailmodels.CloneTrail{}
+	err = r.DecodeJsonPayload(&clone)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error decoding clone payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	targetObjectID, err := primitive.ObjectIDFromHex(strings.TrimPrefix(clone.Device, "prn:::devices:/"))
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid target device "+clone.Device, http.StatusBadRequest)
+		return
+	}
+	if targetObjectID == trailObjectID {
+		utils.RestErrorWrapper(w, "Cannot clone a trail onto itself", http.StatusBadRequest)
+		return
+	}
+
+	source, err := a.FindTrail(rContext, trailObjectID)
+	if err != nil || source.Owner != owner {
+		utils.RestErrorWrapper(w, "No access to trail", http.StatusNotFound)
+		return
+	}
+
+	target, err := a.FindTrail(rContext, targetObjectID)
+	if err != nil || target.Owner != owner {
+		utils.RestErrorWrapper(w, "No access to target device trail", http.StatusNotFound)
+		return
+	}
+
+	checks := StepChecksFromRequest(r)
+	result, rerr := a.CloneTrail(rContext, source, target, clone.All, checks)
+	if rerr != nil {
+		if !writeFailedStepChecks(w, checks) {
+			utils.RestErrorWrite(w, rerr)
+		}
+		return
+	}
+
+	w.WriteJson(result)
+}
+
+// CloneTrail appends the latest (or all) steps of source to target and copies
+// the factory-state. On error the steps posted so far get removed again.
+// Both trails must have the same owner, which has to be checked by the
+// caller. The last step has to pass checks.
+func (a *App) CloneTrail(
+	pctx context.Context,
+	source *trailmodels.Trail,
+	target *trailmodels.Trail,
+	all bool,
+	checks *StepChecks,
+) (*trailmodels.CloneResult, *utils.RError) {
+	collSteps := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	collTrails := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"rev": 1})
+	if !all {
+		findOptions.SetSort(bson.M{"rev": -1})
+		findOptions.SetLimit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(pctx, 30*time.Second)
+	defer cancel()
+	cur, err := collSteps.Find(ctx, bson.M{
+		"trail-id": source.ID,
+		"garbage":  bson.M{"$ne": true},
+	}, findOptions)
+	if err != nil {
+		return nil, &utils.RError{Error: "Error finding steps: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+	steps := []trailmodels.Step{}
+	err = cur.All(ctx, &steps)
+	if err != nil {
+		return nil, &utils.RError{Error: "Error reading steps: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+	if len(steps) == 0 {
+		return nil, &utils.RError{Error: "No steps to clone", Code: http.StatusNotFound}
+	}
+
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	_, err = collTrails.UpdateOne(ctx, bson.M{
+		"_id":     target.ID,
+		"garbage": bson.M{"$ne": true},
+	}, bson.M{"$set": bson.M{
+		"factory-state": source.FactoryState,
+		"used_objects":  source.UsedObjects,
+	}})
+	if err != nil {
+		return nil, &utils.RError{Error: "Error copying factory-state: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	result := &trailmodels.CloneResult{
+		TrailID:    target.ID.Hex(),
+		Revs:       []int{},
+		ClonedRevs: []int{},
+	}
+	for i, step := range steps {
+		meta := utils.BsonUnquoteMap(&step.Meta)
+		if meta == nil {
+			meta = map[string]interface{}{}
+		}
+		meta["cloned-from-trail"] = source.ID.Hex()
+		meta["cloned-from-rev"] = step.Rev
+
+		newStep := trailmodels.Step{
+			Rev:       -1,
+			CommitMsg: step.CommitMsg,
+			Meta:      meta,
+			Release:   step.Release,
+		}
+		if step.Release == "" {
+			newStep.State = utils.BsonUnquoteMap(&step.State)
+		}
+
+		// only the last step is for the device to apply; history keeps
+		// its progress and does not get checked
+		last := i == len(steps)-1
+		stepChecks := checks
+		if !last {
+			stepChecks = &StepChecks{SkipValidation: true, SkipCompatibility: true}
+			newStep.StepProgress = clonedProgress(source, &step)
+			newStep.ProgressTime = step.ProgressTime
+		}
+
+		rerr := a.createStep(pctx, target, &newStep, true, stepChecks, nil, !last)
+		if rerr != nil {
+			a.undoClone(pctx, target, result.Revs)
+			return nil, rerr
+		}
+
+		result.Revs = append(result.Revs, newStep.Rev)
+		result.ClonedRevs = append(result.ClonedRevs, step.Rev)
+	}
+
+	return result, nil
+}
+
+// clonedProgress returns the progress a cloned history step gets: the one of
+// the step if final, otherwise it gets cancelled so the device never sees it
+func clonedProgress(source *trailmodels.Trail, step *trailmodels.Step) trailmodels.StepProgress {
+	if isFinalStepStatus(step.StepProgress.Status) {
+		return step.StepProgress
+	}
+	return trailmodels.StepProgress{
+		Status:    "CANCEL",
+		Progress:  100,
+		StatusMsg: "Cloned history of trail " + source.ID.Hex() + " rev " + strconv.Itoa(step.Rev),
+	}
+}
+
+// undoClone removes the steps with revs a failed clone posted to target and
+// restores its factory-state. Errors only get logged.
+func (a *App) undoClone(pctx context.Context, target *trailmodels.Trail, revs []int) {
+	collSteps := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	collTrails := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+
+	stepIDs := []string{}
+	for _, rev := range revs {
+		stepIDs = append(stepIDs, target.ID.Hex()+"-"+strconv.Itoa(rev))
+	}
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	if len(stepIDs) > 0 {
+		_, err := collSteps.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": stepIDs}})
+		if err != nil {
+			log.Printf("Error removing steps of failed clone onto trail %s: %s\n", target.ID.Hex(), err.Error())
+		}
+	}
+
+	_, err := collTrails.UpdateOne(ctx, bson.M{"_id": target.ID}, bson.M{"$set": bson.M{
+		"factory-state": target.FactoryState,
+		"used_objects":  target.UsedObjects,
+	}})
+	if err != nil {
+		log.Printf("Error restoring factory-state of trail %s after failed clone: %s\n", target.ID.Hex(), err.Error())
+	}
+
+	stepWatch.notify(target.ID.Hex())
+}
@@ -0,0 +1,139 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// handlePostTrailFactoryReset Post a step restoring the factory state of a trail
+// @Summary Post a step restoring the factory state of a trail
+// @Description Post a new step to the head of the trail whose state is the factory-state
+// @Description the device reported when the trail got created. Objects of the factory
+// @Description state that became garbage get restored. The step meta records factory-reset.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param id path string true "ID"
+// @Param body body trailmodels.FactoryReset false "Factory reset payload"
+// @Param validate query string false "no to skip the state validation"
+// @Param compatibility query string false "no to skip the device compatibility check"
+// @Success 200 {object} trailmodels.Step
+// @Failure 400 {object} utils.RError
+// @Failure 403 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 409 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/{id}/factory-reset [post]
+func (a *App) handlePostTrailFactoryReset(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	authType := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+	if authType != "USER" && authType != "SESSION" {
+		utils.RestErrorWrapper(w, "Need to be logged in as USER/SESSION to factory reset trails", http.StatusForbidden)
+		return
+	}
+
+	trailObjectID, err := primitive.ObjectIDFromHex(r.PathParam("id"))
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid Hex:"+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reset := trailmodels.FactoryReset{}
+	if r.ContentLength != 0 {
+		err = r.DecodeJsonPayload(&reset)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Error decoding factory reset payload: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	trail, err := a.FindTrail(rContext, trailObjectID)
+	if err != nil || trail.Owner != owner {
+		utils.RestErrorWrapper(w, "No access to trail", http.StatusNotFound)
+		return
+	}
+
+	checks := StepChecksFromRequest(r)
+	step, rerr := a.FactoryReset(rContext, trail, &reset, checks)
+	if rerr != nil {
+		if !writeFailedStepChecks(w, checks) {
+			utils.RestErrorWrite(w, rerr)
+		}
+		return
+	}
+
+	w.WriteJson(step)
+}
+
+// FactoryReset posts a new step to trail with its factory state, which has to
+// pass checks. Ownership of the trail must have been checked by the caller.
+func (a *App) FactoryReset(
+	pctx context.Context,
+	trail *trailmodels.Trail,
+	reset *trailmodels.FactoryReset,
+	checks *StepChecks,
+) (*trailmodels.Step, *utils.RError) {
+	if len(trail.FactoryState) == 0 {
+		return nil, &utils.RError{Error: "Trail has no factory state", Code: http.StatusConflict}
+	}
+
+	err := RestoreObjects(pctx, trail.UsedObjects, a)
+	if err != nil {
+		return nil, &utils.RError{Error: "Error restoring factory objects: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	meta := map[string]interface{}{}
+	for k, v := range reset.Meta {
+		meta[k] = v
+	}
+	meta["factory-reset"] = true
+
+	commitMsg := reset.CommitMsg
+	if commitMsg == "" {
+		commitMsg = "Factory reset"
+	}
+
+	step := &trailmodels.Step{
+		Rev:       -1,
+		CommitMsg: commitMsg,
+		Meta:      meta,
+		State:     utils.BsonUnquoteMap(&trail.FactoryState),
+	}
+
+	rerr := a.CreateStep(pctx, trail, step, true, checks)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	return step, nil
+}
@@ -0,0 +1,138 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// handlePostStepCompose Post a new step composed from fragments of other steps.
+// @Summary Post a new step composed from fragments of other steps.
+// @Description Post a new step to the head of the trail with the state of the base step
+// @Description (default latest) where the fragments of each part get replaced by the ones
+// @Description of the step of another trail, e.g. bsp of device A rev 12 and nginx of
+// @Description device B rev 40. Parts can be taken from trails of the caller and from
+// @Description public devices; devices can only take parts from their own and public
+// @Description trails. Objects get linked like for any other step posting, so nothing
+// @Description needs to be uploaded again.
+// @Description The state gets validated for its #spec unless validate=no is passed and
+// @Description checked against the requirements in its _compat.json unless compatibility=no.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param id path string true "ID"
+// @Param body body trailmodels.ComposeStep true "Compose Payload"
+// @Param validate query string false "no to skip the state validation"
+// @Param compatibility query string false "no to skip the device compatibility check"
+// @Success 200 {object} trailmodels.Step
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 409 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/{id}/steps/compose [post]
+func (a *App) handlePostStepCompose(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["owner"]
+
+	// if not a device there wont be an owner; so we use the caller (aka prn)
+	if !ok {
+		owner, ok = r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+		if !ok {
+			utils.RestErrorWrapper(w, "You need to be logged in as user or device", http.StatusForbidden)
+			return
+		}
+	}
+
+	authType, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+	if authType != "USER" && authType != "DEVICE" && authType != "SESSION" {
+		utils.RestErrorWrapper(w, "Need to be logged in as USER to post trail steps", http.StatusForbidden)
+		return
+	}
+
+	trailObjectID, err := primitive.ObjectIDFromHex(r.PathParam("id"))
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid Hex:"+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	trail, err := a.FindTrail(rContext, trailObjectID)
+	if err != nil {
+		utils.RestErrorWrapper(w, "No resource access possible", http.StatusNotFound)
+		return
+	}
+
+	if trail.Owner != owner {
+		utils.RestErrorWrapper(w, "No access", http.StatusForbidden)
+		return
+	}
+
+	compose := trailmodels.ComposeStep{}
+	err = r.DecodeJsonPayload(&compose)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error decoding compose payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// parts get read with the access of the caller; devices can only read
+	// their own trail and public trails, not the ones of their siblings
+	readAs := owner
+	if authType == "DEVICE" {
+		readAs = r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	}
+
+	state, rerr := a.ComposeState(rContext, trail, &compose, readAs, authType)
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
+
+	newStep := trailmodels.Step{
+		Rev:       compose.Rev,
+		CommitMsg: compose.CommitMsg,
+		Meta:      compose.Meta,
+		State:     state,
+	}
+	if newStep.Rev == 0 {
+		newStep.Rev = -1
+	}
+
+	autoLink := true
+	autolinkValue, ok := r.URL.Query()["autolink"]
+	if ok && autolinkValue[0] == "no" {
+		autoLink = false
+	}
+
+	checks := StepChecksFromRequest(r)
+	rerr = a.CreateStep(rContext, trail, &newStep, autoLink, checks)
+	if rerr != nil {
+		if !writeFailedStepChecks(w, checks) {
+			utils.RestErrorWrite(w, rerr)
+		}
+		return
+	}
+
+	w.WriteJson(newStep)
+}
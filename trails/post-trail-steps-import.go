@@ -0,0 +1,164 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// handlePostStepImport Import a pvr tarball as new step of the trail.
+// @Summary Import a pvr tarball as new step of the trail.
+// @Description Import a tar or tar.gz pvr tarball, like the ones the exports service
+// @Description produces, as new step of the trail. The tarball has the state as "json"
+// @Description and the objects as "objects/<sha>"; objects get verified against their
+// @Description sha and stored subject to the disk quota of the owner; objects the state does
+// @Description not reference get skipped. Objects missing in
+// @Description the tarball must already be available to the owner or get linked.
+// @Description rev defaults to the next rev. The state gets validated and checked for
+// @Description compatibility like on step posting.
+// @Accept  application/octet-stream
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param id path string true "ID"
+// @Param rev query int false "Rev of the new step"
+// @Param commit-msg query string false "Commit message of the new step"
+// @Param validate query string false "no to skip the state validation"
+// @Param compatibility query string false "no to skip the device compatibility check"
+// @Param autolink query string false "no to not link missing objects"
+// @Success 200 {object} trailmodels.Step
+// @Failure 400 {object} utils.RError
+// @Failure 403 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 409 {object} utils.RError
+// @Failure 412 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/{id}/steps/import [post]
+func (a *App) handlePostStepImport(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["owner"]
+	if !ok {
+		owner, ok = r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+		if !ok {
+			utils.RestErrorWrapper(w, "You need to be logged in as user or device", http.StatusForbidden)
+			return
+		}
+	}
+
+	authType, _ := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+	if authType != "USER" && authType != "DEVICE" && authType != "SESSION" {
+		utils.RestErrorWrapper(w, "Need to be logged in as USER to post trail steps", http.StatusForbidden)
+		return
+	}
+
+	trailObjectID, err := primitive.ObjectIDFromHex(r.PathParam("id"))
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid Hex:"+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	trail, err := a.FindTrail(rContext, trailObjectID)
+	if err == mongo.ErrNoDocuments {
+		utils.RestErrorWrapper(w, "Trail not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.RestErrorWrapper(w, "No resource access possible", http.StatusInternalServerError)
+		return
+	}
+
+	if trail.Owner != owner {
+		utils.RestErrorWrapper(w, "No access", http.StatusForbidden)
+		return
+	}
+
+	newStep := trailmodels.Step{
+		Rev:       -1,
+		CommitMsg: r.URL.Query().Get("commit-msg"),
+	}
+	if revStr := r.URL.Query().Get("rev"); revStr != "" {
+		newStep.Rev, err = strconv.Atoi(revStr)
+		if err != nil || newStep.Rev < 1 {
+			utils.RestErrorWrapper(w, "Invalid rev "+revStr, http.StatusBadRequest)
+			return
+		}
+	}
+
+	quota, err := a.importQuota(rContext, trail.Owner)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error calculating disk quota: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	defer r.Body.Close()
+	body := http.MaxBytesReader(nil, r.Body, quota+importStateMaxSize+importTarOverhead)
+	imported, err := readImportTar(body, quota)
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, errImportQuotaExceeded) || errors.As(err, &maxBytesErr) {
+		utils.RestErrorWrapperUser(w, err.Error(), errImportQuotaExceeded.Error(), http.StatusPreconditionFailed)
+		return
+	}
+	if err != nil {
+		utils.RestErrorWrapper(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer imported.Close()
+
+	newStep.State = imported.State
+
+	// check before storing any object of the tarball
+	checks := StepChecksFromRequest(r)
+	rerr := a.checkStep(rContext, trail, &newStep, checks)
+	if rerr != nil {
+		if !writeFailedStepChecks(w, checks) {
+			utils.RestErrorWrite(w, rerr)
+		}
+		return
+	}
+
+	rerr = a.importStateObjects(rContext, trail.Owner, imported)
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
+
+	autoLink := true
+	autolinkValue, ok := r.URL.Query()["autolink"]
+	if ok && autolinkValue[0] == "no" {
+		autoLink = false
+	}
+
+	// checked before importing the objects
+	rerr = a.CreateStep(rContext, trail, &newStep, autoLink, &StepChecks{SkipValidation: true, SkipCompatibility: true})
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
+
+	w.WriteJson(newStep)
+}
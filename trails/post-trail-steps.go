@@ -20,9 +20,9 @@
 package trails
 
 import (
-	"log"
+	"io"
+	"mime"
 	"net/http"
-	"strconv"
 	"time"
 
 	"context"
@@ -43,15 +43,31 @@ import (
 // @Description In the DB the ID will be composite of trails ID + Rev; this ensures that
 // @Description it will be unique. Also no step will be added if the previous one does not
 // @Description exist that. This will include completeness of the step rev sequence.
+// @Description With Content-Type application/json-patch+json or application/merge-patch+json
+// @Description the body is a patch that gets applied to the state of the latest step. Use
+// @Description base=<rev> to get a conflict if the latest rev moved and commit-msg=<msg>
+// @Description to set the commit message of the new step.
+// @Description With from-tag=<tag> the state of the step the tag points to gets used; the
+// @Description body then only needs commit-msg and meta and rev defaults to the next rev.
+// @Description The state gets validated for its #spec unless validate=no is passed.
+// @Description The requirements in _compat.json of the state (or release) get checked
+// @Description against the device-meta of the device; with compatibility=no the step gets
+// @Description posted anyway.
 // @Accept  json
 // @Produce  json
 // @Tags trails
 // @Security ApiKeyAuth
 // @Param id path string true "ID|NICK|PRN"
 // @Param body body trailmodels.Step true "Step Payload"
+// @Param base query string false "Expected latest rev for patch postings"
+// @Param commit-msg query string false "Commit message for patch postings"
+// @Param from-tag query string false "Tag of the step to take the state from"
+// @Param validate query string false "no to skip the state validation"
+// @Param compatibility query string false "no to skip the device compatibility check"
 // @Success 200 {object} trailmodels.Trail
 // @Failure 400 {object} utils.RError
 // @Failure 404 {object} utils.RError
+// @Failure 409 {object} utils.RError
 // @Failure 500 {object} utils.RError
 // @Router /trails/{id}/steps [post]
 func (a *App) handlePostStep(w rest.ResponseWriter, r *rest.Request) {
@@ -110,83 +126,34 @@ func (a *App) handlePostStep(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
-	collSteps := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
-
-	if collSteps == nil {
-		utils.RestErrorWrapper(w, "Error with Database connectivity", http.StatusInternalServerError)
-		return
-	}
-
 	newStep := trailmodels.Step{}
-	previousStep := trailmodels.Step{}
-	r.DecodeJsonPayload(&newStep)
-
-	if newStep.Rev == -1 {
-		trailObjectID, err := primitive.ObjectIDFromHex(trailID)
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType == ContentTypeJSONPatch || contentType == ContentTypeMergePatch {
+		patch, err := io.ReadAll(r.Body)
 		if err != nil {
-			utils.RestErrorWrapper(w, "Invalid Hex:"+err.Error(), http.StatusInternalServerError)
+			utils.RestErrorWrapper(w, "Error reading patch: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		ctx, cancel := context.WithTimeout(rContext, 10*time.Second)
-		defer cancel()
-		newStep.Rev, err = a.getLatestStepRev(ctx, trailObjectID)
-		if err != nil {
-			utils.RestErrorWrapper(w, "Error with getLatestStepRev: "+err.Error(), http.StatusInternalServerError)
+		patchedStep, rerr := a.PatchLatestStep(rContext, &trail, contentType, patch, r.URL.Query().Get("base"))
+		if rerr != nil {
+			utils.RestErrorWrite(w, rerr)
 			return
 		}
-		newStep.Rev++
-	}
-
-	if err != nil {
-		utils.RestErrorWrapper(w, "Error auto appending step 1 "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	stepID := trailID + "-" + strconv.Itoa(newStep.Rev-1)
-	ctx, cancel = context.WithTimeout(rContext, 10*time.Second)
-	defer cancel()
-	err = collSteps.FindOne(ctx, bson.M{
-		"_id":     stepID,
-		"garbage": bson.M{"$ne": true},
-	}).Decode(&previousStep)
-
-	if err != nil {
-		// XXX: figure how to be better on error cases here...
-		utils.RestErrorWrapper(w, "No access to resource or bad step "+stepID, http.StatusInternalServerError)
-		return
-	}
-
-	// XXX: introduce step diffs here and store them precalced
-
-	newStep.ID = trail.ID.Hex() + "-" + strconv.Itoa(newStep.Rev)
-	newStep.Owner = trail.Owner
-	newStep.Device = trail.Device
-	newStep.StepProgress = trailmodels.StepProgress{
-		Status: "NEW",
-	}
-	newStep.TrailID = trail.ID
-	now := time.Now()
-	newStep.StepTime = now
-	newStep.ProgressTime = time.Unix(0, 0)
-	newStep.TimeCreated = now
-	newStep.TimeModified = now
-	newStep.IsPublic = previousStep.IsPublic
-
-	ctx, cancel = context.WithTimeout(rContext, 10*time.Second)
-	defer cancel()
-
-	isDevicePublic, err := a.IsDevicePublic(ctx, newStep.TrailID)
-	if err != nil {
-		utils.RestErrorWrapper(w, "Error checking device is public or not: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	newStep.IsPublic = isDevicePublic
-
-	// IMPORTANT: statesha has to be before state as that will be escaped
-	newStep.StateSha, err = utils.StateSha(&newStep.State)
-	if err != nil {
-		utils.RestErrorWrapper(w, "Error calculating Sha "+err.Error(), http.StatusInternalServerError)
-		return
+		newStep = *patchedStep
+		newStep.CommitMsg = r.URL.Query().Get("commit-msg")
+	} else if fromTag := r.URL.Query().Get("from-tag"); fromTag != "" {
+		r.DecodeJsonPayload(&newStep)
+		state, rerr := a.StateFromTag(rContext, &trail, fromTag)
+		if rerr != nil {
+			utils.RestErrorWrite(w, rerr)
+			return
+		}
+		newStep.State = state
+		if newStep.Rev == 0 {
+			newStep.Rev = -1
+		}
+	} else {
+		r.DecodeJsonPayload(&newStep)
 	}
 
 	autoLink := true
@@ -195,59 +162,14 @@ func (a *App) handlePostStep(w rest.ResponseWriter, r *rest.Request) {
 		autoLink = false
 	}
 
-	ctx, cancel = context.WithTimeout(rContext, 10*time.Second)
-	defer cancel()
-
-	objectList, err := ProcessObjectsInState(ctx, newStep.Owner, newStep.State, autoLink, a)
-	if err != nil {
-		utils.RestErrorWrapper(w, "Error processing step objects in state: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	newStep.UsedObjects = objectList
-	newStep.State = utils.BsonQuoteMap(&newStep.State)
-	if newStep.Meta == nil {
-		newStep.Meta = map[string]interface{}{}
-	}
-	newStep.Meta = utils.BsonQuoteMap(&newStep.Meta)
-	newStep.TimeModified = time.Now()
-	newStep.TimeCreated = time.Now()
-
-	ctx, cancel = context.WithTimeout(rContext, 10*time.Second)
-	defer cancel()
-	_, err = collSteps.InsertOne(
-		ctx,
-		newStep,
-	)
-
-	if err != nil {
-		// XXX: figure how to be better on error cases here...
-		utils.RestErrorWrapper(w, "No access to resource or bad step rev1 "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	ctx, cancel = context.WithTimeout(rContext, 10*time.Second)
-	defer cancel()
-	updateResult, err := collTrails.UpdateOne(
-		ctx,
-		bson.M{
-			"_id":     trail.ID,
-			"garbage": bson.M{"$ne": true},
-		},
-		bson.M{"$set": bson.M{
-			"last-touched": newStep.StepTime,
-		}},
-	)
-	if updateResult.MatchedCount == 0 {
-		utils.RestErrorWrapper(w, "Trail not found", http.StatusBadRequest)
+	checks := StepChecksFromRequest(r)
+	rerr := a.CreateStep(rContext, &trail, &newStep, autoLink, checks)
+	if rerr != nil {
+		if !writeFailedStepChecks(w, checks) {
+			utils.RestErrorWrite(w, rerr)
+		}
 		return
 	}
-	if err != nil {
-		// XXX: figure how to be better on error cases here...
-		log.Printf("Error updating last-touched for trail in poststep; not failing because step was written: %s\n  => ERROR: %s\n ", trail.ID.Hex(), err.Error())
-	}
-
-	newStep.State = utils.BsonUnquoteMap(&newStep.State)
-	newStep.Meta = utils.BsonUnquoteMap(&newStep.Meta)
 
 	w.WriteJson(newStep)
 }
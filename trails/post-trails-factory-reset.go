@@ -0,0 +1,113 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/devices"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// FleetFactoryReset payload to factory reset the trails of the selected devices
+type FleetFactoryReset struct {
+	Selector  devices.Selector       `json:"selector"`
+	CommitMsg string                 `json:"commit-msg"`
+	Meta      map[string]interface{} `json:"meta"`
+}
+
+// handlePostTrailsFactoryReset Factory reset the trails of a set of devices
+// @Summary Factory reset the trails of a set of devices
+// @Description Post a step restoring the factory-state to the trail of every device of the
+// @Description owner matching the selector, e.g. to return RMA devices to a known baseline.
+// @Description Devices are selected by ID or nick and/or user-meta and device-meta values;
+// @Description all must be set explicitly to reset every device. The result lists the new
+// @Description rev or the error of every selected device.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param body body FleetFactoryReset true "Fleet factory reset payload"
+// @Param validate query string false "no to skip the state validation"
+// @Param compatibility query string false "no to skip the device compatibility check"
+// @Success 200 {array} trailmodels.FactoryResetResult
+// @Failure 400 {object} utils.RError
+// @Failure 403 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/factory-reset [post]
+func (a *App) handlePostTrailsFactoryReset(w rest.ResponseWriter, r *rest.Request) {
+	rContext := context.WithoutCancel(r.Context())
+
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	authType := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+	if authType != "USER" && authType != "SESSION" {
+		utils.RestErrorWrapper(w, "Need to be logged in as USER/SESSION to factory reset trails", http.StatusForbidden)
+		return
+	}
+	ownerStr, _ := owner.(string)
+
+	reset := FleetFactoryReset{}
+	err := r.DecodeJsonPayload(&reset)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error decoding factory reset payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if reset.Selector.IsEmpty() {
+		utils.RestErrorWrapper(w, devices.ErrEmptySelector.Error(), http.StatusBadRequest)
+		return
+	}
+
+	devs, err := devices.Build(a.mongoClient).FindDevicesBySelector(rContext, ownerStr, &reset.Selector)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error finding devices: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	results := []trailmodels.FactoryResetResult{}
+	for _, device := range devs {
+		result := trailmodels.FactoryResetResult{TrailID: device.ID.Hex()}
+
+		trail, err := a.FindTrail(rContext, device.ID)
+		if err != nil || trail.Owner != ownerStr {
+			result.Error = "No trail found for device"
+			results = append(results, result)
+			continue
+		}
+
+		step, rerr := a.FactoryReset(rContext, trail, &trailmodels.FactoryReset{
+			CommitMsg: reset.CommitMsg,
+			Meta:      reset.Meta,
+		}, StepChecksFromRequest(r))
+		if rerr != nil {
+			result.Error = rerr.Error
+		} else {
+			result.Rev = step.Rev
+		}
+		results = append(results, result)
+	}
+
+	w.WriteJson(results)
+}
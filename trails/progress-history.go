@@ -0,0 +1,113 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// ProgressHistoryCollection collection keeping every progress update of steps
+const ProgressHistoryCollection = "pantahub_step_progress_history"
+
+const (
+	// ProgressSourceDevice progress reported by the device
+	ProgressSourceDevice = "device"
+
+	// ProgressSourceOwner progress set by the owner (e.g. cancel)
+	ProgressSourceOwner = "owner"
+
+	// ProgressSourceSystem progress set by pantahub (e.g. rollouts)
+	ProgressSourceSystem = "system"
+
+	// ProgressSourceOwnerReport progress a device collected offline that the
+	// owner submitted on its behalf
+	ProgressSourceOwnerReport = "owner-report"
+)
+
+// recordStepProgress appends progress of step stepID to its progress history.
+// Errors only get logged as the progress itself got stored with the step.
+func (a *App) recordStepProgress(pctx context.Context, stepID string, progress trailmodels.StepProgress, progressTime time.Time, source string) {
+	entry, err := stepProgressEntry(stepID, progress, progressTime, source)
+	if err != nil {
+		log.Printf("Error recording progress history of step %s: %s\n", stepID, err.Error())
+		return
+	}
+
+	coll := a.mongoClient.Database(utils.MongoDb).Collection(ProgressHistoryCollection)
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	_, err = coll.InsertOne(ctx, entry)
+	if err != nil {
+		log.Printf("Error recording progress history of step %s: %s\n", stepID, err.Error())
+	}
+}
+
+// recordStepProgressOnce is recordStepProgress for progress that can get
+// submitted more than once, like offline reports that get uploaded again
+// after a failure. An entry with the same progress-time, source and status
+// is not added again.
+func (a *App) recordStepProgressOnce(pctx context.Context, stepID string, progress trailmodels.StepProgress, progressTime time.Time, source string) {
+	entry, err := stepProgressEntry(stepID, progress, progressTime, source)
+	if err != nil {
+		log.Printf("Error recording progress history of step %s: %s\n", stepID, err.Error())
+		return
+	}
+
+	coll := a.mongoClient.Database(utils.MongoDb).Collection(ProgressHistoryCollection)
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	_, err = coll.UpdateOne(ctx, bson.M{
+		"step-id":         entry.StepID,
+		"progress-time":   entry.ProgressTime,
+		"source":          entry.Source,
+		"progress.status": entry.Progress.Status,
+	}, bson.M{"$setOnInsert": entry}, options.Update().SetUpsert(true))
+	if err != nil {
+		log.Printf("Error recording progress history of step %s: %s\n", stepID, err.Error())
+	}
+}
+
+// stepProgressEntry makes the progress history entry of step stepID
+func stepProgressEntry(stepID string, progress trailmodels.StepProgress, progressTime time.Time, source string) (trailmodels.StepProgressEntry, error) {
+	trailID := trailIDFromStepID(stepID)
+	trailObjectID, err := primitive.ObjectIDFromHex(trailID)
+	if err != nil {
+		return trailmodels.StepProgressEntry{}, err
+	}
+	rev, _ := strconv.Atoi(strings.TrimPrefix(stepID, trailID+"-"))
+
+	return trailmodels.StepProgressEntry{
+		ID:           primitive.NewObjectID(),
+		StepID:       stepID,
+		TrailID:      trailObjectID,
+		Rev:          rev,
+		Progress:     progress,
+		ProgressTime: progressTime,
+		Source:       source,
+		TimeCreated:  time.Now(),
+	}, nil
+}
@@ -0,0 +1,121 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// handlePutTrailPolicy Replace the policy of a trail
+// @Summary Replace the policy of a trail
+// @Description Replace the policy of a trail. Only the owner of the trail can change it.
+// @Description With auto-rollback enabled a step reported ERROR or WONTGO gets followed
+// @Description by a new step restoring the last revision that reached DONE.
+// @Description The retention policy decides which old steps expire and become garbage;
+// @Description without one the retention defaults of the owner's subscription apply.
+// @Description In sequential mode new steps wait until the steps before them are final;
+// @Description turning it off releases the waiting step.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param id path string true "ID|NICK|PRN"
+// @Param body body trailmodels.TrailPolicy true "TrailPolicy payload"
+// @Success 200 {object} trailmodels.TrailPolicy
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/{id}/policy [put]
+func (a *App) handlePutTrailPolicy(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		// XXX: find right error
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	authType, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+	if authType != "USER" && authType != "SESSION" {
+		utils.RestErrorWrapper(w, "Need to be logged in as USER/SESSION to change trail policy", http.StatusForbidden)
+		return
+	}
+
+	trailID, err := primitive.ObjectIDFromHex(r.PathParam("id"))
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid trail ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	policy := trailmodels.TrailPolicy{}
+	err = r.DecodeJsonPayload(&policy)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error decoding policy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if policy.Retention != nil && (policy.Retention.KeepRevs < 0 || policy.Retention.DropNotAppliedAfterDays < 0) {
+		utils.RestErrorWrapper(w, "Retention values must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	collTrails := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+	if collTrails == nil {
+		utils.RestErrorWrapper(w, "Error with Database connectivity", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	updateResult, err := collTrails.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":     trailID,
+			"owner":   owner,
+			"garbage": bson.M{"$ne": true},
+		},
+		bson.M{"$set": bson.M{
+			"policy": policy,
+		}},
+	)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error updating trail policy: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if updateResult.MatchedCount == 0 {
+		utils.RestErrorWrapper(w, "No access to trail", http.StatusNotFound)
+		return
+	}
+
+	// without sequential mode waiting steps must not wait any longer
+	if !policy.Sequential {
+		err = a.AdvanceStepQueue(r.Context(), trailID, true)
+		if err != nil {
+			utils.RestErrorWrapper(w, "Error releasing waiting steps: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteJson(policy)
+}
@@ -22,6 +22,7 @@ package trails
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"context"
@@ -38,6 +39,7 @@ import (
 // @Summary Cancel a step that is in NEW state.
 // @Description Cancel a step that is in NEW state.
 // @Description Only owner can cancel steps and only those steps still in NEW state.
+// @Description Steps WAITING in the queue of a sequential trail can be cancelled too.
 // @Accept  json
 // @Produce  json
 // @Tags trails
@@ -108,7 +110,7 @@ func (a *App) handlePutStepProgressCancel(w rest.ResponseWriter, r *rest.Request
 		bson.M{
 			"_id":             stepID,
 			"owner":           owner,
-			"progress.status": "NEW",
+			"progress.status": bson.M{"$in": []string{"NEW", StepStatusWaiting}},
 			"garbage":         bson.M{"$ne": true},
 		},
 		bson.M{"$set": bson.M{
@@ -119,7 +121,7 @@ func (a *App) handlePutStepProgressCancel(w rest.ResponseWriter, r *rest.Request
 		}},
 	)
 	if updateResult.MatchedCount == 0 {
-		utils.RestErrorWrapper(w, "Error cancelling step. A step in state NEW was not found", http.StatusBadRequest)
+		utils.RestErrorWrapper(w, "Error cancelling step. A step in state NEW or WAITING was not found", http.StatusBadRequest)
 		return
 	}
 
@@ -127,6 +129,9 @@ func (a *App) handlePutStepProgressCancel(w rest.ResponseWriter, r *rest.Request
 		utils.RestErrorWrapper(w, "Cannot canel step "+err.Error(), http.StatusForbidden)
 		return
 	}
+	stepWatch.notify(trailID)
+	a.recordStepProgress(r.Context(), stepID, stepProgress, progressTime, ProgressSourceOwner)
+
 	ctx, cancel = context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 	trailObjectID, err := primitive.ObjectIDFromHex(trailID)
@@ -152,6 +157,8 @@ func (a *App) handlePutStepProgressCancel(w rest.ResponseWriter, r *rest.Request
 		log.Printf("Error updating last-touched for trail of cancelled step; not failing because step got written successfully: %s\n", trailID)
 	}
 
+	a.advanceStepQueueOnStatus(context.WithoutCancel(r.Context()), trailObjectID, stepProgress.Status)
+
 	w.WriteJson(stepProgress)
 }
 
@@ -248,6 +255,8 @@ func (a *App) handlePutStepProgressWontgo(w rest.ResponseWriter, r *rest.Request
 		utils.RestErrorWrapper(w, "Cannot canel step "+err.Error(), http.StatusForbidden)
 		return
 	}
+	a.recordStepProgress(r.Context(), stepID, stepProgress, progressTime, ProgressSourceOwner)
+
 	ctx, cancel = context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 	trailObjectID, err := primitive.ObjectIDFromHex(trailID)
@@ -273,5 +282,11 @@ func (a *App) handlePutStepProgressWontgo(w rest.ResponseWriter, r *rest.Request
 		log.Printf("Error updating last-touched for trail of cancelled step; not failing because step got written successfully: %s\n", trailID)
 	}
 
+	rev, err := strconv.Atoi(r.PathParam("rev"))
+	if err == nil {
+		a.rollbackOnStatus(context.WithoutCancel(r.Context()), trailObjectID, rev, stepProgress.Status)
+	}
+	a.advanceStepQueueOnStatus(context.WithoutCancel(r.Context()), trailObjectID, stepProgress.Status)
+
 	w.WriteJson(stepProgress)
 }
@@ -0,0 +1,135 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// handlePutTrailTag Point a tag of a trail to a rev
+// @Summary Point a tag of a trail to a rev
+// @Description Create a tag or move an existing one to rev. Tags can be used instead of
+// @Description the rev number in all GET step routes and in exports. Tag names start with
+// @Description a letter, contain only letters, digits, "-" and "_" and "latest" is reserved.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param id path string true "ID|NICK|PRN"
+// @Param tag path string true "Tag name"
+// @Param body body trailmodels.TagRev true "Rev to point the tag to"
+// @Success 200 {object} map[string]int
+// @Failure 400 {object} utils.RError
+// @Failure 404 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/{id}/tags/{tag} [put]
+func (a *App) handlePutTrailTag(w rest.ResponseWriter, r *rest.Request) {
+	owner, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["prn"]
+	if !ok {
+		// XXX: find right error
+		utils.RestErrorWrapper(w, "You need to be logged in", http.StatusForbidden)
+		return
+	}
+
+	authType, ok := r.Env["JWT_PAYLOAD"].(jwtgo.MapClaims)["type"]
+	if authType != "USER" && authType != "SESSION" {
+		utils.RestErrorWrapper(w, "Need to be logged in as USER/SESSION to change trail tags", http.StatusForbidden)
+		return
+	}
+
+	trailID, err := primitive.ObjectIDFromHex(r.PathParam("id"))
+	if err != nil {
+		utils.RestErrorWrapper(w, "Invalid trail ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tag := r.PathParam("tag")
+	if !ValidTagName(tag) {
+		utils.RestErrorWrapper(w, "Invalid tag name "+tag, http.StatusBadRequest)
+		return
+	}
+
+	tagRev := trailmodels.TagRev{}
+	err = r.DecodeJsonPayload(&tagRev)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error decoding tag payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	collSteps := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	collTrails := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+	if collSteps == nil || collTrails == nil {
+		utils.RestErrorWrapper(w, "Error with Database connectivity", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	count, err := collSteps.CountDocuments(ctx, bson.M{
+		"_id":     trailID.Hex() + "-" + strconv.Itoa(tagRev.Rev),
+		"owner":   owner,
+		"garbage": bson.M{"$ne": true},
+	})
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error finding step: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if count == 0 {
+		utils.RestErrorWrapper(w, "Step rev "+strconv.Itoa(tagRev.Rev)+" not found", http.StatusNotFound)
+		return
+	}
+
+	ctx, cancel = context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	updateResult, err := collTrails.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":     trailID,
+			"owner":   owner,
+			"garbage": bson.M{"$ne": true},
+		},
+		bson.M{"$set": bson.M{
+			"tags." + tag: tagRev.Rev,
+		}},
+	)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error updating trail tags: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if updateResult.MatchedCount == 0 {
+		utils.RestErrorWrapper(w, "No access to trail", http.StatusNotFound)
+		return
+	}
+
+	trail, err := a.FindTrail(r.Context(), trailID)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error reading trail: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteJson(trail.Tags)
+}
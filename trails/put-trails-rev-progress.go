@@ -22,6 +22,7 @@ package trails
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"context"
@@ -125,6 +126,8 @@ func (a *App) handlePutStepProgress(w rest.ResponseWriter, r *rest.Request) {
 		utils.RestErrorWrapper(w, "Cannot update step progress "+err.Error(), http.StatusForbidden)
 		return
 	}
+	a.recordStepProgress(r.Context(), stepID, stepProgress, progressTime, ProgressSourceDevice)
+
 	ctx, cancel = context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 	trailObjectID, err := primitive.ObjectIDFromHex(trailID)
@@ -150,5 +153,11 @@ func (a *App) handlePutStepProgress(w rest.ResponseWriter, r *rest.Request) {
 		log.Printf("Error updating last-touched for trail in poststepprogress; not failing because step was written: %s\n", trailID)
 	}
 
+	rev, err := strconv.Atoi(r.PathParam("rev"))
+	if err == nil {
+		a.rollbackOnStatus(context.WithoutCancel(r.Context()), trailObjectID, rev, stepProgress.Status)
+	}
+	a.advanceStepQueueOnStatus(context.WithoutCancel(r.Context()), trailObjectID, stepProgress.Status)
+
 	w.WriteJson(stepProgress)
 }
@@ -27,6 +27,7 @@ import (
 
 	"github.com/ant0ine/go-json-rest/rest"
 	jwtgo "github.com/dgrijalva/jwt-go"
+	"gitlab.com/pantacor/pantahub-base/trails/statevalidator"
 	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
 	"gitlab.com/pantacor/pantahub-base/utils"
 	"gopkg.in/mgo.v2/bson"
@@ -35,6 +36,7 @@ import (
 // handlePutStepState Put step state (only if not yet consumed)
 // @Summary Put step state (only if not yet consumed)
 // @Description put step state (only if not yet consumed). just the raw data of a step without metainfo like pvr pu
+// @Description The state gets validated for its #spec unless validate=no is passed.
 // @Accept  json
 // @Produce  json
 // @Tags trails
@@ -102,6 +104,27 @@ func (a *App) handlePutStepState(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 
+	if ValidationRequested(r) {
+		violations := statevalidator.Validate(stateMap)
+		if len(violations) > 0 {
+			WriteStateViolations(w, violations)
+			return
+		}
+	}
+
+	trail, err := a.FindTrail(r.Context(), step.TrailID)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error finding trail: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var rerr *utils.RError
+	step.Signatures, rerr = a.checkStateSignatures(r.Context(), trail, stateMap)
+	if rerr != nil {
+		utils.RestErrorWrite(w, rerr)
+		return
+	}
+
 	step.StateSha, err = utils.StateSha(&stateMap)
 	if err != nil {
 		utils.RestErrorWrapper(w, "Error with request: "+err.Error(), http.StatusBadRequest)
@@ -126,6 +149,7 @@ func (a *App) handlePutStepState(w rest.ResponseWriter, r *rest.Request) {
 		return
 	}
 	step.UsedObjects = objectList
+	step.StateKeys = stateKeys(stateMap)
 	step.State = utils.BsonQuoteMap(&stateMap)
 
 	step.TimeModified = time.Now()
@@ -139,6 +163,17 @@ func (a *App) handlePutStepState(w rest.ResponseWriter, r *rest.Request) {
 	}
 	step.IsPublic = isDevicePublic
 
+	// the state is stored with the step from now on
+	step.Release = ""
+	unset := bson.M{"release": ""}
+	if len(step.StateKeys) == 0 {
+		unset["state-keys"] = ""
+	}
+	if step.Signatures == nil {
+		unset["signatures"] = ""
+	}
+	update := bson.M{"$set": step, "$unset": unset}
+
 	ctx, cancel = context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 	updateResult, err := coll.UpdateOne(
@@ -149,7 +184,7 @@ func (a *App) handlePutStepState(w rest.ResponseWriter, r *rest.Request) {
 			"progress.status": "NEW",
 			"garbage":         bson.M{"$ne": true},
 		},
-		bson.M{"$set": step},
+		update,
 	)
 	if updateResult.MatchedCount == 0 {
 		utils.RestErrorWrapper(w, "Error updating step state: not found", http.StatusBadRequest)
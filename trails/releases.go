@@ -0,0 +1,37 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/trails/trailservices"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// resolveRelease fills in the (bson quoted) state of step if it references a release
+func (a *App) resolveRelease(ctx context.Context, step *trailmodels.Step) *utils.RError {
+	trailService := trailservices.CreateService(a.mongoClient, utils.MongoDb)
+	return trailService.ResolveRelease(ctx, step)
+}
+
+// findRelease finds the release sha of owner
+func (a *App) findRelease(ctx context.Context, owner string, sha string) (*trailmodels.Release, *utils.RError) {
+	trailService := trailservices.CreateService(a.mongoClient, utils.MongoDb)
+	return trailService.GetRelease(ctx, owner, sha)
+}
@@ -0,0 +1,278 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/subscriptions"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// SubscriptionRetentionKeepRevs subscription property with the default
+	// number of latest revisions kept per trail
+	SubscriptionRetentionKeepRevs = "RETENTION-KEEP-REVS"
+
+	// SubscriptionRetentionDropNotAppliedDays subscription property with the
+	// default days after which WONTGO and CANCEL steps expire
+	SubscriptionRetentionDropNotAppliedDays = "RETENTION-DROP-NOT-APPLIED-DAYS"
+)
+
+// expiredSteps returns the steps that expire under policy. steps must be
+// sorted by rev and tags are the tags of their trail.
+func expiredSteps(steps []trailmodels.Step, policy *trailmodels.RetentionPolicy, tags map[string]int, now time.Time) []trailmodels.Step {
+	expired := []trailmodels.Step{}
+	if policy == nil || len(steps) == 0 {
+		return expired
+	}
+
+	head := steps[len(steps)-1].Rev
+	current := -1
+	for _, s := range steps {
+		if s.StepProgress.Status == "DONE" {
+			current = s.Rev
+		}
+	}
+	tagged := map[int]bool{}
+	for _, rev := range tags {
+		tagged[rev] = true
+	}
+	notAppliedBefore := now.AddDate(0, 0, -policy.DropNotAppliedAfterDays)
+
+	for _, s := range steps {
+		status := s.StepProgress.Status
+		switch {
+		case s.Rev == 0 || s.Rev == head:
+			continue
+		case !isFinalStepStatus(status):
+			continue
+		case policy.KeepTagged && tagged[s.Rev]:
+			continue
+		case policy.KeepCurrent && s.Rev == current:
+			continue
+		}
+
+		outOfRange := policy.KeepRevs > 0 && s.Rev <= head-policy.KeepRevs
+		notApplied := policy.DropNotAppliedAfterDays > 0 &&
+			(status == "WONTGO" || status == "CANCEL") &&
+			s.ProgressTime.Before(notAppliedBefore)
+		if outOfRange || notApplied {
+			expired = append(expired, s)
+		}
+	}
+
+	return expired
+}
+
+// isFinalStepStatus returns true if a step with status does not progress anymore
+func isFinalStepStatus(status string) bool {
+	for _, s := range finalStepStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// retentionFromSubscription reads the default retention policy of an owner
+// from its subscription. Properties missing in the subscription are taken
+// from its plan. It returns nil if nothing expires.
+func retentionFromSubscription(sub subscriptions.Subscription) *trailmodels.RetentionPolicy {
+	var planProperties map[string]interface{}
+	plan := strings.Split(string(sub.GetPlan()), ":/")[0]
+	if p, ok := subscriptions.SubscriptionProperties[utils.Prn(plan)].(map[string]interface{}); ok {
+		planProperties = p
+	}
+
+	property := func(key string) int {
+		v := sub.GetProperty(key)
+		if !sub.HasProperty(key) {
+			v = planProperties[key]
+		}
+		s, _ := v.(string)
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 0 {
+			return 0
+		}
+		return i
+	}
+
+	policy := &trailmodels.RetentionPolicy{
+		KeepRevs:                property(SubscriptionRetentionKeepRevs),
+		KeepTagged:              true,
+		KeepCurrent:             true,
+		DropNotAppliedAfterDays: property(SubscriptionRetentionDropNotAppliedDays),
+	}
+	if policy.KeepRevs == 0 && policy.DropNotAppliedAfterDays == 0 {
+		return nil
+	}
+	return policy
+}
+
+// ProcessRetention marks the steps of all trails that expired under their
+// retention policy as garbage and drops their progress history.
+// Trails without a retention policy use the defaults of the subscription
+// of their owner.
+func (a *App) ProcessRetention(ctx context.Context) ([]trailmodels.RetentionResult, error) {
+	collTrails := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+
+	subService := subscriptions.NewService(a.mongoClient, utils.Prn("prn::subscriptions:"),
+		utils.GetSubscriptionAdmins(), subscriptions.SubscriptionProperties)
+	ownerPolicies := map[string]*trailmodels.RetentionPolicy{}
+
+	findOptions := options.Find()
+	findOptions.SetNoCursorTimeout(true)
+	findOptions.SetProjection(bson.M{"owner": 1, "policy": 1, "tags": 1})
+	cur, err := collTrails.Find(ctx, bson.M{"garbage": bson.M{"$ne": true}}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	results := []trailmodels.RetentionResult{}
+	for cur.Next(ctx) {
+		trail := trailmodels.Trail{}
+		err := cur.Decode(&trail)
+		if err != nil {
+			return nil, err
+		}
+
+		policy := trail.Policy.Retention
+		if policy == nil {
+			var ok bool
+			policy, ok = ownerPolicies[trail.Owner]
+			if !ok {
+				sub, err := subService.LoadBySubject(ctx, utils.Prn(trail.Owner))
+				if err != nil {
+					sub = subService.GetDefaultSubscription(utils.Prn(trail.Owner))
+				}
+				policy = retentionFromSubscription(sub)
+				ownerPolicies[trail.Owner] = policy
+			}
+		}
+		if policy == nil {
+			continue
+		}
+
+		result, err := a.applyRetention(ctx, &trail, policy)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		if len(result.ExpiredRevs) > 0 || result.Error != "" {
+			results = append(results, result)
+		}
+	}
+
+	return results, nil
+}
+
+// applyRetention marks the expired steps of trail as garbage and drops their
+// progress history
+func (a *App) applyRetention(pctx context.Context, trail *trailmodels.Trail, policy *trailmodels.RetentionPolicy) (trailmodels.RetentionResult, error) {
+	collSteps := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	collTrails := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+
+	result := trailmodels.RetentionResult{
+		TrailID:     trail.ID.Hex(),
+		Owner:       trail.Owner,
+		ExpiredRevs: []int{},
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"rev": 1})
+	findOptions.SetProjection(bson.M{
+		"rev":             1,
+		"progress.status": 1,
+		"progress-time":   1,
+	})
+
+	ctx, cancel := context.WithTimeout(pctx, 30*time.Second)
+	defer cancel()
+	cur, err := collSteps.Find(ctx, bson.M{
+		"trail-id": trail.ID,
+		"garbage":  bson.M{"$ne": true},
+	}, findOptions)
+	if err != nil {
+		return result, err
+	}
+	steps := []trailmodels.Step{}
+	err = cur.All(ctx, &steps)
+	if err != nil {
+		return result, err
+	}
+
+	expired := expiredSteps(steps, policy, trail.Tags, time.Now())
+	if len(expired) == 0 {
+		return result, nil
+	}
+
+	stepIDs := []string{}
+	expiredRevs := map[int]bool{}
+	for _, s := range expired {
+		stepIDs = append(stepIDs, s.ID)
+		expiredRevs[s.Rev] = true
+	}
+
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	_, err = collSteps.UpdateMany(ctx, bson.M{
+		"_id":     bson.M{"$in": stepIDs},
+		"garbage": bson.M{"$ne": true},
+	}, bson.M{"$set": bson.M{
+		"garbage":      true,
+		"timemodified": time.Now(),
+	}})
+	if err != nil {
+		return result, err
+	}
+	for _, s := range expired {
+		result.ExpiredRevs = append(result.ExpiredRevs, s.Rev)
+	}
+
+	// tags must not point to steps that are gone
+	unset := bson.M{}
+	for tag, rev := range trail.Tags {
+		if expiredRevs[rev] {
+			unset["tags."+tag] = ""
+		}
+	}
+	if len(unset) > 0 {
+		ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+		defer cancel()
+		_, err = collTrails.UpdateOne(ctx, bson.M{"_id": trail.ID}, bson.M{"$unset": unset})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	// objects of the steps get collected by pantahub-gc; the progress
+	// history of the steps goes with them
+	ctx, cancel = context.WithTimeout(pctx, 30*time.Second)
+	defer cancel()
+	_, err = a.mongoClient.Database(utils.MongoDb).Collection(ProgressHistoryCollection).DeleteMany(ctx, bson.M{
+		"step-id": bson.M{"$in": stepIDs},
+	})
+
+	return result, err
+}
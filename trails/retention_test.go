@@ -0,0 +1,73 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+)
+
+func retentionTestStep(rev int, status string, progressTime time.Time) trailmodels.Step {
+	return trailmodels.Step{
+		Rev:          rev,
+		StepProgress: trailmodels.StepProgress{Status: status},
+		ProgressTime: progressTime,
+	}
+}
+
+func TestExpiredSteps(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	old := now.AddDate(0, 0, -40)
+	steps := []trailmodels.Step{
+		retentionTestStep(0, "DONE", old),
+		retentionTestStep(1, "DONE", old),
+		retentionTestStep(2, "ERROR", old),
+		retentionTestStep(3, "DONE", old),
+		retentionTestStep(4, "WONTGO", old),
+		retentionTestStep(5, "CANCEL", now),
+		retentionTestStep(6, "INPROGRESS", now),
+		retentionTestStep(7, "NEW", now),
+	}
+
+	tests := []struct {
+		name   string
+		policy *trailmodels.RetentionPolicy
+		tags   map[string]int
+		want   []int
+	}{
+		{"no policy", nil, nil, []int{}},
+		{"keep all", &trailmodels.RetentionPolicy{}, nil, []int{}},
+		{"keep revs", &trailmodels.RetentionPolicy{KeepRevs: 4}, nil, []int{1, 2, 3}},
+		{"keep tagged and current", &trailmodels.RetentionPolicy{KeepRevs: 4, KeepTagged: true, KeepCurrent: true},
+			map[string]int{"golden": 1}, []int{2}},
+		{"drop not applied", &trailmodels.RetentionPolicy{DropNotAppliedAfterDays: 30}, nil, []int{4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []int{}
+			for _, s := range expiredSteps(steps, tt.policy, tt.tags, now) {
+				got = append(got, s.Rev)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expiredSteps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// MetaRollbackOf step meta key holding the failed rev a rollback step reverts
+	MetaRollbackOf = "rollback-of"
+
+	// MetaRollbackTo step meta key holding the rev a rollback step restores
+	MetaRollbackTo = "rollback-to"
+
+	// MetaRollbackReason step meta key holding the status of the failed step
+	MetaRollbackReason = "rollback-reason"
+)
+
+// isRollbackStatus tells if a step progress status triggers an automatic rollback
+func isRollbackStatus(status string) bool {
+	return status == "ERROR" || status == "WONTGO"
+}
+
+// RollbackFailedStep posts the last DONE revision before failedRev as new
+// step if the trail has the auto-rollback policy enabled.
+//
+// Nothing gets posted if failedRev is not the latest step anymore, if the
+// failed step was a rollback itself or if there is no DONE step to go back
+// to. In these cases nil is returned without error.
+func (a *App) RollbackFailedStep(pctx context.Context, trailID primitive.ObjectID, failedRev int) (*trailmodels.Step, error) {
+	trail, err := a.FindTrail(pctx, trailID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !trail.Policy.AutoRollback {
+		return nil, nil
+	}
+
+	collSteps := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	if collSteps == nil {
+		return nil, errors.New("error with database connectivity")
+	}
+
+	latestRev, err := a.getLatestStepRev(pctx, trailID)
+	if err != nil {
+		return nil, err
+	}
+	if latestRev != failedRev {
+		return nil, nil
+	}
+
+	failedStep := trailmodels.Step{}
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	err = collSteps.FindOne(ctx, bson.M{
+		"trail-id": trailID,
+		"rev":      failedRev,
+		"garbage":  bson.M{"$ne": true},
+	}).Decode(&failedStep)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isRollbackStatus(failedStep.StepProgress.Status) {
+		return nil, nil
+	}
+
+	// never roll back a rollback to avoid ping-pong between bad revisions
+	if _, ok := failedStep.Meta[MetaRollbackOf]; ok {
+		return nil, nil
+	}
+
+	goodStep := trailmodels.Step{}
+	findOneOptions := options.FindOne()
+	findOneOptions.SetSort(bson.M{"rev": -1})
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	err = collSteps.FindOne(ctx, bson.M{
+		"trail-id":        trailID,
+		"rev":             bson.M{"$lt": failedRev},
+		"progress.status": "DONE",
+		"garbage":         bson.M{"$ne": true},
+	}, findOneOptions).Decode(&goodStep)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	status := failedStep.StepProgress.Status
+	rollbackStep := trailmodels.Step{
+		Rev:       -1,
+		CommitMsg: fmt.Sprintf("Rollback to rev %d as rev %d reported %s", goodStep.Rev, failedRev, status),
+		State:     utils.BsonUnquoteMap(&goodStep.State),
+		Release:   goodStep.Release,
+		Meta: map[string]interface{}{
+			MetaRollbackOf:     failedRev,
+			MetaRollbackTo:     goodStep.Rev,
+			MetaRollbackReason: status,
+		},
+	}
+
+	// the state ran on the device before; checks that changed meanwhile
+	// must not keep it from getting back to it
+	rerr := a.CreateStep(pctx, trail, &rollbackStep, true, &StepChecks{SkipValidation: true, SkipCompatibility: true})
+	if rerr != nil {
+		return nil, errors.New(rerr.Error)
+	}
+
+	collTrails := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	_, err = collTrails.UpdateOne(
+		ctx,
+		bson.M{
+			"_id":     trailID,
+			"garbage": bson.M{"$ne": true},
+		},
+		bson.M{"$set": bson.M{
+			"last-rollback": trailmodels.Rollback{
+				FailedRev:    failedRev,
+				FailedStatus: status,
+				ToRev:        goodStep.Rev,
+				Rev:          rollbackStep.Rev,
+				Time:         rollbackStep.StepTime,
+			},
+		}},
+	)
+	if err != nil {
+		// XXX: figure how to be better on error cases here...
+		log.Printf("Error recording last-rollback for trail %s; not failing because rollback step got written: %s\n", trailID.Hex(), err.Error())
+	}
+
+	return &rollbackStep, nil
+}
+
+// rollbackOnStatus triggers RollbackFailedStep for steps that got a rollback
+// status. Errors are only logged as the progress update itself succeeded.
+func (a *App) rollbackOnStatus(pctx context.Context, trailID primitive.ObjectID, rev int, status string) {
+	if !isRollbackStatus(status) {
+		return
+	}
+
+	step, err := a.RollbackFailedStep(pctx, trailID, rev)
+	if err != nil {
+		log.Printf("Error rolling back rev %d of trail %s: %s\n", rev, trailID.Hex(), err.Error())
+		return
+	}
+	if step != nil {
+		log.Printf("Posted rollback step %s for rev %d reporting %s\n", step.ID, rev, status)
+	}
+}
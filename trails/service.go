@@ -20,8 +20,10 @@
 package trails
 
 import (
+	"errors"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"context"
@@ -29,6 +31,7 @@ import (
 	"github.com/ant0ine/go-json-rest/rest"
 	jwt "github.com/pantacor/go-json-rest-middleware-jwt"
 	"gitlab.com/pantacor/pantahub-base/metrics"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
 	"gitlab.com/pantacor/pantahub-base/utils"
 	"gitlab.com/pantacor/pantahub-base/utils/tracer"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -36,6 +39,13 @@ import (
 	"go.mongodb.org/mongo-driver/x/bsonx"
 )
 
+// Build factory a new trails App only with mongoClient
+func Build(mongoClient *mongo.Client) *App {
+	return &App{
+		mongoClient: mongoClient,
+	}
+}
+
 // New create a new trails rest application
 //
 //	finish getsteps
@@ -208,6 +218,125 @@ func New(jwtMiddleware *jwt.JWTMiddleware, mongoClient *mongo.Client) *App {
 		return nil
 	}
 
+	// INDEXES FOR STEP SEARCH
+	collection = app.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	searchIndexes := []bsonx.Doc{
+		{
+			{Key: "commit-msg", Value: bsonx.String("text")},
+		},
+		{
+			{Key: "owner", Value: bsonx.Int32(1)},
+			{Key: "used_objects", Value: bsonx.Int32(1)},
+		},
+		{
+			{Key: "owner", Value: bsonx.Int32(1)},
+			{Key: "state-keys", Value: bsonx.Int32(1)},
+		},
+	}
+	for _, keys := range searchIndexes {
+		CreateIndexesOptions = options.CreateIndexesOptions{}
+		CreateIndexesOptions.SetMaxTime(10 * time.Second)
+
+		indexOptions = options.IndexOptions{}
+		indexOptions.SetUnique(false)
+		indexOptions.SetSparse(false)
+		indexOptions.SetBackground(true)
+
+		index = mongo.IndexModel{
+			Keys:    keys,
+			Options: &indexOptions,
+		}
+		_, err = collection.Indexes().CreateOne(context.Background(), index, &CreateIndexesOptions)
+		if err != nil {
+			log.Fatalln("Error setting up search index for pantahub_steps: " + err.Error())
+			return nil
+		}
+	}
+
+	// INDEX FOR STEP PROGRESS HISTORY
+	collection = app.mongoClient.Database(utils.MongoDb).Collection(ProgressHistoryCollection)
+
+	CreateIndexesOptions = options.CreateIndexesOptions{}
+	CreateIndexesOptions.SetMaxTime(10 * time.Second)
+
+	indexOptions = options.IndexOptions{}
+	indexOptions.SetUnique(false)
+	indexOptions.SetSparse(false)
+	indexOptions.SetBackground(true)
+
+	index = mongo.IndexModel{
+		Keys: bsonx.Doc{
+			{Key: "step-id", Value: bsonx.Int32(1)},
+			{Key: "progress-time", Value: bsonx.Int32(1)},
+		},
+		Options: &indexOptions,
+	}
+	_, err = collection.Indexes().CreateOne(context.Background(), index, &CreateIndexesOptions)
+	if err != nil {
+		log.Fatalln("Error setting up index for " + ProgressHistoryCollection + ": " + err.Error())
+		return nil
+	}
+
+	historyTTLDays, err := strconv.Atoi(utils.GetEnv(utils.EnvPantahubProgressHistoryTTLDays))
+	if err != nil {
+		log.Fatalln("Error parsing " + utils.EnvPantahubProgressHistoryTTLDays + ": " + err.Error())
+		return nil
+	}
+	if historyTTLDays > 0 {
+		indexOptions = options.IndexOptions{}
+		indexOptions.SetBackground(true)
+		indexOptions.SetExpireAfterSeconds(int32(historyTTLDays * 24 * 60 * 60))
+
+		index = mongo.IndexModel{
+			Keys: bsonx.Doc{
+				{Key: "time-created", Value: bsonx.Int32(1)},
+			},
+			Options: &indexOptions,
+		}
+		_, err = collection.Indexes().CreateOne(context.Background(), index, &CreateIndexesOptions)
+		if err != nil {
+			// e.g. the index exists with another ttl; history still works
+			log.Println("Error setting up ttl index for " + ProgressHistoryCollection + ": " + err.Error())
+		}
+	}
+
+	go app.watchStepChanges(context.Background())
+
+	if utils.GetEnv(utils.EnvPantahubSummaryMaterialize) == "true" {
+		// INDEX FOR SUMMARIES BY DEVICE
+		CreateIndexesOptions = options.CreateIndexesOptions{}
+		CreateIndexesOptions.SetMaxTime(10 * time.Second)
+
+		// unique as summaries get upserted by deviceid
+		indexOptions = options.IndexOptions{}
+		indexOptions.SetUnique(true)
+		indexOptions.SetSparse(false)
+		indexOptions.SetBackground(true)
+
+		index = mongo.IndexModel{
+			Keys: bsonx.Doc{
+				{Key: "deviceid", Value: bsonx.Int32(1)},
+			},
+			Options: &indexOptions,
+		}
+		collection = app.mongoClient.Database(trailmodels.SummaryDatabase).Collection(trailmodels.SummaryCollection)
+		_, err = collection.Indexes().CreateOne(context.Background(), index, &CreateIndexesOptions)
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) && (cmdErr.Name == "IndexOptionsConflict" || cmdErr.Name == "IndexKeySpecsConflict") {
+			// summaries maintained by kafka connect have a non unique index
+			_, err = collection.Indexes().DropOne(context.Background(), "deviceid_1")
+			if err == nil {
+				_, err = collection.Indexes().CreateOne(context.Background(), index, &CreateIndexesOptions)
+			}
+		}
+		if err != nil {
+			log.Fatalln("Error setting up index for " + trailmodels.SummaryCollection + " (duplicate summaries of a device need to be removed first): " + err.Error())
+			return nil
+		}
+
+		go app.materializeSummaries(context.Background())
+	}
+
 	app.API = rest.NewApi()
 
 	// we dont use default stack because we dont want content type enforcement
@@ -265,14 +394,25 @@ func New(jwtMiddleware *jwt.JWTMiddleware, mongoClient *mongo.Client) *App {
 		rest.Get("/", utils.ScopeFilter(readTrailsScopes, app.handleGetTrails)),
 		rest.Post("/", utils.ScopeFilter(writeTrailsScopes, app.handlePostTrail)),
 		rest.Get("/summary", utils.ScopeFilter(readTrailsScopes, app.handleGetTrailSummary)),
+		rest.Post("/validate", utils.ScopeFilter(readTrailsScopes, app.handlePostValidateState)),
+		rest.Post("/steps", utils.ScopeFilter(writeTrailsScopes, app.handlePostBulkSteps)),
+		rest.Get("/steps/progress", utils.ScopeFilter(readTrailsScopes, app.handleGetStepsProgress)),
+		rest.Get("/steps/search", utils.ScopeFilter(readTrailsScopes, app.handleGetStepsSearch)),
+		rest.Post("/factory-reset", utils.ScopeFilter(writeTrailsScopes, app.handlePostTrailsFactoryReset)),
 		rest.Get("/#id", utils.ScopeFilter(readTrailsScopes, app.handleGetTrail)),
 		rest.Get("/#id/.pvrremote", utils.ScopeFilter(readTrailsScopes, app.handleGetTrailPvrInfo)),
+		rest.Post("/#id/clone", utils.ScopeFilter(writeTrailsScopes, app.handlePostTrailClone)),
+		rest.Post("/#id/factory-reset", utils.ScopeFilter(writeTrailsScopes, app.handlePostTrailFactoryReset)),
 		rest.Get("/#id/steps", utils.ScopeFilter(readTrailsScopes, app.handleGetSteps)),
 		rest.Post("/#id/steps", utils.ScopeFilter(writeTrailsScopes, app.handlePostStep)),
+		rest.Post("/#id/steps/compose", utils.ScopeFilter(writeTrailsScopes, app.handlePostStepCompose)),
+		rest.Post("/#id/steps/import", utils.ScopeFilter(writeTrailsScopes, app.handlePostStepImport)),
+		rest.Get("/#id/steps/watch", utils.ScopeFilter(readTrailsScopes, app.handleGetStepsWatch)),
 		rest.Get("/#id/steps/#rev", utils.ScopeFilter(readTrailsScopes, app.handleGetStep)),
 		rest.Get("/#id/steps/#rev/.pvrremote", utils.ScopeFilter(readTrailsScopes, app.handleGetStepPvrInfo)),
 		rest.Get("/#id/steps/#rev/meta", utils.ScopeFilter(readTrailsScopes, app.handleGetStepMeta)),
 		rest.Get("/#id/steps/#rev/state", utils.ScopeFilter(readTrailsScopes, app.handleGetStepState)),
+		rest.Get("/#id/steps/#rev/diff", utils.ScopeFilter(readTrailsScopes, app.handleGetStepDiff)),
 		rest.Get("/#id/steps/#rev/objects", utils.ScopeFilter(readTrailsScopes, app.handleGetStepsObjects)),
 		rest.Get("/#id/steps/#rev/objects/#obj", utils.ScopeFilter(readTrailsScopes, app.handleGetStepsObject)),
 		rest.Get("/#id/steps/#rev/objects/#obj/blob", utils.ScopeFilter(readTrailsScopes, app.handleGetStepsObjectFile)),
@@ -280,9 +420,15 @@ func New(jwtMiddleware *jwt.JWTMiddleware, mongoClient *mongo.Client) *App {
 		rest.Put("/#id/steps/#rev/meta", utils.ScopeFilter(writeTrailsScopes, app.handlePutStepMeta)),
 		rest.Put("/#id/steps/#rev/state", utils.ScopeFilter(writeTrailsScopes, app.handlePutStepState)),
 		rest.Put("/#id/steps/#rev/progress", utils.ScopeFilter(writeTrailsScopes, app.handlePutStepProgress)),
+		rest.Get("/#id/steps/#rev/progress/history", utils.ScopeFilter(readTrailsScopes, app.handleGetStepProgressHistory)),
 		rest.Put("/#id/steps/#rev/cancel", utils.ScopeFilter(writeTrailsScopes, app.handlePutStepProgressCancel)),
 		rest.Put("/#id/steps/#rev/wontgo", utils.ScopeFilter(writeTrailsScopes, app.handlePutStepProgressWontgo)),
 		rest.Get("/#id/summary", utils.ScopeFilter(readTrailsScopes, app.handleGetTrailStepSummary)),
+		rest.Get("/#id/policy", utils.ScopeFilter(readTrailsScopes, app.handleGetTrailPolicy)),
+		rest.Put("/#id/policy", utils.ScopeFilter(writeTrailsScopes, app.handlePutTrailPolicy)),
+		rest.Get("/#id/tags", utils.ScopeFilter(readTrailsScopes, app.handleGetTrailTags)),
+		rest.Put("/#id/tags/#tag", utils.ScopeFilter(writeTrailsScopes, app.handlePutTrailTag)),
+		rest.Delete("/#id/tags/#tag", utils.ScopeFilter(writeTrailsScopes, app.handleDeleteTrailTag)),
 	)
 	app.API.Use(&tracer.OtelMiddleware{
 		ServiceName: os.Getenv("OTEL_SERVICE_NAME"),
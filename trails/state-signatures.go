@@ -0,0 +1,94 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"gitlab.com/pantacor/pantahub-base/signing"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// requireSigned tells if steps of trail need verified signatures covering the
+// whole state; the trail policy wins over the signing policy of the owner
+func (a *App) requireSigned(ctx context.Context, trail *trailmodels.Trail) (bool, error) {
+	if trail.Policy.RequireSigned != nil {
+		return *trail.Policy.RequireSigned, nil
+	}
+
+	policy, err := signing.Build(a.mongoClient).GetPolicy(ctx, trail.Owner)
+	if err != nil {
+		return false, err
+	}
+
+	return policy.RequireSigned, nil
+}
+
+// checkStateSignatures verifies the signatures of state against the trusted
+// keys of the trail owner. Result is nil for unsigned states on trails that
+// do not require signatures. If signatures are required and they do not
+// verify or do not cover the whole state an error is returned.
+func (a *App) checkStateSignatures(
+	ctx context.Context,
+	trail *trailmodels.Trail,
+	state map[string]interface{},
+) (*trailmodels.StepSignatures, *utils.RError) {
+	required, err := a.requireSigned(ctx, trail)
+	if err != nil {
+		return nil, &utils.RError{Error: "Error reading signing policy: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	if !required && !signing.HasSignatures(state) {
+		return nil, nil
+	}
+
+	keys, err := signing.Build(a.mongoClient).Keys(ctx, trail.Owner)
+	if err != nil {
+		return nil, &utils.RError{Error: "Error reading signing keys: " + err.Error(), Code: http.StatusInternalServerError}
+	}
+
+	result, err := signing.VerifyState(state, keys)
+	if err != nil {
+		return nil, &utils.RError{Error: "Error verifying state signatures: " + err.Error(), Code: http.StatusBadRequest}
+	}
+
+	if !required || result.Complete {
+		return result, nil
+	}
+
+	if len(result.Signatures) == 0 {
+		return nil, &utils.RError{Error: "Trail requires signed states but state has no signatures", Code: http.StatusBadRequest}
+	}
+
+	failed := []string{}
+	for _, s := range result.Signatures {
+		if !s.Verified {
+			failed = append(failed, s.Name+" ("+s.Error+")")
+		}
+	}
+	if len(failed) > 0 {
+		return nil, &utils.RError{Error: "State signatures do not verify: " + strings.Join(failed, ", "), Code: http.StatusBadRequest}
+	}
+
+	return nil, &utils.RError{
+		Error: "State entries not covered by a verified signature: " + strings.Join(result.Uncovered, ", "),
+		Code:  http.StatusBadRequest,
+	}
+}
@@ -0,0 +1,231 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package statevalidator
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// SpecMultiPlatform pantavisor state with bsp and platforms
+	SpecMultiPlatform = "pantavisor-multi-platform@1"
+
+	// SpecServiceEmbed pantavisor embedded service state
+	SpecServiceEmbed = "pantavisor-service-embed@1"
+
+	// SpecServiceSystem pantavisor system service state
+	SpecServiceSystem = "pantavisor-service-system@1"
+
+	// SpecServiceManifestRun #spec of platform run.json documents
+	SpecServiceManifestRun = "service-manifest-run@1"
+
+	bspRunJSON = "bsp/run.json"
+)
+
+// bspFileFields bsp/run.json fields that reference a file in bsp/
+var bspFileFields = []string{"linux", "fit", "initrd", "fdt", "firmware", "modules"}
+
+func init() {
+	Register(SpecMultiPlatform, validateMultiPlatform)
+	Register(SpecServiceEmbed, validateObjects)
+	Register(SpecServiceSystem, validateObjects)
+}
+
+// isSha256 tells if s is a hex encoded sha256 sum
+func isSha256(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// validateObjects checks that all non json entries of state are object shas
+func validateObjects(state map[string]interface{}) []Violation {
+	violations := []Violation{}
+	for key, v := range state {
+		if strings.HasSuffix(key, ".json") || key == "#spec" {
+			continue
+		}
+		sha, ok := v.(string)
+		if !ok {
+			violations = append(violations, Violation{Key: key, Code: CodeInvalidType, Msg: "object entries must be sha256 strings"})
+			continue
+		}
+		if !isSha256(sha) {
+			violations = append(violations, Violation{Key: key, Code: CodeInvalidSha, Msg: "not a hex encoded sha256: " + sha})
+		}
+	}
+	return violations
+}
+
+// volumeName strips the handler prefix (e.g. dm:) of a volume reference
+func volumeName(ref string) string {
+	if strings.HasPrefix(ref, "dm:") {
+		return strings.TrimPrefix(ref, "dm:")
+	}
+	return ref
+}
+
+// checkFileRef reports a violation if the file dir/name referenced in field
+// of key is not part of the state
+func checkFileRef(state map[string]interface{}, key, field, dir, name string) []Violation {
+	if _, ok := state[dir+"/"+name]; ok {
+		return nil
+	}
+	return []Violation{{
+		Key:   key,
+		Field: field,
+		Code:  CodeMissingObject,
+		Msg:   fmt.Sprintf("references %s/%s which is not part of the state", dir, name),
+	}}
+}
+
+// validateBsp checks bsp/run.json and the bsp files it references
+func validateBsp(state map[string]interface{}) []Violation {
+	value, ok := state[bspRunJSON]
+	if !ok {
+		return []Violation{{Key: bspRunJSON, Code: CodeMissingKey, Msg: "bsp is missing"}}
+	}
+
+	run, ok := value.(map[string]interface{})
+	if !ok {
+		return []Violation{{Key: bspRunJSON, Code: CodeInvalidType, Msg: "must be a json object"}}
+	}
+
+	violations := []Violation{}
+	_, hasLinux := run["linux"]
+	_, hasFit := run["fit"]
+	if !hasLinux && !hasFit {
+		violations = append(violations, Violation{Key: bspRunJSON, Field: "linux", Code: CodeMissingKey, Msg: "one of linux or fit is required"})
+	}
+	// a fit image carries its initrd inside
+	if _, ok := run["initrd"]; !ok && !hasFit {
+		violations = append(violations, Violation{Key: bspRunJSON, Field: "initrd", Code: CodeMissingKey, Msg: "initrd is missing"})
+	}
+
+	for _, field := range bspFileFields {
+		v, ok := run[field]
+		if !ok {
+			continue
+		}
+		name, ok := v.(string)
+		if !ok {
+			violations = append(violations, Violation{Key: bspRunJSON, Field: field, Code: CodeInvalidType, Msg: "must be a file name string"})
+			continue
+		}
+		violations = append(violations, checkFileRef(state, bspRunJSON, field, "bsp", name)...)
+	}
+
+	if v, ok := run["addons"]; ok {
+		addons, ok := v.([]interface{})
+		if !ok {
+			return append(violations, Violation{Key: bspRunJSON, Field: "addons", Code: CodeInvalidType, Msg: "must be a list of file names"})
+		}
+		for i, a := range addons {
+			field := fmt.Sprintf("addons/%d", i)
+			name, ok := a.(string)
+			if !ok {
+				violations = append(violations, Violation{Key: bspRunJSON, Field: field, Code: CodeInvalidType, Msg: "must be a file name string"})
+				continue
+			}
+			violations = append(violations, checkFileRef(state, bspRunJSON, field, "bsp", name)...)
+		}
+	}
+
+	return violations
+}
+
+// validatePlatform checks the run.json of one platform and the files it references
+func validatePlatform(state map[string]interface{}, key string) []Violation {
+	dir := strings.TrimSuffix(key, "/run.json")
+
+	run, ok := state[key].(map[string]interface{})
+	if !ok {
+		return []Violation{{Key: key, Code: CodeInvalidType, Msg: "must be a json object"}}
+	}
+
+	violations := []Violation{}
+	stringField := func(field string, required bool) (string, bool) {
+		v, ok := run[field]
+		if !ok {
+			if required {
+				violations = append(violations, Violation{Key: key, Field: field, Code: CodeMissingKey, Msg: field + " is missing"})
+			}
+			return "", false
+		}
+		s, ok := v.(string)
+		if !ok {
+			violations = append(violations, Violation{Key: key, Field: field, Code: CodeInvalidType, Msg: field + " must be a string"})
+			return "", false
+		}
+		return s, true
+	}
+
+	if spec, ok := stringField("#spec", true); ok && spec != SpecServiceManifestRun {
+		violations = append(violations, Violation{Key: key, Field: "#spec", Code: CodeInvalidValue, Msg: "unsupported run.json #spec " + spec})
+	}
+	stringField("name", true)
+	if t, ok := stringField("type", true); ok && t != "lxc" {
+		violations = append(violations, Violation{Key: key, Field: "type", Code: CodeInvalidValue, Msg: "unsupported platform type " + t})
+	}
+	if root, ok := stringField("root-volume", true); ok {
+		violations = append(violations, checkFileRef(state, key, "root-volume", dir, volumeName(root))...)
+	}
+	if config, ok := stringField("config", false); ok {
+		violations = append(violations, checkFileRef(state, key, "config", dir, config)...)
+	}
+
+	if v, ok := run["volumes"]; ok {
+		volumes, ok := v.([]interface{})
+		if !ok {
+			return append(violations, Violation{Key: key, Field: "volumes", Code: CodeInvalidType, Msg: "must be a list of volume names"})
+		}
+		for i, vol := range volumes {
+			field := fmt.Sprintf("volumes/%d", i)
+			name, ok := vol.(string)
+			if !ok {
+				violations = append(violations, Violation{Key: key, Field: field, Code: CodeInvalidType, Msg: "must be a volume name string"})
+				continue
+			}
+			violations = append(violations, checkFileRef(state, key, field, dir, volumeName(name))...)
+		}
+	}
+
+	return violations
+}
+
+// validateMultiPlatform validates pantavisor-multi-platform@1 states
+func validateMultiPlatform(state map[string]interface{}) []Violation {
+	violations := validateObjects(state)
+	violations = append(violations, validateBsp(state)...)
+
+	for key := range state {
+		if !strings.HasSuffix(key, "/run.json") || key == bspRunJSON {
+			continue
+		}
+		// _sigs/, _config/ and friends are not platforms
+		if strings.HasPrefix(key, "_") || strings.Count(key, "/") != 1 {
+			continue
+		}
+		violations = append(violations, validatePlatform(state, key)...)
+	}
+
+	return violations
+}
@@ -0,0 +1,110 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+// Package statevalidator validates pantavisor state documents before they
+// get posted as trail steps. Validators are registered per #spec.
+package statevalidator
+
+import (
+	"sort"
+	"sync"
+)
+
+// Violation codes
+const (
+	CodeMissingKey    = "missing-key"
+	CodeInvalidType   = "invalid-type"
+	CodeInvalidValue  = "invalid-value"
+	CodeMissingObject = "missing-object"
+	CodeInvalidSha    = "invalid-sha"
+	CodeUnknownSpec   = "unknown-spec"
+)
+
+// Violation one problem found in a state document
+type Violation struct {
+	// Key state key the violation was found in; empty for the whole state
+	Key string `json:"key"`
+	// Field inside the json value of Key, if any
+	Field string `json:"field,omitempty"`
+	Code  string `json:"code"`
+	Msg   string `json:"msg"`
+}
+
+// Validator checks a state document of one #spec and returns all violations found
+type Validator func(state map[string]interface{}) []Violation
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]Validator{}
+)
+
+// Register makes validator responsible for states with the given #spec.
+// Registering a spec again replaces the previous validator.
+func Register(spec string, validator Validator) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[spec] = validator
+}
+
+// Specs returns the sorted list of specs with a registered validator
+func Specs() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	specs := make([]string, 0, len(registry))
+	for spec := range registry {
+		specs = append(specs, spec)
+	}
+	sort.Strings(specs)
+
+	return specs
+}
+
+// Validate checks state with the validator registered for its #spec.
+// An empty state is valid. The violations are sorted by key and field.
+func Validate(state map[string]interface{}) []Violation {
+	violations := []Violation{}
+	if len(state) == 0 {
+		return violations
+	}
+
+	specValue, ok := state["#spec"]
+	if !ok {
+		return append(violations, Violation{Key: "#spec", Code: CodeMissingKey, Msg: "#spec is missing"})
+	}
+
+	spec, ok := specValue.(string)
+	if !ok {
+		return append(violations, Violation{Key: "#spec", Code: CodeInvalidType, Msg: "#spec must be a string"})
+	}
+
+	registryMutex.RLock()
+	validator, ok := registry[spec]
+	registryMutex.RUnlock()
+	if !ok {
+		return append(violations, Violation{Key: "#spec", Code: CodeUnknownSpec, Msg: "no validator for #spec " + spec})
+	}
+
+	violations = append(violations, validator(state)...)
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].Key != violations[j].Key {
+			return violations[i].Key < violations[j].Key
+		}
+		return violations[i].Field < violations[j].Field
+	})
+
+	return violations
+}
@@ -0,0 +1,183 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package statevalidator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const sha = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func validState() map[string]interface{} {
+	state := map[string]interface{}{}
+	err := json.Unmarshal([]byte(`{
+		"#spec": "pantavisor-multi-platform@1",
+		"bsp/run.json": {
+			"initrd": "pantavisor",
+			"linux": "kernel.img",
+			"fdt": "board.dtb",
+			"addons": ["plymouth.cpio.xz4"]
+		},
+		"bsp/pantavisor": "`+sha+`",
+		"bsp/kernel.img": "`+sha+`",
+		"bsp/board.dtb": "`+sha+`",
+		"bsp/plymouth.cpio.xz4": "`+sha+`",
+		"awconnect/run.json": {
+			"#spec": "service-manifest-run@1",
+			"name": "awconnect",
+			"type": "lxc",
+			"config": "lxc.container.conf",
+			"root-volume": "dm:root.squashfs",
+			"volumes": ["lxc-overlay.squashfs"]
+		},
+		"awconnect/lxc.container.conf": "`+sha+`",
+		"awconnect/root.squashfs": "`+sha+`",
+		"awconnect/lxc-overlay.squashfs": "`+sha+`",
+		"_sigs/awconnect.json": {"protected": "x"}
+	}`), &state)
+	if err != nil {
+		panic(err)
+	}
+	return state
+}
+
+func codes(violations []Violation) []string {
+	result := []string{}
+	for _, v := range violations {
+		result = append(result, v.Key+"#"+v.Field+":"+v.Code)
+	}
+	return result
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(state map[string]interface{})
+		want   []string
+	}{
+		{
+			name:   "valid",
+			modify: func(state map[string]interface{}) {},
+			want:   []string{},
+		},
+		{
+			name: "missing spec",
+			modify: func(state map[string]interface{}) {
+				delete(state, "#spec")
+			},
+			want: []string{"#spec#:missing-key"},
+		},
+		{
+			name: "unknown spec",
+			modify: func(state map[string]interface{}) {
+				state["#spec"] = "pantavisor@0"
+			},
+			want: []string{"#spec#:unknown-spec"},
+		},
+		{
+			name: "missing bsp",
+			modify: func(state map[string]interface{}) {
+				delete(state, "bsp/run.json")
+			},
+			want: []string{"bsp/run.json#:missing-key"},
+		},
+		{
+			name: "bsp references missing file",
+			modify: func(state map[string]interface{}) {
+				delete(state, "bsp/kernel.img")
+			},
+			want: []string{"bsp/run.json#linux:missing-object"},
+		},
+		{
+			name: "fit bsp without initrd",
+			modify: func(state map[string]interface{}) {
+				state["bsp/run.json"] = map[string]interface{}{
+					"fit": "pantavisor.fit",
+				}
+				state["bsp/pantavisor.fit"] = sha
+			},
+			want: []string{},
+		},
+		{
+			name: "linux bsp without initrd",
+			modify: func(state map[string]interface{}) {
+				run := state["bsp/run.json"].(map[string]interface{})
+				delete(run, "initrd")
+			},
+			want: []string{"bsp/run.json#initrd:missing-key"},
+		},
+		{
+			name: "bad object sha",
+			modify: func(state map[string]interface{}) {
+				state["bsp/board.dtb"] = "abc"
+			},
+			want: []string{"bsp/board.dtb#:invalid-sha"},
+		},
+		{
+			name: "broken platform run.json",
+			modify: func(state map[string]interface{}) {
+				run := state["awconnect/run.json"].(map[string]interface{})
+				delete(run, "name")
+				run["type"] = "docker"
+				run["volumes"] = []interface{}{"missing.squashfs", 3}
+			},
+			want: []string{
+				"awconnect/run.json#name:missing-key",
+				"awconnect/run.json#type:invalid-value",
+				"awconnect/run.json#volumes/0:missing-object",
+				"awconnect/run.json#volumes/1:invalid-type",
+			},
+		},
+		{
+			name: "platform run.json not an object",
+			modify: func(state map[string]interface{}) {
+				state["awconnect/run.json"] = "x"
+			},
+			want: []string{"awconnect/run.json#:invalid-type"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := validState()
+			tt.modify(state)
+			got := codes(Validate(state))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateEmptyState(t *testing.T) {
+	if got := Validate(map[string]interface{}{}); len(got) != 0 {
+		t.Errorf("Validate() = %v, want no violations", got)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("test-spec@1", func(state map[string]interface{}) []Violation {
+		return []Violation{{Key: "x", Code: CodeInvalidValue, Msg: "always"}}
+	})
+
+	got := codes(Validate(map[string]interface{}{"#spec": "test-spec@1"}))
+	want := []string{"x#:invalid-value"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Validate() = %v, want %v", got, want)
+	}
+}
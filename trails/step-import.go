@@ -0,0 +1,291 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"gitlab.com/pantacor/pantahub-base/objects"
+	"gitlab.com/pantacor/pantahub-base/storagedriver"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// importStateMaxSize is the largest json state accepted in an import tarball
+const importStateMaxSize = 16 * 1024 * 1024
+
+// importTarOverhead is what an import tarball may have on top of the state and
+// the objects it imports: tar headers, padding and unreferenced entries
+const importTarOverhead = 16 * 1024 * 1024
+
+// errImportQuotaExceeded the objects of an import tarball do not fit into the
+// disk quota left to the owner
+var errImportQuotaExceeded = errors.New("Quota exceeded; delete some objects or request a quota bump from team@pantahub.com")
+
+// importedObject an object read from an import tarball
+type importedObject struct {
+	Sha  string
+	Size int64
+	File string
+}
+
+// importedTar the content of a pvr tarball as written by the exports
+// service: the state as "json" and the objects as "objects/<sha>"
+type importedTar struct {
+	State   map[string]interface{}
+	Objects map[string]importedObject
+
+	dir string
+}
+
+// Close removes the spooled objects of the tarball
+func (t *importedTar) Close() error {
+	if t.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(t.dir)
+}
+
+// readImportTar reads a tar or tar.gz pvr tarball from r. Objects the state
+// references get spooled into a temporary directory and verified against
+// their sha; the caller must Close the result to remove them. Objects that
+// would spool more than quota bytes fail with errImportQuotaExceeded.
+func readImportTar(r io.Reader, quota int64) (*importedTar, error) {
+	br := bufio.NewReader(r)
+	var reader io.Reader = br
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.New("invalid gzip stream: " + err.Error())
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	dir, err := os.MkdirTemp(os.TempDir(), "pantahub-import-")
+	if err != nil {
+		return nil, err
+	}
+	imported := &importedTar{
+		Objects: map[string]importedObject{},
+		dir:     dir,
+	}
+	var referenced map[string]bool // shas of the objects of the state
+	var spooled int64
+
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			imported.Close()
+			return nil, errors.New("invalid tarball: " + err.Error())
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean(header.Name), "./")
+		switch {
+		case name == "json":
+			if imported.State != nil {
+				imported.Close()
+				return nil, errors.New("invalid tarball: json included more than once")
+			}
+			if header.Size > importStateMaxSize {
+				imported.Close()
+				return nil, errors.New("invalid tarball: json too big")
+			}
+			state := map[string]interface{}{}
+			err = json.NewDecoder(io.LimitReader(tr, importStateMaxSize)).Decode(&state)
+			if err != nil {
+				imported.Close()
+				return nil, errors.New("invalid tarball: json is no valid state: " + err.Error())
+			}
+			imported.State = state
+			referenced = map[string]bool{}
+			for _, sha := range stateObjectShas(state) {
+				referenced[sha] = true
+			}
+		case strings.HasPrefix(name, "objects/"):
+			sha := strings.TrimPrefix(name, "objects/")
+			if _, err := utils.DecodeSha256HexString(sha); err != nil {
+				imported.Close()
+				return nil, errors.New("invalid tarball: object name is no sha256: " + name)
+			}
+			if referenced != nil && !referenced[sha] {
+				continue
+			}
+			if _, ok := imported.Objects[sha]; ok {
+				continue
+			}
+			if header.Size > quota-spooled {
+				imported.Close()
+				return nil, errImportQuotaExceeded
+			}
+			object, err := spoolImportObject(dir, sha, tr)
+			if err != nil {
+				imported.Close()
+				return nil, err
+			}
+			imported.Objects[sha] = *object
+			spooled += object.Size
+		}
+	}
+
+	if imported.State == nil {
+		imported.Close()
+		return nil, errors.New("invalid tarball: json is missing")
+	}
+
+	// objects before the json got spooled before knowing the state
+	for sha, object := range imported.Objects {
+		if !referenced[sha] {
+			os.Remove(object.File)
+			delete(imported.Objects, sha)
+		}
+	}
+
+	return imported, nil
+}
+
+// importQuota returns the disk quota owner has left for importing objects
+func (a *App) importQuota(pctx context.Context, owner string) (int64, error) {
+	quota, err := objects.Build(a.mongoClient).GetDiskQuota(pctx, owner)
+	if err != nil {
+		return 0, err
+	}
+	usage, err := objects.CalcUsageAfterPost(pctx, owner, a.mongoClient, "", 0)
+	if err != nil {
+		return 0, err
+	}
+	if usage.Total >= quota {
+		return 0, nil
+	}
+	return int64(quota - usage.Total), nil
+}
+
+// spoolImportObject copies the object sha from r to dir and verifies its sha
+func spoolImportObject(dir, sha string, r io.Reader) (*importedObject, error) {
+	file, err := os.Create(path.Join(dir, sha))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hasher), r)
+	if err != nil {
+		return nil, errors.New("error reading object " + sha + ": " + err.Error())
+	}
+
+	if hex.EncodeToString(hasher.Sum(nil)) != sha {
+		return nil, errors.New("invalid tarball: sha mismatch for object " + sha)
+	}
+
+	return &importedObject{
+		Sha:  sha,
+		Size: size,
+		File: file.Name(),
+	}, nil
+}
+
+// importStateObjects stores the objects of imported that the state uses
+// and owner has no backing file for yet. Objects get saved through the
+// objects service, so the disk quota of owner applies.
+func (a *App) importStateObjects(pctx context.Context, owner string, imported *importedTar) *utils.RError {
+	objectsApp := objects.Build(a.mongoClient)
+	sd := storagedriver.FromEnv()
+
+	for key, v := range imported.State {
+		if strings.HasSuffix(key, ".json") || key == "#spec" {
+			continue
+		}
+		sha, ok := v.(string)
+		if !ok {
+			continue
+		}
+		object, ok := imported.Objects[sha]
+		if !ok {
+			// resolved or linked when the step gets created
+			continue
+		}
+
+		existing, err := objectsApp.ResolveObjectWithBacking(pctx, owner, sha)
+		if err != nil && err != mongo.ErrNoDocuments && err != objects.ErrNoBackingFile {
+			return &utils.RError{Error: "Error resolving object " + sha + ": " + err.Error(), Code: http.StatusInternalServerError}
+		}
+		if existing != nil {
+			continue
+		}
+
+		newObject, err := objects.NewObject(sha, owner, key)
+		if err != nil {
+			return &utils.RError{Error: err.Error(), Code: http.StatusBadRequest}
+		}
+		newObject.SizeInt = object.Size
+
+		err = objectsApp.SaveObject(pctx, newObject, false)
+		if utils.IsUserError(err) {
+			return &utils.RError{Error: err.Error(), Msg: err.Error(), Code: http.StatusInternalServerError}
+		}
+		if err != nil {
+			return &utils.RError{Error: "Error saving object " + sha + ": " + err.Error(), Code: http.StatusInternalServerError}
+		}
+
+		filePath, err := utils.MakeLocalS3PathForName(newObject.StorageID)
+		if err != nil {
+			return &utils.RError{Error: "Error finding path for object " + sha + ": " + err.Error(), Code: http.StatusInternalServerError}
+		}
+
+		err = storeImportObject(pctx, sd, filePath, &object)
+		if err != nil {
+			return &utils.RError{Error: "Error storing object " + sha + ": " + err.Error(), Code: http.StatusInternalServerError}
+		}
+
+		// objects used under several keys get stored once
+		delete(imported.Objects, sha)
+	}
+
+	return nil
+}
+
+// storeImportObject puts the spooled object to the storage driver
+func storeImportObject(ctx context.Context, sd storagedriver.StorageDriver, filePath string, object *importedObject) error {
+	file, err := os.Open(object.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return sd.Put(ctx, filePath, file, object.Size)
+}
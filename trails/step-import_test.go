@@ -0,0 +1,117 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+)
+
+type tarEntry struct {
+	name    string
+	content []byte
+}
+
+func makeTar(t *testing.T, gz bool, entries ...tarEntry) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	var gw *gzip.Writer
+	tw := tar.NewWriter(buf)
+	if gz {
+		gw = gzip.NewWriter(buf)
+		tw = tar.NewWriter(gw)
+	}
+	for _, e := range entries {
+		err := tw.WriteHeader(&tar.Header{Name: e.name, Size: int64(len(e.content)), Mode: 0600})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	if gw != nil {
+		gw.Close()
+	}
+	return buf
+}
+
+func TestReadImportTar(t *testing.T) {
+	object := []byte("rootfs content")
+	sum := sha256.Sum256(object)
+	sha := hex.EncodeToString(sum[:])
+	state := []byte(`{"#spec": "pantavisor-multi-platform@1", "bsp/rootfs.squashfs": "` + sha + `"}`)
+	unused := []byte("not in the state")
+	unusedSum := sha256.Sum256(unused)
+	unusedSha := hex.EncodeToString(unusedSum[:])
+
+	for _, gz := range []bool{false, true} {
+		imported, err := readImportTar(makeTar(t, gz,
+			tarEntry{"json", state},
+			tarEntry{"objects/" + sha, object},
+			tarEntry{"objects/" + unusedSha, unused},
+		), 1024)
+		if err != nil {
+			t.Fatalf("gz=%v: unexpected error %s", gz, err.Error())
+		}
+		if imported.State["bsp/rootfs.squashfs"] != sha {
+			t.Errorf("gz=%v: unexpected state %v", gz, imported.State)
+		}
+		o, ok := imported.Objects[sha]
+		if !ok || o.Size != int64(len(object)) || len(imported.Objects) != 1 {
+			t.Errorf("gz=%v: unexpected objects %v", gz, imported.Objects)
+		}
+		imported.Close()
+		if _, err := os.Stat(o.File); !os.IsNotExist(err) {
+			t.Errorf("gz=%v: spooled object not removed", gz)
+		}
+	}
+}
+
+func TestReadImportTarErrors(t *testing.T) {
+	object := []byte("rootfs content")
+	sum := sha256.Sum256(object)
+	sha := hex.EncodeToString(sum[:])
+	otherSum := sha256.Sum256([]byte("other"))
+	state := []byte(`{"#spec": "pantavisor-multi-platform@1", "bsp/rootfs.squashfs": "` + sha + `"}`)
+	otherState := []byte(`{"#spec": "pantavisor-multi-platform@1", "bsp/rootfs.squashfs": "` + hex.EncodeToString(otherSum[:]) + `"}`)
+
+	tests := map[string]*bytes.Buffer{
+		"no json":      makeTar(t, false, tarEntry{"objects/" + sha, object}),
+		"bad json":     makeTar(t, false, tarEntry{"json", []byte("[1")}),
+		"double json":  makeTar(t, false, tarEntry{"json", state}, tarEntry{"json", state}),
+		"sha mismatch": makeTar(t, false, tarEntry{"json", otherState}, tarEntry{"objects/" + hex.EncodeToString(otherSum[:]), object}),
+		"over quota":   makeTar(t, false, tarEntry{"json", state}, tarEntry{"objects/" + sha, object}),
+		"bad sha":      makeTar(t, false, tarEntry{"json", state}, tarEntry{"objects/rootfs.squashfs", object}),
+		"no tar":       bytes.NewBufferString("not a tarball at all"),
+	}
+	for name, buf := range tests {
+		quota := int64(1024)
+		if name == "over quota" {
+			quota = int64(len(object)) - 1
+		}
+		if imported, err := readImportTar(buf, quota); err == nil {
+			imported.Close()
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
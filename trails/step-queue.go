@@ -0,0 +1,361 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// StepStatusWaiting status of a step of a sequential trail that waits for
+// the steps before it to finish. Devices only see the step once it becomes
+// NEW. (QUEUED is taken by devices reporting progress.)
+const StepStatusWaiting = "WAITING"
+
+// unfinishedSteps returns the steps of trailID that are not final yet,
+// ordered by rev
+func (a *App) unfinishedSteps(pctx context.Context, trailID primitive.ObjectID) ([]trailmodels.Step, error) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"rev": 1})
+	findOptions.SetProjection(bson.M{"state": 0})
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	cur, err := coll.Find(ctx, bson.M{
+		"trail-id":        trailID,
+		"progress.status": bson.M{"$nin": finalStepStatus},
+		"garbage":         bson.M{"$ne": true},
+	}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	steps := []trailmodels.Step{}
+	for cur.Next(ctx) {
+		step := trailmodels.Step{}
+		err := cur.Decode(&step)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, cur.Err()
+}
+
+// blockingStepOf returns the oldest of steps (ordered by rev) before rev that
+// is neither final nor waiting, or nil if there is none. The step at head,
+// the rev the queue released last, blocks until it is final even while it
+// still waits to become NEW.
+func blockingStepOf(steps []trailmodels.Step, head int, rev int) *trailmodels.Step {
+	for i := range steps {
+		step := &steps[i]
+		if step.Rev >= rev {
+			break
+		}
+		if isFinalStepStatus(step.StepProgress.Status) {
+			continue
+		}
+		if step.StepProgress.Status != StepStatusWaiting || step.Rev == head {
+			return step
+		}
+	}
+	return nil
+}
+
+// supersededSteps returns the waiting steps of steps before rev; rev
+// supersedes them
+func supersededSteps(steps []trailmodels.Step, rev int) []trailmodels.Step {
+	superseded := []trailmodels.Step{}
+	for _, step := range steps {
+		if step.Rev < rev && step.StepProgress.Status == StepStatusWaiting {
+			superseded = append(superseded, step)
+		}
+	}
+	return superseded
+}
+
+// stepQueuePlan what advancing the step queue of a trail does
+type stepQueuePlan struct {
+	// Waiting latest waiting step, if any
+	Waiting *trailmodels.Step
+	// Blocking step that keeps Waiting from being released
+	Blocking *trailmodels.Step
+	// Release step to make NEW; nil if the queue cannot advance
+	Release *trailmodels.Step
+	// Superseded waiting steps before Release that get cancelled
+	Superseded []trailmodels.Step
+}
+
+// planStepQueue decides how the step queue with the unfinished steps (ordered
+// by rev) and head advances. Only the latest waiting step gets released; with
+// force even if steps before it did not finish.
+func planStepQueue(steps []trailmodels.Step, head int, force bool) stepQueuePlan {
+	plan := stepQueuePlan{}
+	for i := len(steps) - 1; i >= 0; i-- {
+		if steps[i].StepProgress.Status == StepStatusWaiting {
+			plan.Waiting = &steps[i]
+			break
+		}
+	}
+	if plan.Waiting == nil {
+		return plan
+	}
+
+	plan.Blocking = blockingStepOf(steps, head, plan.Waiting.Rev)
+	if plan.Blocking != nil && !force {
+		return plan
+	}
+
+	plan.Release = plan.Waiting
+	plan.Superseded = supersededSteps(steps, plan.Release.Rev)
+	return plan
+}
+
+// blockingStep returns the step of trailID that keeps a step at rev waiting,
+// or nil if there is none (see blockingStepOf)
+func (a *App) blockingStep(pctx context.Context, trailID primitive.ObjectID, head int, rev int) (*trailmodels.Step, error) {
+	steps, err := a.unfinishedSteps(pctx, trailID)
+	if err != nil {
+		return nil, err
+	}
+	return blockingStepOf(steps, head, rev), nil
+}
+
+// waitingProgress returns the progress of a step waiting for rev
+func waitingProgress(rev int) trailmodels.StepProgress {
+	return trailmodels.StepProgress{
+		Status:    StepStatusWaiting,
+		StatusMsg: "Waiting for rev " + strconv.Itoa(rev) + " to finish",
+	}
+}
+
+// stepQueueHead returns the rev the step queue of trailID released last
+func (a *App) stepQueueHead(pctx context.Context, trailID primitive.ObjectID) (int, error) {
+	trail, err := a.FindTrail(pctx, trailID)
+	if err != nil {
+		return 0, err
+	}
+	return trail.QueueRev, nil
+}
+
+// claimStepQueueQuery matches the trail trailID while its step queue head is
+// still head. Trails that never released a step have no queue-rev yet.
+func claimStepQueueQuery(trailID primitive.ObjectID, head int) bson.M {
+	query := bson.M{"_id": trailID, "queue-rev": head}
+	if head == 0 {
+		query["queue-rev"] = bson.M{"$in": []interface{}{0, nil}}
+	}
+	return query
+}
+
+// claimStepQueue moves the head of the step queue of trailID from head to
+// rev. It fails if the head moved meanwhile, so only one of concurrent
+// postings and progress updates gets to release a step.
+func (a *App) claimStepQueue(pctx context.Context, trailID primitive.ObjectID, head int, rev int) (bool, error) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_trails")
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	updateResult, err := coll.UpdateOne(ctx, claimStepQueueQuery(trailID, head), bson.M{"$set": bson.M{"queue-rev": rev}})
+	if err != nil {
+		return false, err
+	}
+	return updateResult.MatchedCount > 0, nil
+}
+
+// cancelSupersededSteps cancels the steps of trailID before rev that are
+// still waiting; rev supersedes them
+func (a *App) cancelSupersededSteps(pctx context.Context, trailID primitive.ObjectID, rev int) error {
+	steps, err := a.unfinishedSteps(pctx, trailID)
+	if err != nil {
+		return err
+	}
+	return a.cancelWaitingSteps(pctx, supersededSteps(steps, rev), rev)
+}
+
+// cancelWaitingSteps cancels steps superseded by rev that are still waiting
+func (a *App) cancelWaitingSteps(pctx context.Context, steps []trailmodels.Step, rev int) error {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+
+	if len(steps) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(steps))
+	for _, step := range steps {
+		ids = append(ids, step.ID)
+	}
+
+	stepProgress := trailmodels.StepProgress{
+		Status:    "CANCEL",
+		Progress:  100,
+		StatusMsg: "Superseded by rev " + strconv.Itoa(rev),
+	}
+	now := time.Now()
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	_, err := coll.UpdateMany(ctx, bson.M{
+		"_id":             bson.M{"$in": ids},
+		"progress.status": StepStatusWaiting,
+		"garbage":         bson.M{"$ne": true},
+	}, bson.M{"$set": bson.M{
+		"progress":      stepProgress,
+		"progress-time": now,
+		"timemodified":  now,
+	}})
+	if err != nil {
+		return err
+	}
+
+	// record the cancel only for the steps that actually got cancelled
+	ctx, cancel = context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	cancelled, err := coll.Distinct(ctx, "_id", bson.M{
+		"_id":             bson.M{"$in": ids},
+		"progress.status": "CANCEL",
+		"progress-time":   now,
+	})
+	if err != nil {
+		return err
+	}
+	for _, stepID := range cancelled {
+		if id, ok := stepID.(string); ok {
+			a.recordStepProgress(pctx, id, stepProgress, now, ProgressSourceSystem)
+		}
+	}
+	return nil
+}
+
+// AdvanceStepQueue makes the waiting step of trailID NEW once no step before
+// it is in progress anymore. With force the waiting step gets released even
+// if steps before it did not finish (e.g. when sequential mode got turned off).
+func (a *App) AdvanceStepQueue(pctx context.Context, trailID primitive.ObjectID, force bool) error {
+	_, err := a.advanceStepQueue(pctx, trailID, force)
+	return err
+}
+
+// advanceStepQueue is AdvanceStepQueue returning the step that became NEW, if
+// any
+func (a *App) advanceStepQueue(pctx context.Context, trailID primitive.ObjectID, force bool) (*trailmodels.Step, error) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+
+	head, err := a.stepQueueHead(pctx, trailID)
+	if err != nil {
+		return nil, err
+	}
+
+	steps, err := a.unfinishedSteps(pctx, trailID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := planStepQueue(steps, head, force)
+	if plan.Release == nil {
+		return nil, nil
+	}
+	waiting := *plan.Release
+
+	claimed, err := a.claimStepQueue(pctx, trailID, head, waiting.Rev)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		// another posting or progress update released a step meanwhile;
+		// the queue advances again once that one is final
+		return nil, nil
+	}
+
+	err = a.cancelWaitingSteps(pctx, plan.Superseded, waiting.Rev)
+	if err != nil {
+		return nil, err
+	}
+
+	stepProgress := trailmodels.StepProgress{Status: "NEW"}
+	now := time.Now()
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	updateResult, err := coll.UpdateOne(ctx, bson.M{
+		"_id":             waiting.ID,
+		"progress.status": StepStatusWaiting,
+	}, bson.M{"$set": bson.M{
+		"progress":      stepProgress,
+		"progress-time": now,
+		"timemodified":  now,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if updateResult.ModifiedCount == 0 {
+		return nil, nil
+	}
+	a.recordStepProgress(pctx, waiting.ID, stepProgress, now, ProgressSourceSystem)
+	stepWatch.notify(trailID.Hex())
+
+	waiting.StepProgress = stepProgress
+	waiting.ProgressTime = now
+	return &waiting, nil
+}
+
+// advanceStepQueueOnStatus advances the step queue of trailID after a step
+// reached status. Errors are only logged as the progress update itself succeeded.
+func (a *App) advanceStepQueueOnStatus(pctx context.Context, trailID primitive.ObjectID, status string) {
+	if !isFinalStepStatus(status) {
+		return
+	}
+	err := a.AdvanceStepQueue(pctx, trailID, false)
+	if err != nil {
+		log.Printf("Error advancing step queue of trail %s: %s\n", trailID.Hex(), err.Error())
+	}
+}
+
+// StepQueue returns the queue state of trail; nil if the trail is not
+// sequential and has no waiting step
+func (a *App) StepQueue(pctx context.Context, trail *trailmodels.Trail) (*trailmodels.StepQueue, error) {
+	steps, err := a.unfinishedSteps(pctx, trail.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := planStepQueue(steps, trail.QueueRev, false)
+	if plan.Waiting == nil {
+		if !trail.Policy.Sequential {
+			return nil, nil
+		}
+		return &trailmodels.StepQueue{Sequential: trail.Policy.Sequential}, nil
+	}
+
+	queue := &trailmodels.StepQueue{
+		Sequential:   trail.Policy.Sequential,
+		WaitingRev:   &plan.Waiting.Rev,
+		WaitingSince: &plan.Waiting.StepTime,
+	}
+	if plan.Blocking != nil {
+		queue.BlockingRev = &plan.Blocking.Rev
+		queue.BlockingStatus = plan.Blocking.StepProgress.Status
+	}
+	return queue, nil
+}
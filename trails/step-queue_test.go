@@ -0,0 +1,187 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"reflect"
+	"testing"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func queueTestSteps(statuses ...string) []trailmodels.Step {
+	steps := []trailmodels.Step{}
+	for rev, status := range statuses {
+		if status == "" {
+			continue
+		}
+		steps = append(steps, trailmodels.Step{
+			Rev:          rev,
+			StepProgress: trailmodels.StepProgress{Status: status},
+		})
+	}
+	return steps
+}
+
+func queueTestRevs(steps []trailmodels.Step) []int {
+	revs := []int{}
+	for _, s := range steps {
+		revs = append(revs, s.Rev)
+	}
+	return revs
+}
+
+func TestBlockingStepOf(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []trailmodels.Step
+		head  int
+		rev   int
+		want  int
+	}{
+		{"nothing unfinished", queueTestSteps("DONE", "DONE"), 1, 2, -1},
+		{"in progress blocks", queueTestSteps("DONE", "INPROGRESS"), 1, 2, 1},
+		{"oldest unfinished blocks", queueTestSteps("NEW", "INPROGRESS"), 1, 2, 0},
+		{"final steps are skipped", queueTestSteps("ERROR", "CANCEL", "NEW"), 2, 3, 2},
+		{"waiting steps do not block", queueTestSteps("DONE", StepStatusWaiting), 0, 2, -1},
+		{"released head blocks while still waiting", queueTestSteps("DONE", StepStatusWaiting), 1, 2, 1},
+		{"steps from rev on are ignored", queueTestSteps("DONE", "DONE", "INPROGRESS"), 2, 2, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := -1
+			if blocking := blockingStepOf(tt.steps, tt.head, tt.rev); blocking != nil {
+				got = blocking.Rev
+			}
+			if got != tt.want {
+				t.Errorf("blockingStepOf() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSupersededSteps(t *testing.T) {
+	steps := queueTestSteps("DONE", StepStatusWaiting, "INPROGRESS", StepStatusWaiting, StepStatusWaiting)
+	if got := queueTestRevs(supersededSteps(steps, 4)); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Errorf("supersededSteps() = %v, want [1 3]", got)
+	}
+	if got := queueTestRevs(supersededSteps(steps, 1)); !reflect.DeepEqual(got, []int{}) {
+		t.Errorf("supersededSteps() = %v, want []", got)
+	}
+}
+
+func TestPlanStepQueue(t *testing.T) {
+	tests := []struct {
+		name           string
+		steps          []trailmodels.Step
+		head           int
+		force          bool
+		wantWaiting    int
+		wantBlocking   int
+		wantRelease    int
+		wantSuperseded []int
+	}{
+		{
+			name:           "nothing waiting",
+			steps:          queueTestSteps("DONE", "NEW"),
+			head:           1,
+			wantWaiting:    -1,
+			wantBlocking:   -1,
+			wantRelease:    -1,
+			wantSuperseded: []int{},
+		},
+		{
+			name:           "blocked by the step in progress",
+			steps:          queueTestSteps("DONE", "INPROGRESS", StepStatusWaiting),
+			head:           1,
+			wantWaiting:    2,
+			wantBlocking:   1,
+			wantRelease:    -1,
+			wantSuperseded: []int{},
+		},
+		{
+			name:           "releases the latest and supersedes the others",
+			steps:          queueTestSteps("DONE", "DONE", StepStatusWaiting, StepStatusWaiting),
+			head:           1,
+			wantWaiting:    3,
+			wantBlocking:   -1,
+			wantRelease:    3,
+			wantSuperseded: []int{2},
+		},
+		{
+			name:           "released head not picked up yet blocks",
+			steps:          queueTestSteps("DONE", StepStatusWaiting, StepStatusWaiting),
+			head:           1,
+			wantWaiting:    2,
+			wantBlocking:   1,
+			wantRelease:    -1,
+			wantSuperseded: []int{},
+		},
+		{
+			name:           "force releases despite the blocking step",
+			steps:          queueTestSteps("DONE", "INPROGRESS", StepStatusWaiting, StepStatusWaiting),
+			head:           1,
+			force:          true,
+			wantWaiting:    3,
+			wantBlocking:   1,
+			wantRelease:    3,
+			wantSuperseded: []int{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := planStepQueue(tt.steps, tt.head, tt.force)
+			rev := func(s *trailmodels.Step) int {
+				if s == nil {
+					return -1
+				}
+				return s.Rev
+			}
+			if got := rev(plan.Waiting); got != tt.wantWaiting {
+				t.Errorf("Waiting = %d, want %d", got, tt.wantWaiting)
+			}
+			if got := rev(plan.Blocking); got != tt.wantBlocking {
+				t.Errorf("Blocking = %d, want %d", got, tt.wantBlocking)
+			}
+			if got := rev(plan.Release); got != tt.wantRelease {
+				t.Errorf("Release = %d, want %d", got, tt.wantRelease)
+			}
+			if got := queueTestRevs(plan.Superseded); !reflect.DeepEqual(got, tt.wantSuperseded) {
+				t.Errorf("Superseded = %v, want %v", got, tt.wantSuperseded)
+			}
+		})
+	}
+}
+
+func TestClaimStepQueueQuery(t *testing.T) {
+	trailID := primitive.NewObjectID()
+
+	got := claimStepQueueQuery(trailID, 3)
+	want := bson.M{"_id": trailID, "queue-rev": 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("claimStepQueueQuery() = %v, want %v", got, want)
+	}
+
+	// trails that never released a step have no queue-rev
+	got = claimStepQueueQuery(trailID, 0)
+	want = bson.M{"_id": trailID, "queue-rev": bson.M{"$in": []interface{}{0, nil}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("claimStepQueueQuery() = %v, want %v", got, want)
+	}
+}
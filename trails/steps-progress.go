@@ -0,0 +1,181 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// finalStepStatus statuses of steps that do not progress anymore
+var finalStepStatus = []string{"DONE", "ERROR", "WONTGO", "CANCEL"}
+
+// stepsProgressPipeline aggregates the download progress of the latest step
+// of every trail matched by match. Download start and current times are
+// reported by devices in seconds.
+func stepsProgressPipeline(match bson.M, slowest int) mongo.Pipeline {
+	total := "$progress.downloads.total"
+	notProgressing := append([]string{"NEW", StepStatusWaiting}, finalStepStatus...)
+
+	return mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		// project before sorting so states do not count against the sort
+		// memory limit
+		{{Key: "$project", Value: bson.M{
+			"trail-id":   1,
+			"device":     1,
+			"rev":        1,
+			"status":     "$progress.status",
+			"total":      bson.M{"$ifNull": []interface{}{total + ".total_size", 0}},
+			"downloaded": bson.M{"$ifNull": []interface{}{total + ".total_downloaded", 0}},
+			"elapsed": bson.M{"$subtract": []interface{}{
+				bson.M{"$ifNull": []interface{}{total + ".currentb_time", 0}},
+				bson.M{"$ifNull": []interface{}{total + ".start_time", 0}},
+			}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "trail-id", Value: 1}, {Key: "rev", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":  "$trail-id",
+			"step": bson.M{"$first": "$$ROOT"},
+		}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$step"}}},
+		{{Key: "$addFields", Value: bson.M{
+			"active": bson.M{"$and": []interface{}{
+				bson.M{"$not": []interface{}{bson.M{"$in": []interface{}{"$status", notProgressing}}}},
+				bson.M{"$lt": []interface{}{"$downloaded", "$total"}},
+			}},
+			"remaining": bson.M{"$cond": []interface{}{
+				bson.M{"$in": []interface{}{"$status", finalStepStatus}},
+				0,
+				bson.M{"$max": []interface{}{0, bson.M{"$subtract": []interface{}{"$total", "$downloaded"}}}},
+			}},
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"throughput": bson.M{"$cond": []interface{}{
+				bson.M{"$and": []interface{}{"$active", bson.M{"$gt": []interface{}{"$elapsed", 0}}}},
+				bson.M{"$divide": []interface{}{"$downloaded", "$elapsed"}},
+				0,
+			}},
+		}}},
+		{{Key: "$facet", Value: bson.M{
+			"status": []bson.M{
+				{"$group": bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}},
+			},
+			"totals": []bson.M{
+				{"$group": bson.M{
+					"_id":        nil,
+					"steps":      bson.M{"$sum": 1},
+					"total":      bson.M{"$sum": "$total"},
+					"downloaded": bson.M{"$sum": "$downloaded"},
+					"remaining":  bson.M{"$sum": "$remaining"},
+					"throughput": bson.M{"$sum": "$throughput"},
+				}},
+			},
+			"slowest": []bson.M{
+				{"$match": bson.M{"active": true}},
+				{"$sort": bson.D{{Key: "throughput", Value: 1}, {Key: "trail-id", Value: 1}}},
+				{"$limit": slowest},
+				{"$project": bson.M{
+					"_id":              0,
+					"trail-id":         1,
+					"device":           1,
+					"rev":              1,
+					"status":           1,
+					"total-bytes":      "$total",
+					"downloaded-bytes": "$downloaded",
+					"throughput":       1,
+				}},
+			},
+		}}},
+	}
+}
+
+// finishStepsProgress computes the ETA of p from its remaining bytes and throughput
+func finishStepsProgress(p *trailmodels.StepsProgress) {
+	switch {
+	case p.RemainingBytes <= 0:
+		p.ETA = 0
+	case p.Throughput <= 0:
+		p.ETA = -1
+	default:
+		p.ETA = int64(math.Ceil(float64(p.RemainingBytes) / p.Throughput))
+	}
+}
+
+// AggregateStepsProgress aggregates the update progress of the latest step of
+// every trail matched by match, listing up to slowest active downloads
+func (a *App) AggregateStepsProgress(pctx context.Context, match bson.M, slowest int) (*trailmodels.StepsProgress, error) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+
+	ctx, cancel := context.WithTimeout(pctx, 30*time.Second)
+	defer cancel()
+	cur, err := coll.Aggregate(ctx, stepsProgressPipeline(match, slowest), options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	result := []struct {
+		Status []struct {
+			Status string `bson:"_id"`
+			Count  int    `bson:"count"`
+		} `bson:"status"`
+		Totals []struct {
+			Steps      int     `bson:"steps"`
+			Total      int64   `bson:"total"`
+			Downloaded int64   `bson:"downloaded"`
+			Remaining  int64   `bson:"remaining"`
+			Throughput float64 `bson:"throughput"`
+		} `bson:"totals"`
+		Slowest []trailmodels.DeviceProgress `bson:"slowest"`
+	}{}
+	err = cur.All(ctx, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &trailmodels.StepsProgress{
+		Status:  map[string]int{},
+		Slowest: []trailmodels.DeviceProgress{},
+	}
+	if len(result) > 0 {
+		for _, s := range result[0].Status {
+			progress.Status[s.Status] = s.Count
+		}
+		if len(result[0].Totals) > 0 {
+			totals := result[0].Totals[0]
+			progress.Steps = totals.Steps
+			progress.TotalBytes = totals.Total
+			progress.DownloadedBytes = totals.Downloaded
+			progress.RemainingBytes = totals.Remaining
+			progress.Throughput = totals.Throughput
+		}
+		if result[0].Slowest != nil {
+			progress.Slowest = result[0].Slowest
+		}
+	}
+	finishStepsProgress(progress)
+
+	return progress, nil
+}
@@ -0,0 +1,44 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"testing"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+)
+
+func TestFinishStepsProgress(t *testing.T) {
+	tests := []struct {
+		name string
+		in   trailmodels.StepsProgress
+		eta  int64
+	}{
+		{"done", trailmodels.StepsProgress{RemainingBytes: 0, Throughput: 0}, 0},
+		{"stalled", trailmodels.StepsProgress{RemainingBytes: 100, Throughput: 0}, -1},
+		{"downloading", trailmodels.StepsProgress{RemainingBytes: 1001, Throughput: 100}, 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.in
+			finishStepsProgress(&p)
+			if p.ETA != tt.eta {
+				t.Errorf("finishStepsProgress() ETA = %d, want %d", p.ETA, tt.eta)
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/devices"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// summaryFlushInterval how often changed devices get their summary updated
+	summaryFlushInterval = 2 * time.Second
+
+	// summaryPollInterval how often collections get polled for changes when
+	// no change stream is available (e.g. mongo is not a replica set)
+	summaryPollInterval = 30 * time.Second
+)
+
+// summarySource a collection whose changes affect the device summaries
+type summarySource struct {
+	collection string
+	// idField field holding the device (or step) id for polling
+	idField string
+	// timeField field holding the last change time for polling
+	timeField string
+}
+
+var summarySources = []summarySource{
+	{collection: "pantahub_devices", idField: "_id", timeField: "timemodified"},
+	{collection: "pantahub_trails", idField: "_id", timeField: "last-touched"},
+	{collection: "pantahub_steps", idField: "trail-id", timeField: "timemodified"},
+}
+
+// summaryDeviceID returns the device id for the id of a device, trail or step
+func summaryDeviceID(id interface{}) (primitive.ObjectID, bool) {
+	switch v := id.(type) {
+	case primitive.ObjectID:
+		return v, true
+	case string:
+		oid, err := primitive.ObjectIDFromHex(trailIDFromStepID(v))
+		return oid, err == nil
+	}
+	return primitive.NilObjectID, false
+}
+
+// summaryUserMetaString returns the string value of key in user-meta
+func summaryUserMetaString(meta map[string]interface{}, key string) string {
+	v, _ := meta[key].(string)
+	return v
+}
+
+// newTrailSummary builds the summary of device from its trail, its latest
+// step and the step it last reported progress for; trail and the steps can
+// be nil
+func newTrailSummary(
+	device *devices.Device,
+	trail *trailmodels.Trail,
+	latest *trailmodels.Step,
+	progressed *trailmodels.Step,
+) *trailmodels.TrailSummary {
+	userMeta := utils.BsonUnquoteMap(&device.UserMeta)
+	summary := &trailmodels.TrailSummary{
+		DeviceID:      device.ID.Hex(),
+		Device:        device.Prn,
+		DeviceNick:    device.Nick,
+		Owner:         device.Owner,
+		IsPublic:      device.IsPublic,
+		Garbage:       device.Garbage,
+		Timestamp:     device.TimeModified,
+		FleetGroup:    summaryUserMetaString(userMeta, "fleet-group"),
+		FleetModel:    summaryUserMetaString(userMeta, "fleet-model"),
+		FleetLocation: summaryUserMetaString(userMeta, "fleet-location"),
+		FleetRev:      summaryUserMetaString(userMeta, "fleet-rev"),
+	}
+
+	if trail != nil {
+		summary.TrailTouchedTime = trail.LastTouched
+		if trail.LastTouched.After(summary.Timestamp) {
+			summary.Timestamp = trail.LastTouched
+		}
+	}
+
+	if latest == nil {
+		return summary
+	}
+	summary.Rev = latest.Rev
+	summary.StepTime = latest.StepTime
+
+	if progressed == nil {
+		progressed = latest
+	}
+	summary.ProgressRev = progressed.Rev
+	summary.Progress = progressed.StepProgress.Progress
+	summary.Status = progressed.StepProgress.Status
+	summary.StatusMsg = progressed.StepProgress.StatusMsg
+	summary.StateSha = progressed.StateSha
+	summary.ProgressTime = progressed.ProgressTime
+	if progressed.ProgressTime.After(summary.Timestamp) {
+		summary.Timestamp = progressed.ProgressTime
+	}
+
+	return summary
+}
+
+// findSummaryStep returns the first step of trailID matching query in sort
+// order, or nil if there is none
+func (a *App) findSummaryStep(pctx context.Context, trailID primitive.ObjectID, query bson.M, sort bson.M) (*trailmodels.Step, error) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+
+	query["trail-id"] = trailID
+	query["garbage"] = bson.M{"$ne": true}
+
+	findOneOptions := options.FindOne()
+	findOneOptions.SetSort(sort)
+	findOneOptions.SetProjection(bson.M{"state": 0, "meta": 0})
+
+	step := &trailmodels.Step{}
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	err := coll.FindOne(ctx, query, findOneOptions).Decode(step)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return step, nil
+}
+
+// UpdateTrailSummary rebuilds the summary of the device deviceID from the
+// device, its trail and its steps. The summary gets removed if the device
+// does not exist anymore.
+func (a *App) UpdateTrailSummary(pctx context.Context, deviceID primitive.ObjectID) error {
+	summaryCol := a.mongoClient.Database(trailmodels.SummaryDatabase).Collection(trailmodels.SummaryCollection)
+
+	device := devices.Device{}
+	err := devices.Build(a.mongoClient).FindDeviceByID(pctx, deviceID, &device)
+	if err == mongo.ErrNoDocuments {
+		ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+		defer cancel()
+		_, err = summaryCol.DeleteOne(ctx, bson.M{"deviceid": deviceID.Hex()})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	trail, err := a.FindTrail(pctx, deviceID)
+	if err == mongo.ErrNoDocuments {
+		trail = nil
+	} else if err != nil {
+		return err
+	}
+
+	latest, err := a.findSummaryStep(pctx, deviceID, bson.M{}, bson.M{"rev": -1})
+	if err != nil {
+		return err
+	}
+	progressed, err := a.findSummaryStep(pctx, deviceID, bson.M{
+		"progress.status": bson.M{"$nin": []string{"NEW", StepStatusWaiting}},
+	}, bson.M{"progress-time": -1})
+	if err != nil {
+		return err
+	}
+
+	summary := newTrailSummary(&device, trail, latest, progressed)
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	_, err = summaryCol.ReplaceOne(ctx, bson.M{"deviceid": summary.DeviceID}, summary,
+		options.Replace().SetUpsert(true))
+	return err
+}
+
+// RebuildTrailSummaries rebuilds the summaries of all devices and removes the
+// summaries of devices that do not exist anymore
+func (a *App) RebuildTrailSummaries(pctx context.Context) (*trailmodels.SummaryRebuildResult, error) {
+	collDevices := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_devices")
+	summaryCol := a.mongoClient.Database(trailmodels.SummaryDatabase).Collection(trailmodels.SummaryCollection)
+
+	result := &trailmodels.SummaryRebuildResult{}
+	seen := map[string]bool{}
+
+	findOptions := options.Find()
+	findOptions.SetProjection(bson.M{"_id": 1})
+	findOptions.SetNoCursorTimeout(true)
+	cur, err := collDevices.Find(pctx, bson.M{}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(pctx)
+
+	for cur.Next(pctx) {
+		device := struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}{}
+		err := cur.Decode(&device)
+		if err != nil {
+			return nil, err
+		}
+		err = a.UpdateTrailSummary(pctx, device.ID)
+		if err != nil {
+			return nil, err
+		}
+		seen[device.ID.Hex()] = true
+		result.Summaries++
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(pctx, 30*time.Second)
+	defer cancel()
+	deviceIDs, err := summaryCol.Distinct(ctx, "deviceid", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range deviceIDs {
+		deviceID, ok := id.(string)
+		if !ok || seen[deviceID] {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+		_, err := summaryCol.DeleteMany(ctx, bson.M{"deviceid": deviceID})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		result.Removed++
+	}
+
+	return result, nil
+}
+
+// summaryQueue collects the devices whose summary needs an update
+type summaryQueue struct {
+	mu      sync.Mutex
+	devices map[primitive.ObjectID]struct{}
+}
+
+func (q *summaryQueue) add(deviceID primitive.ObjectID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.devices[deviceID] = struct{}{}
+}
+
+// take returns the queued devices and empties the queue
+func (q *summaryQueue) take() map[primitive.ObjectID]struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	devices := q.devices
+	q.devices = map[primitive.ObjectID]struct{}{}
+	return devices
+}
+
+// materializeSummaries keeps the device summaries up to date with the
+// changes of devices, trails and steps made by any replica
+func (a *App) materializeSummaries(ctx context.Context) {
+	queue := &summaryQueue{devices: map[primitive.ObjectID]struct{}{}}
+
+	for _, source := range summarySources {
+		go a.followSummarySource(ctx, source, queue)
+	}
+
+	ticker := time.NewTicker(summaryFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for deviceID := range queue.take() {
+			err := a.UpdateTrailSummary(ctx, deviceID)
+			if err != nil {
+				log.Printf("Error updating summary of device %s: %s\n", deviceID.Hex(), err.Error())
+				queue.add(deviceID)
+			}
+		}
+	}
+}
+
+// followSummarySource queues the devices changed in the collection of source
+// following its change stream. Without change streams the collection gets
+// polled for documents changed since the last poll.
+func (a *App) followSummarySource(ctx context.Context, source summarySource, queue *summaryQueue) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection(source.collection)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"operationType": bson.M{"$in": []string{"insert", "update", "replace", "delete"}},
+		}}},
+		{{Key: "$project", Value: bson.M{"documentKey": 1}}},
+	}
+
+	since := time.Now()
+	logged := false
+	for {
+		stream, err := coll.Watch(ctx, pipeline, options.ChangeStream())
+		if err != nil && !logged {
+			log.Printf("INFO: %s change stream not available, summaries get polled: %s\n", source.collection, err.Error())
+			logged = true
+		}
+		if err == nil {
+			for stream.Next(ctx) {
+				event := struct {
+					DocumentKey struct {
+						ID interface{} `bson:"_id"`
+					} `bson:"documentKey"`
+				}{}
+				if stream.Decode(&event) != nil {
+					continue
+				}
+				if deviceID, ok := summaryDeviceID(event.DocumentKey.ID); ok {
+					queue.add(deviceID)
+				}
+			}
+			if stream.Err() != nil {
+				log.Printf("WARNING: %s change stream closed, summaries fall back to polling: %s\n", source.collection, stream.Err().Error())
+			}
+			stream.Close(context.Background())
+			since = time.Now().Add(-summaryPollInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(summaryPollInterval):
+		}
+
+		now := time.Now()
+		pollCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		ids, err := coll.Distinct(pollCtx, source.idField, bson.M{source.timeField: bson.M{"$gte": since}})
+		cancel()
+		if err != nil {
+			log.Printf("Error polling %s for summary changes: %s\n", source.collection, err.Error())
+			continue
+		}
+		for _, id := range ids {
+			if deviceID, ok := summaryDeviceID(id); ok {
+				queue.add(deviceID)
+			}
+		}
+		since = now
+	}
+}
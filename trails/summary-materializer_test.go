@@ -0,0 +1,79 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/devices"
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSummaryDeviceID(t *testing.T) {
+	id := primitive.NewObjectID()
+
+	for _, v := range []interface{}{id, id.Hex() + "-12"} {
+		got, ok := summaryDeviceID(v)
+		if !ok || got != id {
+			t.Errorf("summaryDeviceID(%v) = %v, %v; want %v", v, got, ok, id)
+		}
+	}
+	if _, ok := summaryDeviceID("nohex-1"); ok {
+		t.Errorf("summaryDeviceID of invalid step id should fail")
+	}
+}
+
+func TestNewTrailSummary(t *testing.T) {
+	modified := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	touched := modified.Add(time.Hour)
+	progressed := modified.Add(2 * time.Hour)
+
+	device := &devices.Device{
+		ID:           primitive.NewObjectID(),
+		Prn:          "prn:::devices:/1",
+		Nick:         "happy_device",
+		Owner:        "prn:pantahub.com:auth:/user1",
+		TimeModified: modified,
+		UserMeta:     map[string]interface{}{"fleet-group": "lab"},
+	}
+	trail := &trailmodels.Trail{LastTouched: touched}
+
+	summary := newTrailSummary(device, trail, nil, nil)
+	if summary.DeviceID != device.ID.Hex() || summary.FleetGroup != "lab" || !summary.Timestamp.Equal(touched) {
+		t.Errorf("unexpected summary without steps %+v", summary)
+	}
+
+	latest := &trailmodels.Step{Rev: 5, StepProgress: trailmodels.StepProgress{Status: "NEW"}}
+	summary = newTrailSummary(device, trail, latest, nil)
+	if summary.Rev != 5 || summary.ProgressRev != 5 || summary.Status != "NEW" {
+		t.Errorf("unexpected summary with new step %+v", summary)
+	}
+
+	done := &trailmodels.Step{
+		Rev:          4,
+		StateSha:     "abc",
+		ProgressTime: progressed,
+		StepProgress: trailmodels.StepProgress{Status: "DONE", Progress: 100},
+	}
+	summary = newTrailSummary(device, trail, latest, done)
+	if summary.Rev != 5 || summary.ProgressRev != 4 || summary.Status != "DONE" ||
+		summary.StateSha != "abc" || !summary.Timestamp.Equal(progressed) {
+		t.Errorf("unexpected summary with progressed step %+v", summary)
+	}
+}
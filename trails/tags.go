@@ -0,0 +1,77 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/trails/trailservices"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// tagNameRegexp tag names must not look like revs and must be usable as bson keys
+var tagNameRegexp = regexp.MustCompile("^[A-Za-z][A-Za-z0-9_-]{0,63}$")
+
+// ValidTagName tells if name can be used as tag name; "latest" is reserved
+func ValidTagName(name string) bool {
+	return name != "latest" && tagNameRegexp.MatchString(name)
+}
+
+// resolveRev returns the rev number a tag of trailID points to; rev numbers
+// are returned as they are
+func (a *App) resolveRev(ctx context.Context, trailID string, rev string) (string, *utils.RError) {
+	trailService := trailservices.CreateService(a.mongoClient, utils.MongoDb)
+	return trailService.ResolveRev(ctx, trailID, rev)
+}
+
+// StateFromTag returns the state of the step the tag of trail points to
+func (a *App) StateFromTag(pctx context.Context, trail *trailmodels.Trail, tag string) (map[string]interface{}, *utils.RError) {
+	rev, ok := trail.Tags[tag]
+	if !ok {
+		return nil, &utils.RError{Error: "Tag " + tag + " not found", Code: http.StatusNotFound}
+	}
+
+	collSteps := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	if collSteps == nil {
+		return nil, &utils.RError{Error: "Error with Database connectivity", Code: http.StatusInternalServerError}
+	}
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+
+	step := trailmodels.Step{}
+	err := collSteps.FindOne(ctx, bson.M{
+		"_id":     trail.ID.Hex() + "-" + strconv.Itoa(rev),
+		"garbage": bson.M{"$ne": true},
+	}).Decode(&step)
+	if err != nil {
+		return nil, &utils.RError{Error: "Step of tag " + tag + " not found: " + err.Error(), Code: http.StatusNotFound}
+	}
+
+	rerr := a.resolveRelease(pctx, &step)
+	if rerr != nil {
+		return nil, rerr
+	}
+
+	return utils.BsonUnquoteMap(&step.State), nil
+}
@@ -0,0 +1,41 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import "testing"
+
+func TestValidTagName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"stable", true},
+		{"customer-approved", true},
+		{"release_012", true},
+		{"", false},
+		{"12", false},
+		{"1stable", false},
+		{"latest", false},
+		{"with.dot", false},
+		{"with space", false},
+	}
+	for _, tt := range tests {
+		if got := ValidTagName(tt.name); got != tt.want {
+			t.Errorf("ValidTagName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
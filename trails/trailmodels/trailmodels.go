@@ -44,6 +44,78 @@ type Trail struct {
 	LastTouched  time.Time              `json:"last-touched" bson:"last-touched"`
 	FactoryState map[string]interface{} `json:"factory-state" bson:"factory-state"`
 	UsedObjects  []string               `bson:"used_objects" json:"used_objects"`
+	Policy       TrailPolicy            `json:"policy" bson:"policy"`
+	LastRollback *Rollback              `json:"last-rollback,omitempty" bson:"last-rollback,omitempty"`
+	// Tags named, movable references to revs of the trail (e.g. stable, golden)
+	Tags map[string]int `json:"tags,omitempty" bson:"tags,omitempty"`
+	// QueueRev rev of the step the step queue released last
+	QueueRev int `json:"-" bson:"queue-rev,omitempty"`
+}
+
+// TrailPolicy per trail settings for the server side handling of steps
+type TrailPolicy struct {
+	// AutoRollback re-post the last DONE revision when a step goes ERROR or WONTGO
+	AutoRollback bool `json:"auto-rollback" bson:"auto-rollback"`
+	// RequireSigned reject states without valid signatures covering all entries;
+	// if not set the signing policy of the owner applies
+	RequireSigned *bool `json:"require-signed,omitempty" bson:"require-signed,omitempty"`
+	// Retention which old steps expire; if not set the retention defaults of
+	// the subscription of the owner apply
+	Retention *RetentionPolicy `json:"retention,omitempty" bson:"retention,omitempty"`
+	// Sequential hold back new steps as WAITING until the steps before them
+	// are final; a newer step supersedes (cancels) older waiting ones
+	Sequential bool `json:"sequential" bson:"sequential"`
+}
+
+// StepQueue state of the step queue of a sequential trail
+type StepQueue struct {
+	Sequential     bool       `json:"sequential"`
+	WaitingRev     *int       `json:"waiting-revision,omitempty"`
+	WaitingSince   *time.Time `json:"waiting-since,omitempty"`
+	BlockingRev    *int       `json:"blocking-revision,omitempty"`
+	BlockingStatus string     `json:"blocking-status,omitempty"`
+}
+
+// RetentionPolicy decides which steps of a trail expire. The first and the
+// latest step of a trail as well as steps not in a final state never expire.
+type RetentionPolicy struct {
+	// KeepRevs number of latest revisions to keep; 0 keeps all
+	KeepRevs int `json:"keep-revs" bson:"keep-revs"`
+	// KeepTagged keep steps a tag points to
+	KeepTagged bool `json:"keep-tagged" bson:"keep-tagged"`
+	// KeepCurrent keep the latest DONE step, the one the device runs
+	KeepCurrent bool `json:"keep-current" bson:"keep-current"`
+	// DropNotAppliedAfterDays expire WONTGO and CANCEL steps older than this
+	// many days even if within the latest revisions; 0 never
+	DropNotAppliedAfterDays int `json:"drop-not-applied-after-days" bson:"drop-not-applied-after-days"`
+}
+
+// RetentionResult steps of a trail that expired in a retention run
+type RetentionResult struct {
+	TrailID     string `json:"trail-id"`
+	Owner       string `json:"owner"`
+	ExpiredRevs []int  `json:"expired-revs"`
+	Error       string `json:"error,omitempty"`
+}
+
+// SummaryRebuildResult result of rebuilding the device summaries
+type SummaryRebuildResult struct {
+	Summaries int `json:"summaries"`
+	Removed   int `json:"removed"`
+}
+
+// TagRev payload to point a tag to a rev
+type TagRev struct {
+	Rev int `json:"rev"`
+}
+
+// Rollback record of a step posted by the server to roll back a failed step
+type Rollback struct {
+	FailedRev    int       `json:"failed-rev" bson:"failed-rev"`
+	FailedStatus string    `json:"failed-status" bson:"failed-status"`
+	ToRev        int       `json:"to-rev" bson:"to-rev"`
+	Rev          int       `json:"rev" bson:"rev"`
+	Time         time.Time `json:"time" bson:"time"`
 }
 
 // Step wanted can be added by the device owner or delegate.
@@ -59,16 +131,130 @@ type Step struct {
 	CommitMsg           string                 `json:"commit-msg" bson:"commit-msg"`
 	State               map[string]interface{} `json:"state"` // json blurb
 	StateSha            string                 `json:"state-sha" bson:"statesha"`
+	Release             string                 `json:"release,omitempty" bson:"release,omitempty"` // sha of the release the state is taken from
 	StepProgress        StepProgress           `json:"progress" bson:"progress"`
 	StepTime            time.Time              `json:"step-time" bson:"step-time"`
 	ProgressTime        time.Time              `json:"progress-time" bson:"progress-time"`
 	Meta                map[string]interface{} `json:"meta"` // json blurb
 	UsedObjects         []string               `bson:"used_objects" json:"used_objects"`
+	StateKeys           []string               `json:"-" bson:"state-keys,omitempty"` // keys of the state for searching
 	IsPublic            bool                   `json:"-" bson:"ispublic"`
 	MarkPublicProcessed bool                   `json:"mark_public_processed" bson:"mark_public_processed"`
 	Garbage             bool                   `json:"garbage" bson:"garbage"`
 	TimeCreated         time.Time              `json:"time-created" bson:"timecreated"`
 	TimeModified        time.Time              `json:"time-modified" bson:"timemodified"`
+	Signatures          *StepSignatures        `json:"signatures,omitempty" bson:"signatures,omitempty"`
+}
+
+// ComposePart fragment of a step of another trail to compose a new step from
+type ComposePart struct {
+	// Trail id of the trail to take the fragment from
+	Trail string `json:"trail"`
+	// Rev rev number or tag of the step; defaults to latest
+	Rev string `json:"rev"`
+	// Frags comma separated fragments as used by pvr, e.g. "bsp" or "nginx"
+	Frags string `json:"frags"`
+}
+
+// ComposeStep payload to compose a new step from fragments of other steps
+type ComposeStep struct {
+	// Rev of the new step; defaults to the next rev of the trail
+	Rev       int    `json:"rev"`
+	CommitMsg string `json:"commit-msg"`
+	// Base rev number or tag of the step to compose on; defaults to latest
+	Base  string                 `json:"base"`
+	Parts []ComposePart          `json:"parts"`
+	Meta  map[string]interface{} `json:"meta"`
+}
+
+// CloneTrail payload to clone the steps of a trail onto the trail of another device
+type CloneTrail struct {
+	// Device ID or PRN of the device to clone onto; must have the same owner
+	Device string `json:"device"`
+	// All clone every step instead of only the latest
+	All bool `json:"all"`
+}
+
+// CloneResult revs posted to the target trail by a clone
+type CloneResult struct {
+	TrailID string `json:"trail-id"`
+	// Revs new revs on the target trail, in the order of ClonedRevs
+	Revs       []int `json:"revs"`
+	ClonedRevs []int `json:"cloned-revs"`
+}
+
+// FactoryReset payload to post a step restoring the factory state of a trail
+type FactoryReset struct {
+	CommitMsg string                 `json:"commit-msg"`
+	Meta      map[string]interface{} `json:"meta"`
+}
+
+// FactoryResetResult result of the factory reset of one trail of a fleet
+type FactoryResetResult struct {
+	TrailID string `json:"trail-id"`
+	Rev     int    `json:"rev,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BulkSteps payload to post steps to many trails in one call. State, Release,
+// CommitMsg and Meta are shared by all steps that do not set their own.
+type BulkSteps struct {
+	State     map[string]interface{} `json:"state"`
+	Release   string                 `json:"release"`
+	CommitMsg string                 `json:"commit-msg"`
+	Meta      map[string]interface{} `json:"meta"`
+	Steps     []BulkStep             `json:"steps"`
+	// Atomic post all steps in one transaction: either all or none get posted
+	Atomic bool `json:"atomic"`
+}
+
+// BulkStep step to post to one trail in a bulk posting
+type BulkStep struct {
+	TrailID string `json:"trail-id"`
+	// Rev of the new step; defaults to the next rev of the trail
+	Rev       int                    `json:"rev"`
+	State     map[string]interface{} `json:"state"`
+	Release   string                 `json:"release"`
+	CommitMsg string                 `json:"commit-msg"`
+	// Meta gets merged into the shared meta
+	Meta map[string]interface{} `json:"meta"`
+}
+
+// Release immutable, content addressed state of an owner that steps of
+// many trails can reference instead of storing a copy of the state
+type Release struct {
+	ID    primitive.ObjectID `json:"-" bson:"_id"`
+	Owner string             `json:"owner" bson:"owner"`
+	// Sha state sha of State; identifies the release of an owner
+	Sha         string                 `json:"sha" bson:"sha"`
+	Name        string                 `json:"name" bson:"name"`
+	State       map[string]interface{} `json:"state,omitempty" bson:"state"`
+	UsedObjects []string               `json:"used_objects,omitempty" bson:"used_objects"`
+	Garbage     bool                   `json:"-" bson:"garbage"`
+	TimeCreated time.Time              `json:"time-created" bson:"time-created"`
+}
+
+// StepSignatures result of verifying the pvs signatures of a step state
+type StepSignatures struct {
+	// Verified all signatures of the state verified against a trusted key
+	Verified bool `json:"verified" bson:"verified"`
+	// Complete the state is Verified and every entry but #spec, _sigs/ and
+	// src.json entries is protected by a signature; entries a signature
+	// excludes are not protected by it
+	Complete   bool             `json:"complete" bson:"complete"`
+	Signatures []SignatureCheck `json:"signatures" bson:"signatures"`
+	Uncovered  []string         `json:"uncovered,omitempty" bson:"uncovered,omitempty"`
+	Time       time.Time        `json:"time" bson:"time"`
+}
+
+// SignatureCheck verification result of one _sigs entry of a state
+type SignatureCheck struct {
+	Name      string   `json:"name" bson:"name"`
+	KeyID     string   `json:"key-id,omitempty" bson:"key-id,omitempty"`
+	Verified  bool     `json:"verified" bson:"verified"`
+	Protected []string `json:"protected,omitempty" bson:"protected,omitempty"`
+	Excluded  []string `json:"excluded,omitempty" bson:"excluded,omitempty"`
+	Error     string   `json:"error,omitempty" bson:"error,omitempty"`
 }
 
 // StepProgress progression of a step
@@ -81,6 +267,22 @@ type StepProgress struct {
 	Log       string           `json:"log"`                         // log if available
 }
 
+// StepProgressEntry one progress update of a step as kept in the progress
+// history of the step
+type StepProgressEntry struct {
+	ID           primitive.ObjectID `json:"id" bson:"_id"`
+	StepID       string             `json:"step-id" bson:"step-id"`
+	TrailID      primitive.ObjectID `json:"trail-id" bson:"trail-id"`
+	Rev          int                `json:"rev" bson:"rev"`
+	Progress     StepProgress       `json:"progress" bson:"progress"`
+	ProgressTime time.Time          `json:"progress-time" bson:"progress-time"`
+	// Source who reported the progress: device, owner or system
+	Source string `json:"source" bson:"source"`
+	// TimeCreated when the entry got recorded; entries expire after
+	// PANTAHUB_PROGRESS_HISTORY_TTL_DAYS
+	TimeCreated time.Time `json:"time-created" bson:"time-created"`
+}
+
 // DownloadProgress holds info about total and individual download progress
 type DownloadProgress struct {
 	Total   ObjectProgress   `json:"total" bson:"total"`
@@ -97,26 +299,63 @@ type ObjectProgress struct {
 	TotalDownloaded int64  `json:"total_downloaded" bson:"total_downloaded"`
 }
 
+// StepsProgress aggregated update progress of a set of steps
+type StepsProgress struct {
+	Steps           int            `json:"steps"`
+	Status          map[string]int `json:"status"`
+	TotalBytes      int64          `json:"total-bytes"`
+	DownloadedBytes int64          `json:"downloaded-bytes"`
+	// RemainingBytes bytes still to download by steps not in a final state
+	RemainingBytes int64 `json:"remaining-bytes"`
+	// Throughput bytes per second of the steps currently downloading
+	Throughput float64 `json:"throughput"`
+	// ETA estimated seconds until all remaining bytes got downloaded; -1 if unknown
+	ETA     int64            `json:"eta"`
+	Slowest []DeviceProgress `json:"slowest"`
+}
+
+// DeviceProgress download progress of the step of a device
+type DeviceProgress struct {
+	TrailID         primitive.ObjectID `json:"trail-id" bson:"trail-id"`
+	Device          string             `json:"device" bson:"device"`
+	Rev             int                `json:"rev" bson:"rev"`
+	Status          string             `json:"status" bson:"status"`
+	TotalBytes      int64              `json:"total-bytes" bson:"total-bytes"`
+	DownloadedBytes int64              `json:"downloaded-bytes" bson:"downloaded-bytes"`
+	Throughput      float64            `json:"throughput" bson:"throughput"`
+}
+
+const (
+	// SummaryDatabase database holding the device summaries
+	SummaryDatabase = "pantabase_devicesummary"
+
+	// SummaryCollection collection holding the TrailSummary of every device
+	SummaryCollection = "device_summary_short_new_v2"
+)
+
 // TrailSummary details about a trail
 type TrailSummary struct {
-	DeviceID         string    `json:"deviceid" bson:"deviceid"`
-	Device           string    `json:"device" bson:"device"`
-	DeviceNick       string    `json:"device-nick" bson:"device_nick"`
-	Rev              int       `json:"revision" bson:"revision"`
-	ProgressRev      int       `json:"progress-revision" bson:"progress_revision"`
-	Progress         int       `json:"progress" bson:"progress"` // progress number. steps or 1-100
-	IsPublic         bool      `json:"public" bson:"public"`
-	StateSha         string    `json:"state-sha" bson:"state_sha256"`
-	StatusMsg        string    `json:"status-msg" bson:"status_msg"` // message of progress status
-	Status           string    `json:"status" bson:"status"`         // status code
-	Timestamp        time.Time `json:"timestamp" bson:"timestamp"`   // greater of last seen and last modified
-	StepTime         time.Time `json:"step-time" bson:"step_time"`
-	ProgressTime     time.Time `json:"progress-time" bson:"progress_time"`
-	TrailTouchedTime time.Time `json:"trail-touched-time" bson:"trail_touched_time"`
-	RealIP           string    `json:"real-ip" bson:"real_ip"`
-	FleetGroup       string    `json:"fleet-group" bson:"fleet_group"`
-	FleetModel       string    `json:"fleet-model" bson:"fleet_model"`
-	FleetLocation    string    `json:"fleet-location" bson:"fleet_location"`
-	FleetRev         string    `json:"fleet-rev" bson:"fleet_rev"`
-	Owner            string    `json:"-" bson:"owner"`
+	DeviceID         string     `json:"deviceid" bson:"deviceid"`
+	Device           string     `json:"device" bson:"device"`
+	DeviceNick       string     `json:"device-nick" bson:"device_nick"`
+	Rev              int        `json:"revision" bson:"revision"`
+	ProgressRev      int        `json:"progress-revision" bson:"progress_revision"`
+	Progress         int        `json:"progress" bson:"progress"` // progress number. steps or 1-100
+	IsPublic         bool       `json:"public" bson:"public"`
+	StateSha         string     `json:"state-sha" bson:"state_sha256"`
+	StatusMsg        string     `json:"status-msg" bson:"status_msg"` // message of progress status
+	Status           string     `json:"status" bson:"status"`         // status code
+	Timestamp        time.Time  `json:"timestamp" bson:"timestamp"`   // greater of last seen and last modified
+	StepTime         time.Time  `json:"step-time" bson:"step_time"`
+	ProgressTime     time.Time  `json:"progress-time" bson:"progress_time"`
+	TrailTouchedTime time.Time  `json:"trail-touched-time" bson:"trail_touched_time"`
+	RealIP           string     `json:"real-ip" bson:"real_ip"`
+	FleetGroup       string     `json:"fleet-group" bson:"fleet_group"`
+	FleetModel       string     `json:"fleet-model" bson:"fleet_model"`
+	FleetLocation    string     `json:"fleet-location" bson:"fleet_location"`
+	FleetRev         string     `json:"fleet-rev" bson:"fleet_rev"`
+	Owner            string     `json:"-" bson:"owner"`
+	Garbage          bool       `json:"-" bson:"garbage"`
+	LastRollback     *Rollback  `json:"last-rollback,omitempty" bson:"-"`
+	Queue            *StepQueue `json:"queue,omitempty" bson:"-"`
 }
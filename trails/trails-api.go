@@ -41,7 +41,6 @@
 //   - enusre that in-sync time and status is timely updated based on step and
 //     progress
 //   - find smart way to figure when device is in sync based on reported state
-//   - consider enforcing sequential processing of steps to have a clean tail?
 package trails
 
 import (
@@ -279,14 +278,68 @@ func ProcessObjectsInState(
 	objects []string,
 	err error,
 ) {
-	objectList, err := GetStateObjects(pctx, owner, state, autoLink, a)
+	return processObjectsInState(pctx, owner, state, autoLink, a, nil)
+}
+
+// stateObjects remembers the objects of an owner already resolved and
+// restored, so steps posted in a batch process every object only once
+type stateObjects struct {
+	resolved map[string]string // sha -> storage id
+	restored map[string]bool
+}
+
+// lookup returns the storage id of sha if it got resolved already
+func (c *stateObjects) lookup(sha string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	storageID, ok := c.resolved[sha]
+	return storageID, ok
+}
+
+func newStateObjects() *stateObjects {
+	return &stateObjects{
+		resolved: map[string]string{},
+		restored: map[string]bool{},
+	}
+}
+
+// processObjectsInState is ProcessObjectsInState sharing the work with
+// other states of the same owner through cache, if not nil
+func processObjectsInState(
+	pctx context.Context,
+	owner string,
+	state map[string]interface{},
+	autoLink bool,
+	a *App,
+	cache *stateObjects,
+) (
+	[]string,
+	error,
+) {
+	objectList, err := getStateObjects(pctx, owner, state, autoLink, a, cache)
 	if err != nil {
 		return objectList, err
 	}
-	err = RestoreObjects(pctx, objectList, a)
+
+	restore := objectList
+	if cache != nil {
+		restore = []string{}
+		for _, o := range objectList {
+			if !cache.restored[o] {
+				restore = append(restore, o)
+			}
+		}
+	}
+	err = RestoreObjects(pctx, restore, a)
 	if err != nil {
 		return objectList, err
 	}
+	if cache != nil {
+		for _, o := range restore {
+			cache.restored[o] = true
+		}
+	}
 	return objectList, nil
 }
 
@@ -300,6 +353,20 @@ func GetStateObjects(
 ) (
 	[]string,
 	error,
+) {
+	return getStateObjects(pctx, owner, state, autoLink, a, nil)
+}
+
+func getStateObjects(
+	pctx context.Context,
+	owner string,
+	state map[string]interface{},
+	autoLink bool,
+	a *App,
+	cache *stateObjects,
+) (
+	[]string,
+	error,
 ) {
 	objectList := []string{}
 	objMap := map[string]bool{}
@@ -338,6 +405,13 @@ func GetStateObjects(
 			return nil, fmt.Errorf("state_object: Object is not a string[%s: %s] \n state details: \n %s", key, sha, statejson)
 		}
 
+		if storageID, ok := cache.lookup(sha); ok {
+			if _, ok := objMap[storageID]; !ok {
+				objectList = append(objectList, storageID)
+			}
+			continue
+		}
+
 		ctx := context.WithoutCancel(pctx)
 		object, err := objectsApp.ResolveObjectWithLinks(ctx, owner, sha, autoLink)
 
@@ -352,6 +426,10 @@ func GetStateObjects(
 			return nil, errors.New("Error saving object: " + err.Error())
 		}
 
+		if cache != nil {
+			cache.resolved[sha] = object.StorageID
+		}
+
 		if _, ok := objMap[object.StorageID]; !ok {
 			objectList = append(objectList, object.StorageID)
 		}
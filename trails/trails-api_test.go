@@ -1,6 +1,7 @@
 package trails
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -14,6 +15,7 @@ import (
 	"gitlab.com/pantacor/pantahub-base/testutils"
 	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
 	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"gopkg.in/resty.v1"
 )
 
@@ -151,7 +153,9 @@ func postStep(t *testing.T) {
 	u := *serverURL
 	u.Path = device.ID.Hex() + "/steps"
 
+	// the state has no #spec; it is only about the state sha
 	res, err := resty.R().SetAuthToken(userAuthToken).
+		SetQueryParam("validate", "no").
 		SetBody("{\"rev\": 1, \"state\": {\"mystate\":         \"mystate\"}}").
 		Post(u.String())
 
@@ -218,3 +222,87 @@ func TestTrailsHash(t *testing.T) {
 
 	tearDown(t)
 }
+
+func postReleaseStepIncompatible(t *testing.T) {
+	d := *devicesURL
+	d.Path = "/" + device.ID.Hex() + "/device-meta"
+
+	res, err := resty.R().SetAuthToken(deviceAuthToken).
+		SetBody(map[string]interface{}{"pantavisor.arch": "armv7l/32/EL"}).
+		Put(d.String())
+
+	if err != nil {
+		t.Fatalf("internal error putting device-meta " + err.Error())
+	}
+
+	mongoClient, err := utils.GetMongoClientTest()
+	if err != nil {
+		t.Fatalf("error getting mongoClient (%s)", err.Error())
+	}
+
+	state := map[string]interface{}{
+		"#spec":          "pantavisor-multi-platform@1",
+		CompatibilityKey: map[string]interface{}{"pantavisor.arch": "aarch64/64/EL"},
+	}
+	sha, err := utils.StateSha(&state)
+	if err != nil {
+		t.Fatalf("error calculating state sha " + err.Error())
+	}
+
+	mongoClient.Database(utils.MongoDb).Collection("pantahub_releases").Drop(nil)
+	_, err = mongoClient.Database(utils.MongoDb).Collection("pantahub_releases").InsertOne(context.Background(),
+		trailmodels.Release{
+			ID:          primitive.NewObjectID(),
+			Owner:       device.Owner,
+			Sha:         sha,
+			Name:        "aarch64 only",
+			State:       utils.BsonQuoteMap(&state),
+			UsedObjects: []string{},
+			TimeCreated: time.Now(),
+		})
+	if err != nil {
+		t.Fatalf("error inserting release " + err.Error())
+	}
+
+	u := *serverURL
+	u.Path = device.ID.Hex() + "/steps"
+
+	// the release state only fails the compatibility check
+	res, err = resty.R().SetAuthToken(userAuthToken).
+		SetQueryParam("validate", "no").
+		SetBody(map[string]interface{}{"rev": 1, "release": sha}).
+		Post(u.String())
+
+	if err != nil {
+		t.Fatalf("internal error calling test server " + err.Error())
+	}
+
+	if res.StatusCode() != http.StatusConflict {
+		t.Errorf("posting a release step to an incompatible device must return 409, got %d: %s",
+			res.StatusCode(), string(res.Body()))
+	}
+
+	res, err = resty.R().SetAuthToken(userAuthToken).
+		SetQueryParam("validate", "no").
+		SetQueryParam("compatibility", "no").
+		SetBody(map[string]interface{}{"rev": 1, "release": sha}).
+		Post(u.String())
+
+	if err != nil {
+		t.Fatalf("internal error calling test server " + err.Error())
+	}
+
+	if res.StatusCode() != http.StatusOK {
+		t.Errorf("posting a release step with compatibility=no must return status OK, got %d: %s",
+			res.StatusCode(), string(res.Body()))
+	}
+}
+
+func TestReleaseStepCompatibility(t *testing.T) {
+	setUp(t)
+
+	t.Run("post state", postState)
+	t.Run("post incompatible release step", postReleaseStepIncompatible)
+
+	tearDown(t)
+}
@@ -70,3 +70,47 @@ func (a *App) isTrailPublic(pctx context.Context, trailID string) (bool, error)
 
 	return device.IsPublic, nil
 }
+
+// findStepForRead finds the step trailID-rev if the caller has read access:
+// anyone for public devices, the device itself or its owner otherwise.
+func (a *App) findStepForRead(pctx context.Context, trailID string, rev string, owner interface{}, authType interface{}) (*trailmodels.Step, error) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+	if coll == nil {
+		return nil, errors.New("Error with Database connectivity")
+	}
+
+	isPublic, err := a.isTrailPublic(pctx, trailID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := bson.M{
+		"_id":     trailID + "-" + rev,
+		"garbage": bson.M{"$ne": true},
+	}
+	switch {
+	case isPublic:
+		// everyone can read steps of public devices
+	case authType == "DEVICE":
+		query["device"] = owner
+	case authType == "USER" || authType == "SESSION":
+		query["owner"] = owner
+	default:
+		return nil, errors.New("no access to step")
+	}
+
+	step := &trailmodels.Step{}
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	err = coll.FindOne(ctx, query).Decode(step)
+	if err != nil {
+		return nil, err
+	}
+
+	rerr := a.resolveRelease(pctx, step)
+	if rerr != nil {
+		return nil, errors.New(rerr.Error)
+	}
+
+	return step, nil
+}
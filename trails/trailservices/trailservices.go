@@ -20,12 +20,14 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"gitlab.com/pantacor/pantahub-base/objects"
 	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
 	"gitlab.com/pantacor/pantahub-base/utils"
 	"gitlab.com/pantacor/pvr/libpvr"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"gopkg.in/mgo.v2/bson"
@@ -34,6 +36,9 @@ import (
 type TrailService interface {
 	GetTrailObjectsWithAccess(ctx context.Context, deviceID, rev, owner, authType string, isPublic bool, frags string) (owa []objects.ObjectWithAccess, rerr *utils.RError)
 	GetStepRev(ctx context.Context, trailID string, rev string) (*trailmodels.Step, *utils.RError)
+	ResolveRev(ctx context.Context, trailID string, rev string) (string, *utils.RError)
+	GetRelease(ctx context.Context, owner string, sha string) (*trailmodels.Release, *utils.RError)
+	ResolveRelease(ctx context.Context, step *trailmodels.Step) *utils.RError
 }
 
 type TService struct {
@@ -48,7 +53,88 @@ func CreateService(client *mongo.Client, db string) TrailService {
 	}
 }
 
+// GetRelease finds the release sha of owner; the state stays bson quoted
+func (s *TService) GetRelease(ctx context.Context, owner string, sha string) (*trailmodels.Release, *utils.RError) {
+	ctxi, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	release := &trailmodels.Release{}
+	err := s.db.Collection("pantahub_releases").FindOne(ctxi, bson.M{
+		"owner":   owner,
+		"sha":     sha,
+		"garbage": bson.M{"$ne": true},
+	}).Decode(release)
+	if err == mongo.ErrNoDocuments {
+		return nil, &utils.RError{Error: "release " + sha + " not found", Msg: "release " + sha + " not found", Code: http.StatusNotFound}
+	}
+	if err != nil {
+		return nil, &utils.RError{Error: err.Error(), Msg: "error finding release " + sha, Code: http.StatusInternalServerError}
+	}
+
+	return release, nil
+}
+
+// ResolveRelease fills in the (bson quoted) state and used objects of a step
+// that references a release. Steps without release are left as they are.
+func (s *TService) ResolveRelease(ctx context.Context, step *trailmodels.Step) *utils.RError {
+	if step == nil || step.Release == "" {
+		return nil
+	}
+
+	release, rerr := s.GetRelease(ctx, step.Owner, step.Release)
+	if rerr != nil {
+		return rerr
+	}
+
+	step.State = release.State
+	if len(step.UsedObjects) == 0 {
+		step.UsedObjects = release.UsedObjects
+	}
+
+	return nil
+}
+
+// ResolveRev turns the tag name rev into the rev number it points to on
+// trail trailID. Rev numbers, "latest" and the empty rev are returned as is.
+func (s *TService) ResolveRev(ctx context.Context, trailID string, rev string) (string, *utils.RError) {
+	if rev == "" || rev == "latest" {
+		return rev, nil
+	}
+	if _, err := strconv.Atoi(rev); err == nil {
+		return rev, nil
+	}
+
+	trailObjectID, err := primitive.ObjectIDFromHex(trailID)
+	if err != nil {
+		return "", &utils.RError{Error: err.Error(), Msg: "invalid trail id " + trailID, Code: http.StatusBadRequest}
+	}
+
+	ctxi, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	trail := trailmodels.Trail{}
+	err = s.db.Collection("pantahub_trails").FindOne(ctxi, bson.M{
+		"_id":     trailObjectID,
+		"garbage": bson.M{"$ne": true},
+	}).Decode(&trail)
+	if err != nil {
+		return "", &utils.RError{Error: err.Error(), Msg: "trail not found: " + trailID, Code: http.StatusNotFound}
+	}
+
+	tagRev, ok := trail.Tags[rev]
+	if !ok {
+		return "", &utils.RError{Error: "tag " + rev + " not found", Msg: "tag " + rev + " not found", Code: http.StatusNotFound}
+	}
+
+	return strconv.Itoa(tagRev), nil
+}
+
 func (s *TService) GetStepRev(ctx context.Context, trailID string, rev string) (step *trailmodels.Step, rerr *utils.RError) {
+	rev, rerr = s.ResolveRev(ctx, trailID, rev)
+	if rerr != nil {
+		return step, rerr
+	}
+
 	coll := s.db.Collection("pantahub_steps")
 	ctxi, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -87,6 +173,8 @@ func (s *TService) GetStepRev(ctx context.Context, trailID string, rev string) (
 		return step, rerr
 	}
 
+	rerr = s.ResolveRelease(ctx, step)
+
 	return step, rerr
 }
 
@@ -134,6 +222,11 @@ func (s *TService) GetTrailObjectsWithAccess(
 		return owa, rerr
 	}
 
+	rerr = s.ResolveRelease(ctx, step)
+	if rerr != nil {
+		return owa, rerr
+	}
+
 	owa = make([]objects.ObjectWithAccess, 0)
 	stepState := utils.BsonUnquoteMap(&step.State)
 	stateU := stepState
@@ -0,0 +1,108 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"gitlab.com/pantacor/pantahub-base/trails/statevalidator"
+	"gitlab.com/pantacor/pantahub-base/utils"
+)
+
+// StateValidationError error response for a state that failed validation
+type StateValidationError struct {
+	Error      string                     `json:"error"`
+	Code       int                        `json:"code"`
+	Violations []statevalidator.Violation `json:"violations"`
+}
+
+// StateValidationResult result of a dry-run state validation
+type StateValidationResult struct {
+	Valid      bool                       `json:"valid"`
+	Specs      []string                   `json:"specs"`
+	Violations []statevalidator.Violation `json:"violations"`
+}
+
+// ValidationRequested tells if the state of a request should get validated;
+// clients can opt out with validate=no
+func ValidationRequested(r *rest.Request) bool {
+	validateValue, ok := r.URL.Query()["validate"]
+	return !ok || validateValue[0] != "no"
+}
+
+// StepChecksFromRequest returns the step checks a request asks for
+func StepChecksFromRequest(r *rest.Request) *StepChecks {
+	return &StepChecks{
+		SkipValidation:    !ValidationRequested(r),
+		SkipCompatibility: !CompatibilityRequested(r),
+	}
+}
+
+// writeFailedStepChecks writes the error response for a step that failed
+// checks and tells if it did so
+func writeFailedStepChecks(w rest.ResponseWriter, checks *StepChecks) bool {
+	if len(checks.Violations) > 0 {
+		WriteStateViolations(w, checks.Violations)
+		return true
+	}
+	if len(checks.Incompatibilities) > 0 {
+		WriteIncompatibilities(w, checks.device, checks.Incompatibilities)
+		return true
+	}
+	return false
+}
+
+// WriteStateViolations writes the error response for a state with violations
+func WriteStateViolations(w rest.ResponseWriter, violations []statevalidator.Violation) {
+	w.WriteHeader(http.StatusBadRequest)
+	w.WriteJson(StateValidationError{
+		Error:      "State validation failed",
+		Code:       http.StatusBadRequest,
+		Violations: violations,
+	})
+}
+
+// handlePostValidateState Validate a state without posting it
+// @Summary Validate a state without posting it
+// @Description Run the server side validation for the #spec of the posted state
+// @Description and return all violations found. Nothing gets stored.
+// @Accept  json
+// @Produce  json
+// @Tags trails
+// @Security ApiKeyAuth
+// @Param body body state true "State payload"
+// @Success 200 {object} StateValidationResult
+// @Failure 400 {object} utils.RError
+// @Failure 500 {object} utils.RError
+// @Router /trails/validate [post]
+func (a *App) handlePostValidateState(w rest.ResponseWriter, r *rest.Request) {
+	state := map[string]interface{}{}
+	err := r.DecodeJsonPayload(&state)
+	if err != nil {
+		utils.RestErrorWrapper(w, "Error with request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	violations := statevalidator.Validate(state)
+
+	w.WriteJson(StateValidationResult{
+		Valid:      len(violations) == 0,
+		Specs:      statevalidator.Specs(),
+		Violations: violations,
+	})
+}
@@ -0,0 +1,219 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+	"gitlab.com/pantacor/pantahub-base/utils"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// watchPollInterval how often watch requests check for new steps when
+	// no change stream is available (e.g. mongo is not a replica set)
+	watchPollInterval = 5 * time.Second
+
+	// watchStreamPollInterval safety net check interval while the change
+	// stream is running
+	watchStreamPollInterval = 60 * time.Second
+
+	// watchStreamRetry time to wait before reopening the change stream
+	watchStreamRetry = 30 * time.Second
+)
+
+// stepWatcher wakes up the watch requests of a trail when one of its steps
+// got added or changed
+type stepWatcher struct {
+	mu        sync.Mutex
+	subs      map[string]map[chan struct{}]struct{}
+	streaming int32
+}
+
+// stepWatch is shared by all trails apps of the process so that steps posted
+// by other services (e.g. rollouts) wake up watch requests right away
+var stepWatch = newStepWatcher()
+
+func newStepWatcher() *stepWatcher {
+	return &stepWatcher{
+		subs: map[string]map[chan struct{}]struct{}{},
+	}
+}
+
+// subscribe returns a channel that gets signaled when a step of trailID
+// changes and a func to unsubscribe
+func (sw *stepWatcher) subscribe(trailID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	if sw.subs[trailID] == nil {
+		sw.subs[trailID] = map[chan struct{}]struct{}{}
+	}
+	sw.subs[trailID][ch] = struct{}{}
+
+	return ch, func() {
+		sw.mu.Lock()
+		defer sw.mu.Unlock()
+		delete(sw.subs[trailID], ch)
+		if len(sw.subs[trailID]) == 0 {
+			delete(sw.subs, trailID)
+		}
+	}
+}
+
+// notify wakes up all subscribers of trailID without blocking
+func (sw *stepWatcher) notify(trailID string) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	for ch := range sw.subs[trailID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pollInterval how often subscribers should check for changes themselves
+func (sw *stepWatcher) pollInterval() time.Duration {
+	if atomic.LoadInt32(&sw.streaming) == 1 {
+		return watchStreamPollInterval
+	}
+	return watchPollInterval
+}
+
+// trailIDFromStepID returns the trail id of a step id (<trail-id>-<rev>)
+func trailIDFromStepID(stepID string) string {
+	i := strings.LastIndex(stepID, "-")
+	if i < 0 {
+		return stepID
+	}
+	return stepID[:i]
+}
+
+// watchStepChanges follows the change stream of the steps collection and
+// notifies the watchers of this replica about steps changed by any replica.
+// Without change streams watchers fall back to polling.
+func (a *App) watchStepChanges(ctx context.Context) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"operationType": bson.M{"$in": []string{"insert", "update", "replace"}},
+		}}},
+		{{Key: "$project", Value: bson.M{"documentKey": 1}}},
+	}
+
+	logged := false
+	for {
+		stream, err := coll.Watch(ctx, pipeline, options.ChangeStream())
+		if err != nil && !logged {
+			log.Printf("INFO: step change stream not available, watch requests will poll: %s\n", err.Error())
+			logged = true
+		}
+		if err == nil {
+			atomic.StoreInt32(&stepWatch.streaming, 1)
+			for stream.Next(ctx) {
+				event := struct {
+					DocumentKey struct {
+						ID string `bson:"_id"`
+					} `bson:"documentKey"`
+				}{}
+				if stream.Decode(&event) != nil {
+					continue
+				}
+				stepWatch.notify(trailIDFromStepID(event.DocumentKey.ID))
+			}
+			atomic.StoreInt32(&stepWatch.streaming, 0)
+			if stream.Err() != nil {
+				log.Printf("WARNING: step change stream closed: %s\n", stream.Err().Error())
+			}
+			stream.Close(context.Background())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchStreamRetry):
+		}
+	}
+}
+
+// pendingETag identifies the list of pending steps
+func pendingETag(steps []trailmodels.Step) string {
+	if len(steps) == 0 {
+		return "\"-\""
+	}
+	ids := make([]string, 0, len(steps))
+	for _, step := range steps {
+		ids = append(ids, step.ID)
+	}
+	return "\"" + strings.Join(ids, ",") + "\""
+}
+
+// findPendingSteps returns the steps of trail still to be consumed by the
+// device, as returned by GET /trails/:id/steps for devices
+func (a *App) findPendingSteps(pctx context.Context, trail *trailmodels.Trail, limit int64) ([]trailmodels.Step, error) {
+	coll := a.mongoClient.Database(utils.MongoDb).Collection("pantahub_steps")
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"rev": 1})
+	if limit > 0 {
+		findOptions.SetLimit(limit)
+	}
+
+	ctx, cancel := context.WithTimeout(pctx, 10*time.Second)
+	defer cancel()
+	cur, err := coll.Find(ctx, bson.M{
+		"trail-id":        trail.ID,
+		"progress.status": "NEW",
+		"garbage":         bson.M{"$ne": true},
+	}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	steps := make([]trailmodels.Step, 0)
+	for cur.Next(ctx) {
+		step := trailmodels.Step{}
+		err := cur.Decode(&step)
+		if err != nil {
+			return nil, err
+		}
+		rerr := a.resolveRelease(ctx, &step)
+		if rerr != nil {
+			return nil, errors.New(rerr.Error)
+		}
+		step.Meta = utils.BsonUnquoteMap(&step.Meta)
+		step.State = utils.BsonUnquoteMap(&step.State)
+		steps = append(steps, step)
+	}
+
+	return steps, cur.Err()
+}
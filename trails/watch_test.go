@@ -0,0 +1,66 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package trails
+
+import (
+	"testing"
+	"time"
+
+	"gitlab.com/pantacor/pantahub-base/trails/trailmodels"
+)
+
+func TestStepWatcher(t *testing.T) {
+	sw := newStepWatcher()
+	changed, unsubscribe := sw.subscribe("5c2cc99990cd51000906c218")
+
+	sw.notify("5c2cc99990cd51000906c219")
+	select {
+	case <-changed:
+		t.Fatal("notify() woke up subscriber of other trail")
+	default:
+	}
+
+	// notifications do not block and collapse while not consumed
+	sw.notify("5c2cc99990cd51000906c218")
+	sw.notify("5c2cc99990cd51000906c218")
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("notify() did not wake up subscriber")
+	}
+
+	unsubscribe()
+	if len(sw.subs) != 0 {
+		t.Errorf("unsubscribe() left %d subscriptions", len(sw.subs))
+	}
+}
+
+func TestTrailIDFromStepID(t *testing.T) {
+	if got := trailIDFromStepID("5c2cc99990cd51000906c218-12"); got != "5c2cc99990cd51000906c218" {
+		t.Errorf("trailIDFromStepID() = %s", got)
+	}
+}
+
+func TestPendingETag(t *testing.T) {
+	if got := pendingETag(nil); got != `"-"` {
+		t.Errorf("pendingETag(nil) = %s", got)
+	}
+	steps := []trailmodels.Step{{ID: "a-1"}, {ID: "a-2"}}
+	if got := pendingETag(steps); got != `"a-1,a-2"` {
+		t.Errorf("pendingETag() = %s", got)
+	}
+}
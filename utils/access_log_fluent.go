@@ -70,6 +70,13 @@ func (r *ResponseWriterWrapper) Write(c []byte) (int, error) {
 	return r.responseWriter.Write(c)
 }
 
+// Flush flush the writer if it supports flushing
+func (r *ResponseWriterWrapper) Flush() {
+	if flusher, ok := r.responseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // NewResponseWriterWrapper create a new wrapper for writer
 func NewResponseWriterWrapper(w rest.ResponseWriter) *ResponseWriterWrapper {
 	return &ResponseWriterWrapper{responseWriter: w}
@@ -301,6 +301,24 @@ const (
 	// EnvCronJobTimeout is to set the cron job timeout(secs)
 	EnvCronJobTimeout = "CRON_JOB_TIMEOUT"
 
+	// EnvPantahubProgressHistoryTTLDays days progress history entries of
+	// steps are kept; 0 keeps them forever
+	// default: "180"
+	EnvPantahubProgressHistoryTTLDays = "PANTAHUB_PROGRESS_HISTORY_TTL_DAYS"
+
+	// EnvPantahubSummaryMaterialize maintain the device summaries from mongo
+	// change streams instead of the kafka connect pipelines
+	// default: "false"
+	EnvPantahubSummaryMaterialize = "PANTAHUB_SUMMARY_MATERIALIZE"
+
+	// EnvPantahubBundleSecret RSA key in base64 encoded PEM format to sign
+	// offline update bundles with; signed bundles are not offered if not set
+	EnvPantahubBundleSecret = "PANTAHUB_BUNDLE_SECRET"
+
+	// EnvPantahubBundlePub public key of EnvPantahubBundleSecret in base64
+	// encoded PEM format
+	EnvPantahubBundlePub = "PANTAHUB_BUNDLE_PUB"
+
 	// EnvGoogleOAuthClientID GOOGLE_OAUTH_CLIENT_ID
 	EnvGoogleOAuthClientID = "GOOGLE_OAUTH_CLIENT_ID"
 
@@ -489,6 +507,12 @@ var defaultEnvs = map[string]string{
 	// Cron job timeout(seconds)
 	EnvCronJobTimeout: "300",
 
+	// progress history of steps is kept for half a year
+	EnvPantahubProgressHistoryTTLDays: "180",
+
+	// device summaries get maintained by kafka connect by default
+	EnvPantahubSummaryMaterialize: "false",
+
 	// Oauth CONFIGURATION
 	EnvGoogleOAuthClientID:         "CHANGE THIS",
 	EnvGoogleOAuthClientSecret:     "CHANGE THIS",
@@ -0,0 +1,143 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+// Package jsondiff creates RFC 6902 JSON Patch documents out of two json values
+package jsondiff
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// OpAdd json patch add operation
+	OpAdd = "add"
+
+	// OpRemove json patch remove operation
+	OpRemove = "remove"
+
+	// OpReplace json patch replace operation
+	OpReplace = "replace"
+)
+
+// Operation one RFC 6902 json patch operation
+type Operation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// MarshalJSON keeps null values of add and replace operations
+func (o Operation) MarshalJSON() ([]byte, error) {
+	if o.Op == OpRemove {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	return json.Marshal(struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}{o.Op, o.Path, o.Value})
+}
+
+// Normalize converts v to the plain types encoding/json produces so
+// values coming from different decoders (e.g. bson) compare equal
+func Normalize(v interface{}) (interface{}, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var n interface{}
+	err = json.Unmarshal(buf, &n)
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// EscapePointer escapes one reference token of a RFC 6901 json pointer
+func EscapePointer(token string) string {
+	token = strings.Replace(token, "~", "~0", -1)
+	return strings.Replace(token, "/", "~1", -1)
+}
+
+// Diff returns the operations that turn from into to. Both values are
+// expected to be normalized. Object keys are processed in sorted order
+// so the result is stable; arrays of different length get replaced as a
+// whole.
+func Diff(from, to interface{}) []Operation {
+	return diff("", from, to, []Operation{})
+}
+
+func diff(path string, from, to interface{}, ops []Operation) []Operation {
+	fromMap, fromIsMap := from.(map[string]interface{})
+	toMap, toIsMap := to.(map[string]interface{})
+	if fromIsMap && toIsMap {
+		return diffMaps(path, fromMap, toMap, ops)
+	}
+
+	fromSlice, fromIsSlice := from.([]interface{})
+	toSlice, toIsSlice := to.([]interface{})
+	if fromIsSlice && toIsSlice && len(fromSlice) == len(toSlice) {
+		for i := range fromSlice {
+			ops = diff(path+"/"+strconv.Itoa(i), fromSlice[i], toSlice[i], ops)
+		}
+		return ops
+	}
+
+	if reflect.DeepEqual(from, to) {
+		return ops
+	}
+
+	return append(ops, Operation{Op: OpReplace, Path: path, Value: to})
+}
+
+func diffMaps(path string, from, to map[string]interface{}, ops []Operation) []Operation {
+	keys := make([]string, 0, len(from)+len(to))
+	for k := range from {
+		keys = append(keys, k)
+	}
+	for k := range to {
+		if _, ok := from[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "/" + EscapePointer(k)
+		fromValue, inFrom := from[k]
+		toValue, inTo := to[k]
+
+		switch {
+		case inFrom && !inTo:
+			ops = append(ops, Operation{Op: OpRemove, Path: p})
+		case !inFrom && inTo:
+			ops = append(ops, Operation{Op: OpAdd, Path: p, Value: toValue})
+		default:
+			ops = diff(p, fromValue, toValue, ops)
+		}
+	}
+
+	return ops
+}
@@ -0,0 +1,127 @@
+//
+// Copyright (c) 2017-2023 Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+//
+
+package jsondiff
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func mustUnmarshal(t *testing.T, s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want []Operation
+	}{
+		{
+			name: "equal",
+			from: `{"a": 1, "b": {"c": [1, 2]}}`,
+			to:   `{"a": 1, "b": {"c": [1, 2]}}`,
+			want: []Operation{},
+		},
+		{
+			name: "add remove replace sorted",
+			from: `{"b": 1, "c": "x"}`,
+			to:   `{"a": true, "c": "y"}`,
+			want: []Operation{
+				{Op: OpAdd, Path: "/a", Value: true},
+				{Op: OpRemove, Path: "/b"},
+				{Op: OpReplace, Path: "/c", Value: "y"},
+			},
+		},
+		{
+			name: "nested and escaped keys",
+			from: `{"app/run.json": {"exec": "/bin/a", "a~b": 1}}`,
+			to:   `{"app/run.json": {"exec": "/bin/b", "a~b": 1}}`,
+			want: []Operation{
+				{Op: OpReplace, Path: "/app~1run.json/exec", Value: "/bin/b"},
+			},
+		},
+		{
+			name: "same length arrays diff by index",
+			from: `{"l": [1, 2, 3]}`,
+			to:   `{"l": [1, 5, 3]}`,
+			want: []Operation{
+				{Op: OpReplace, Path: "/l/1", Value: float64(5)},
+			},
+		},
+		{
+			name: "different length arrays get replaced",
+			from: `{"l": [1, 2]}`,
+			to:   `{"l": [1]}`,
+			want: []Operation{
+				{Op: OpReplace, Path: "/l", Value: []interface{}{float64(1)}},
+			},
+		},
+		{
+			name: "type change",
+			from: `{"a": {"b": 1}}`,
+			to:   `{"a": "b"}`,
+			want: []Operation{
+				{Op: OpReplace, Path: "/a", Value: "b"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(mustUnmarshal(t, tt.from), mustUnmarshal(t, tt.to))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperation_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		op   Operation
+		want string
+	}{
+		{
+			name: "remove has no value",
+			op:   Operation{Op: OpRemove, Path: "/a"},
+			want: `{"op":"remove","path":"/a"}`,
+		},
+		{
+			name: "replace keeps null",
+			op:   Operation{Op: OpReplace, Path: "/a"},
+			want: `{"op":"replace","path":"/a","value":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.op)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MarshalJSON() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,475 @@
+// Copyright 2024  Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+
+package querymongo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FilterMaxLength maximum length of a filter expression
+const FilterMaxLength = 4096
+
+// FieldType type of the values of a filter field
+type FieldType int
+
+const (
+	// FilterString text values; supports = != < <= > >= ^= and in
+	FilterString FieldType = iota
+	// FilterInt integer values; supports = != < <= > >= and in
+	FilterInt
+	// FilterBool true/false values; supports = != and in
+	FilterBool
+	// FilterTime RFC3339 times or now, now-<duration>, now+<duration> with
+	// durations like 90s, 15m, 24h or 7d; supports = != < <= > >= and in
+	FilterTime
+	// FilterAny values of unknown type, like the ones of map keys; = != and
+	// in match the text as well as the number or boolean it reads as,
+	// < <= > >= compare numbers if the value is one; supports ^= too
+	FilterAny
+)
+
+// FilterField a field that can be used in filters
+type FilterField struct {
+	// Key mongo key of the field
+	Key  string
+	Type FieldType
+}
+
+// FilterSchema the fields a filter can use. Fields maps the filter names to
+// mongo fields; Maps allows any key below the given prefixes (e.g.
+// "user-meta.") as FilterAny field, mapped to the mongo prefix it points to.
+type FilterSchema struct {
+	Fields map[string]FilterField
+	Maps   map[string]string
+}
+
+// field returns the field for name
+func (s *FilterSchema) field(name string) (FilterField, error) {
+	if f, ok := s.Fields[name]; ok {
+		return f, nil
+	}
+	for prefix, key := range s.Maps {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			sub := strings.TrimPrefix(name, prefix)
+			return FilterField{
+				Key:  key + strings.Replace(sub, ".", "\uFF2E", -1),
+				Type: FilterAny,
+			}, nil
+		}
+	}
+	return FilterField{}, fmt.Errorf("unknown filter field %q", name)
+}
+
+// ParseFilter parses the filter expression s and compiles it to a mongo
+// query. Only fields of schema can be used.
+//
+// A filter is a list of comparisons combined with and, or, not and
+// parentheses:
+//
+//	fleet-group = lab and status in (DONE, ERROR) and timestamp >= now-24h
+//	device-nick ^= "happy" or not (progress < 100)
+//
+// Comparisons are field op value with op one of = != < <= > >= ^= (prefix),
+// or field in (value, ...). Values with spaces or special characters need
+// double quotes. For compatibility a JSON object of field: value pairs
+// is accepted as well, matching all of them.
+func ParseFilter(s string, schema *FilterSchema) (bson.M, error) {
+	return parseFilter(s, schema, time.Now())
+}
+
+func parseFilter(s string, schema *FilterSchema, now time.Time) (bson.M, error) {
+	if len(s) > FilterMaxLength {
+		return nil, fmt.Errorf("filter longer than %d characters", FilterMaxLength)
+	}
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		return parseJSONFilter(s, schema, now)
+	}
+
+	tokens, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens, schema: schema, now: now}
+	query, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected %q in filter", p.peek().text)
+	}
+	return query, nil
+}
+
+// parseJSONFilter compiles a JSON object of field: value equality pairs
+func parseJSONFilter(s string, schema *FilterSchema, now time.Time) (bson.M, error) {
+	pairs := map[string]interface{}{}
+	err := json.Unmarshal([]byte(s), &pairs)
+	if err != nil {
+		return nil, errors.New("invalid JSON filter: " + err.Error())
+	}
+
+	names := make([]string, 0, len(pairs))
+	for name := range pairs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	conditions := []bson.M{}
+	for _, name := range names {
+		switch pairs[name].(type) {
+		case string, float64, bool:
+		default:
+			return nil, fmt.Errorf("JSON filter value of %q must be a string, number or boolean", name)
+		}
+		condition, err := compileComparison(schema, name, "=", []string{fmt.Sprint(pairs[name])}, now)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	return combineFilter("$and", conditions), nil
+}
+
+type filterTokenKind int
+
+const (
+	tokenWord filterTokenKind = iota
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type filterToken struct {
+	kind filterTokenKind
+	text string
+}
+
+// tokenizeFilter splits s in words, quoted strings, operators, parentheses
+// and commas
+func tokenizeFilter(s string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, filterToken{tokenLParen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, filterToken{tokenRParen, ")"})
+			i++
+		case c == ',':
+			tokens = append(tokens, filterToken{tokenComma, ","})
+			i++
+		case c == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, errors.New("unterminated string in filter")
+			}
+			i++
+			tokens = append(tokens, filterToken{tokenString, b.String()})
+		case strings.ContainsRune("=!<>^", c):
+			op := string(c)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			switch op {
+			case "=", "!=", "<", "<=", ">", ">=", "^=":
+			default:
+				return nil, fmt.Errorf("invalid operator %q in filter", op)
+			}
+			tokens = append(tokens, filterToken{tokenOp, op})
+			i += len(op)
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("(),\"=!<>^", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{tokenWord, string(runes[start:i])})
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	schema *FilterSchema
+	now    time.Time
+}
+
+func (p *filterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *filterParser) peek() filterToken {
+	if p.done() {
+		return filterToken{kind: -1}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+// keyword tells if the next token is the keyword kw
+func (p *filterParser) keyword(kw string) bool {
+	t := p.peek()
+	return t.kind == tokenWord && strings.EqualFold(t.text, kw)
+}
+
+func (p *filterParser) parseOr() (bson.M, error) {
+	return p.parseList("or", "$or", p.parseAnd)
+}
+
+func (p *filterParser) parseAnd() (bson.M, error) {
+	return p.parseList("and", "$and", p.parseUnary)
+}
+
+// parseList parses terms separated by the keyword kw, combined with op
+func (p *filterParser) parseList(kw string, op string, term func() (bson.M, error)) (bson.M, error) {
+	first, err := term()
+	if err != nil {
+		return nil, err
+	}
+	conditions := []bson.M{first}
+	for p.keyword(kw) {
+		p.next()
+		condition, err := term()
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+	return combineFilter(op, conditions), nil
+}
+
+func (p *filterParser) parseUnary() (bson.M, error) {
+	switch {
+	case p.keyword("not"):
+		p.next()
+		condition, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$nor": []bson.M{condition}}, nil
+	case p.peek().kind == tokenLParen:
+		p.next()
+		condition, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenRParen {
+			return nil, errors.New("missing ) in filter")
+		}
+		return condition, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (bson.M, error) {
+	field := p.next()
+	if field.kind != tokenWord {
+		return nil, errors.New("expected field name in filter")
+	}
+
+	if p.keyword("in") {
+		p.next()
+		if p.next().kind != tokenLParen {
+			return nil, fmt.Errorf("expected ( after %s in", field.text)
+		}
+		values := []string{}
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			t := p.next()
+			if t.kind == tokenRParen {
+				break
+			}
+			if t.kind != tokenComma {
+				return nil, fmt.Errorf("expected , or ) in values of %s", field.text)
+			}
+		}
+		return compileComparison(p.schema, field.text, "in", values, p.now)
+	}
+
+	op := p.next()
+	if op.kind != tokenOp {
+		return nil, fmt.Errorf("expected operator after %s", field.text)
+	}
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	return compileComparison(p.schema, field.text, op.text, []string{value}, p.now)
+}
+
+func (p *filterParser) parseValue() (string, error) {
+	t := p.next()
+	if t.kind != tokenWord && t.kind != tokenString {
+		return "", errors.New("expected value in filter")
+	}
+	return t.text, nil
+}
+
+// combineFilter combines conditions with op ($and, $or) unless there is only one
+func combineFilter(op string, conditions []bson.M) bson.M {
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	return bson.M{op: conditions}
+}
+
+var filterMongoOps = map[string]string{
+	"!=": "$ne",
+	"<":  "$lt",
+	"<=": "$lte",
+	">":  "$gt",
+	">=": "$gte",
+}
+
+// compileComparison compiles the comparison of field name with values
+func compileComparison(schema *FilterSchema, name string, op string, values []string, now time.Time) (bson.M, error) {
+	field, err := schema.field(name)
+	if err != nil {
+		return nil, err
+	}
+	if field.Type == FilterAny {
+		return compileAnyComparison(field.Key, op, values), nil
+	}
+
+	typed := make([]interface{}, len(values))
+	for i, v := range values {
+		typed[i], err = filterValue(field.Type, v, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for %s: %s", v, name, err.Error())
+		}
+	}
+
+	switch op {
+	case "=":
+		return bson.M{field.Key: typed[0]}, nil
+	case "in":
+		return bson.M{field.Key: bson.M{"$in": typed}}, nil
+	case "^=":
+		if field.Type != FilterString {
+			return nil, fmt.Errorf("prefix match not supported for %s", name)
+		}
+		return bson.M{field.Key: bson.M{"$regex": "^" + regexp.QuoteMeta(values[0])}}, nil
+	case "!=":
+	default:
+		if field.Type == FilterBool {
+			return nil, fmt.Errorf("operator %s not supported for %s", op, name)
+		}
+	}
+	return bson.M{field.Key: bson.M{filterMongoOps[op]: typed[0]}}, nil
+}
+
+// compileAnyComparison compiles the comparison of the FilterAny field key
+// with values
+func compileAnyComparison(key string, op string, values []string) bson.M {
+	switch op {
+	case "=", "in":
+		candidates := []interface{}{}
+		for _, v := range values {
+			candidates = append(candidates, anyValues(v)...)
+		}
+		return bson.M{key: bson.M{"$in": candidates}}
+	case "!=":
+		return bson.M{key: bson.M{"$nin": anyValues(values[0])}}
+	case "^=":
+		return bson.M{key: bson.M{"$regex": "^" + regexp.QuoteMeta(values[0])}}
+	}
+	if f, err := strconv.ParseFloat(values[0], 64); err == nil {
+		return bson.M{key: bson.M{filterMongoOps[op]: f}}
+	}
+	return bson.M{key: bson.M{filterMongoOps[op]: values[0]}}
+}
+
+// anyValues returns the values v can stand for: the text and the number or
+// boolean it reads as
+func anyValues(v string) []interface{} {
+	values := []interface{}{v}
+	if f, err := strconv.ParseFloat(v, 64); err == nil {
+		values = append(values, f)
+	}
+	if v == "true" || v == "false" {
+		values = append(values, v == "true")
+	}
+	return values
+}
+
+// filterValue converts v to a value of type t
+func filterValue(t FieldType, v string, now time.Time) (interface{}, error) {
+	switch t {
+	case FilterInt:
+		return strconv.ParseInt(v, 10, 64)
+	case FilterBool:
+		return strconv.ParseBool(v)
+	case FilterTime:
+		return filterTime(v, now)
+	}
+	return v, nil
+}
+
+// filterTime parses RFC3339 times and now relative times like now-24h or now-7d
+func filterTime(v string, now time.Time) (time.Time, error) {
+	if !strings.HasPrefix(v, "now") {
+		return time.Parse(time.RFC3339, v)
+	}
+	offset := strings.TrimPrefix(v, "now")
+	if offset == "" {
+		return now, nil
+	}
+	if strings.HasSuffix(offset, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(offset, "d"))
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.AddDate(0, 0, days), nil
+	}
+	d, err := time.ParseDuration(offset)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return now.Add(d), nil
+}
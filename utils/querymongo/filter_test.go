@@ -0,0 +1,106 @@
+// Copyright 2024  Pantacor Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+//	Unless required by applicable law or agreed to in writing, software
+//	distributed under the License is distributed on an "AS IS" BASIS,
+//	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//	See the License for the specific language governing permissions and
+//	limitations under the License.
+
+package querymongo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var testFilterSchema = &FilterSchema{
+	Fields: map[string]FilterField{
+		"fleet-group": {Key: "fleet_group", Type: FilterString},
+		"status":      {Key: "status", Type: FilterString},
+		"progress":    {Key: "progress", Type: FilterInt},
+		"public":      {Key: "public", Type: FilterBool},
+		"timestamp":   {Key: "timestamp", Type: FilterTime},
+	},
+	Maps: map[string]string{
+		"user-meta.": "user-meta.",
+	},
+}
+
+func TestParseFilter(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		filter string
+		want   bson.M
+	}{
+		{"equal", `fleet-group = lab`, bson.M{"fleet_group": "lab"}},
+		{"quoted", `status != "not done"`, bson.M{"status": bson.M{"$ne": "not done"}}},
+		{"int range", `progress >= 10 and progress < 100`, bson.M{"$and": []bson.M{
+			{"progress": bson.M{"$gte": int64(10)}},
+			{"progress": bson.M{"$lt": int64(100)}},
+		}}},
+		{"in", `status in (DONE, "ERROR")`, bson.M{"status": bson.M{"$in": []interface{}{"DONE", "ERROR"}}}},
+		{"prefix", `fleet-group ^= "a.b"`, bson.M{"fleet_group": bson.M{"$regex": `^a\.b`}}},
+		{"relative time", `timestamp >= now-7d`, bson.M{"timestamp": bson.M{"$gte": now.AddDate(0, 0, -7)}}},
+		{"absolute time", `timestamp < 2023-01-02T03:04:05Z`, bson.M{"timestamp": bson.M{
+			"$lt": time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)}}},
+		{"or not parens", `public = true OR not (status = DONE and progress = 100)`, bson.M{"$or": []bson.M{
+			{"public": true},
+			{"$nor": []bson.M{{"$and": []bson.M{{"status": "DONE"}, {"progress": int64(100)}}}}},
+		}}},
+		{"map field", `user-meta.site.name = berlin`, bson.M{"user-meta.site\uFF2Ename": bson.M{
+			"$in": []interface{}{"berlin"}}}},
+		{"map field bool", `user-meta.canary = true`, bson.M{"user-meta.canary": bson.M{
+			"$in": []interface{}{"true", true}}}},
+		{"map field number", `user-meta.batch != 3`, bson.M{"user-meta.batch": bson.M{
+			"$nin": []interface{}{"3", float64(3)}}}},
+		{"map field range", `user-meta.batch >= 2`, bson.M{"user-meta.batch": bson.M{"$gte": float64(2)}}},
+		{"json", `{"status": "DONE", "progress": 100}`, bson.M{"$and": []bson.M{
+			{"progress": int64(100)},
+			{"status": "DONE"},
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFilter(tt.filter, testFilterSchema, now)
+			if err != nil {
+				t.Fatalf("unexpected error %s", err.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		`owner = me`,
+		`progress = many`,
+		`public > true`,
+		`progress ^= 1`,
+		`status = `,
+		`status in (DONE`,
+		`(status = DONE`,
+		`status = DONE progress = 1`,
+		`status == DONE`,
+		`status = "DONE`,
+		`{"status": {"$ne": "DONE"}}`,
+		`{"$where": "1"}`,
+	} {
+		if _, err := ParseFilter(filter, testFilterSchema); err == nil {
+			t.Errorf("expected error for filter %s", filter)
+		}
+	}
+}
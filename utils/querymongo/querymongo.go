@@ -348,13 +348,51 @@ func processValue(v string) interface{} {
 }
 
 func GetPaginationWithLink(u url.URL, total int64, last, first models.DatableSimple) Pagination {
+	result, newURL, size := newPagination(u, total)
+
+	if u.Query().Get("page[offset]") != "" {
+		setOffsetLinks(&result, u, newURL, size)
+		return result
+	}
+
+	finishTimestamp := last.GetCreatedAt().Format(time.RFC3339)
+	startTimetamp := first.GetCreatedAt().Format(time.RFC3339)
+
+	prevURL := *newURL
+	prevQuery := prevURL.Query()
+	prevQuery.Set("page[size]", size)
+	prevQuery.Set("page[before]", startTimetamp)
+	prevURL.RawQuery = prevQuery.Encode()
+	result.Prev = prevURL.String()
+
+	if int(result.Total) >= result.PageSize {
+		nextURL := *newURL
+		nextQuery := nextURL.Query()
+		nextQuery.Set("page[size]", size)
+		nextQuery.Set("page[after]", finishTimestamp)
+		nextURL.RawQuery = nextQuery.Encode()
+		result.Next = nextURL.String()
+	}
+
+	return result
+}
+
+// GetOffsetPagination get the pagination of a resource paginated by
+// page[offset] and page[size] with total elements
+func GetOffsetPagination(u url.URL, total int64) Pagination {
+	result, newURL, size := newPagination(u, total)
+	setOffsetLinks(&result, u, newURL, size)
+	return result
+}
+
+// newPagination creates the pagination of u without links. It returns the
+// url of the resource including the query and the page size
+func newPagination(u url.URL, total int64) (Pagination, *url.URL, string) {
 	result := Pagination{
 		Total:     int(total),
 		PageSizes: []int{10, 20, 30, 50, 100},
 	}
 
-	finishTimestamp := last.GetCreatedAt().Format(time.RFC3339)
-	startTimetamp := first.GetCreatedAt().Format(time.RFC3339)
 	size := u.Query().Get("page[size]")
 	if size == "" {
 		size = strconv.Itoa(DefaultPageSize)
@@ -383,56 +421,42 @@ func GetPaginationWithLink(u url.URL, total int64, last, first models.DatableSim
 
 	newURL.RawQuery = u.Query().Encode()
 
-	if u.Query().Get("page[offset]") == "" {
-		prevURL := *newURL
-		prevQuery := prevURL.Query()
-		prevQuery.Set("page[size]", size)
-		prevQuery.Set("page[before]", startTimetamp)
-		prevURL.RawQuery = prevQuery.Encode()
-		result.Prev = prevURL.String()
-
-		if int(result.Total) >= sizeInt {
-			nextURL := *newURL
-			nextQuery := nextURL.Query()
-			nextQuery.Set("page[size]", size)
-			nextQuery.Set("page[after]", finishTimestamp)
-			nextURL.RawQuery = nextQuery.Encode()
-			result.Next = nextURL.String()
-		}
-	} else {
-		offset, err := strconv.Atoi(u.Query().Get("page[offset]"))
-		if err != nil {
-			offset = 0
-		}
+	return result, newURL, size
+}
 
-		current := offset / sizeInt
+// setOffsetLinks sets the offset and the prev and next links of result
+func setOffsetLinks(result *Pagination, u url.URL, newURL *url.URL, size string) {
+	sizeInt := result.PageSize
+	offset, err := strconv.Atoi(u.Query().Get("page[offset]"))
+	if err != nil {
+		offset = 0
+	}
 
-		result.PageOffset = offset
-		result.CurrentPage = current + 1
+	current := offset / sizeInt
 
-		prevURL := *newURL
-		prevQuery := prevURL.Query()
+	result.PageOffset = offset
+	result.CurrentPage = current + 1
 
-		prevOffsetInt := offset - sizeInt
-		if prevOffsetInt >= 0 {
-			prevQuery.Set("page[offset]", strconv.Itoa(prevOffsetInt))
-		}
-		prevQuery.Set("page[size]", size)
-		prevURL.RawQuery = prevQuery.Encode()
-		result.Prev = prevURL.String()
+	prevURL := *newURL
+	prevQuery := prevURL.Query()
 
-		if int(result.Total) >= sizeInt*current {
-			nextOffset := strconv.Itoa(offset + sizeInt)
+	prevOffsetInt := offset - sizeInt
+	if prevOffsetInt >= 0 {
+		prevQuery.Set("page[offset]", strconv.Itoa(prevOffsetInt))
+	}
+	prevQuery.Set("page[size]", size)
+	prevURL.RawQuery = prevQuery.Encode()
+	result.Prev = prevURL.String()
 
-			nextURL := *newURL
-			nextQuery := nextURL.Query()
-			nextQuery.Set("page[size]", size)
-			nextQuery.Set("page[offset]", nextOffset)
-			nextURL.RawQuery = nextQuery.Encode()
+	if int(result.Total) >= sizeInt*current {
+		nextOffset := strconv.Itoa(offset + sizeInt)
 
-			result.Next = nextURL.String()
-		}
-	}
+		nextURL := *newURL
+		nextQuery := nextURL.Query()
+		nextQuery.Set("page[size]", size)
+		nextQuery.Set("page[offset]", nextOffset)
+		nextURL.RawQuery = nextQuery.Encode()
 
-	return result
+		result.Next = nextURL.String()
+	}
 }
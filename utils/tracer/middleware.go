@@ -111,6 +111,13 @@ func (w *tracerResponseWriter) Count() uint64 {
 	return w.writer.Count()
 }
 
+// Flush the writer if it supports flushing (e.g. for server sent events)
+func (w *tracerResponseWriter) Flush() {
+	if flusher, ok := w.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 // GetTraceHeaderFromJaeger conver uber-trace-id header to traceparent
 // here you can read more about those formats:
 //